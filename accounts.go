@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+const (
+	sessionTokenBytes = 32
+	sessionTTL        = 24 * time.Hour
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 20
+)
+
+// validateUsername allows 3 to 20 letters, digits, underscores and hyphens.
+// Names starting with the member ID prefix are refused, since /user/{id}
+// would take them for IDs.
+func validateUsername(username string) error {
+	if len(username) < minUsernameLength || len(username) > maxUsernameLength {
+		return fmt.Errorf("username must be %d to %d characters", minUsernameLength, maxUsernameLength)
+	}
+	for _, c := range username {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+		default:
+			return fmt.Errorf("username may only contain letters, digits, underscores and hyphens")
+		}
+	}
+	if strings.HasPrefix(strings.ToLower(username), MemberIDPrefix) {
+		return fmt.Errorf("username may not start with %q", MemberIDPrefix)
+	}
+	return nil
+}
+
+// dummyPasswordHash is verified against when a login names an unknown user,
+// so the response time does not reveal which usernames exist.
+var dummyPasswordHash, _ = hashPassword("")
+
+// addMember is the part of RegisterMember that changes state. Registration
+// hashes the password first, so the plaintext never reaches the journal.
+// MemberID is set when a cluster node copies a registration to the others,
+// so every node knows the member by the same ID.
+type addMember struct {
+	Username     string
+	PasswordHash string
+	MemberID     string `json:",omitempty"`
+}
+
+// openSession is the part of Login left once the password has been checked.
+type openSession struct {
+	MemberID string
+}
+
+// answerLater lets work done off the actor answer the sender of the message
+// being handled, or pass its result back to the engine as a new command from
+// that sender.
+type answerLater struct {
+	root   *actor.RootContext
+	engine *actor.PID
+	sender *actor.PID
+}
+
+func (engine *CommunityEngine) answerLater(context actor.Context) *answerLater {
+	return &answerLater{root: context.ActorSystem().Root, engine: context.Self(), sender: context.Sender()}
+}
+
+func (later *answerLater) respond(response interface{}) {
+	if later.sender != nil {
+		later.root.Send(later.sender, response)
+	}
+}
+
+func (later *answerLater) fail(code ErrorCode, reason string) {
+	later.respond(&CommandFailed{Code: code, Reason: reason})
+}
+
+func (later *answerLater) submit(command interface{}) {
+	later.root.RequestWithCustomSender(later.engine, command, later.sender)
+}
+
+// backgroundWork counts the password checks running off the engine actor.
+// Each one ends by passing its result to the engine, so shutdown stops it
+// taking new work and waits for the running work before stopping the
+// engine, or the results would be lost.
+type backgroundWork struct {
+	lock    sync.Mutex
+	stopped bool
+	running sync.WaitGroup
+}
+
+func (work *backgroundWork) start() bool {
+	work.lock.Lock()
+	defer work.lock.Unlock()
+	if work.stopped {
+		return false
+	}
+	work.running.Add(1)
+	return true
+}
+
+// stop refuses new work and waits for the running work to finish.
+func (work *backgroundWork) stop() {
+	work.lock.Lock()
+	work.stopped = true
+	work.lock.Unlock()
+	work.running.Wait()
+}
+
+// offload runs work on a goroutine of its own, which answers through later.
+// Once shutdown has started it refuses the work instead.
+func (engine *CommunityEngine) offload(context actor.Context, work func(later *answerLater)) {
+	if !engine.background.start() {
+		engine.fail(context, ErrUnavailable, "The engine is shutting down")
+		return
+	}
+	later := engine.answerLater(context)
+	go func() {
+		defer engine.background.running.Done()
+		work(later)
+	}()
+}
+
+// registerMember hashes the password on a goroutine of its own, since that
+// takes long enough to hold up every other command, then adds the member.
+func (engine *CommunityEngine) registerMember(context actor.Context, msg *RegisterMember) {
+	if msg.Username == "" || msg.Password == "" {
+		engine.fail(context, ErrInvalidRequest, "Username and password are required")
+		return
+	}
+	if err := validateUsername(msg.Username); err != nil {
+		engine.fail(context, ErrInvalidUsername, err.Error())
+		return
+	}
+	if _, taken := engine.memberByUsername(msg.Username); taken {
+		fmt.Printf("[Engine] Failed to register member: Username=%s already taken\n", msg.Username)
+		engine.fail(context, ErrUsernameTaken, "Username is already taken")
+		return
+	}
+	engine.offload(context, func(later *answerLater) {
+		passwordHash, err := hashPassword(msg.Password)
+		if err != nil {
+			fmt.Printf("[Engine] Failed to hash password: %v\n", err)
+			later.fail(ErrInvalidRequest, "Could not register member")
+			return
+		}
+		later.submit(&addMember{Username: msg.Username, PasswordHash: passwordHash})
+	})
+}
+
+func (engine *CommunityEngine) addMember(context actor.Context, msg *addMember) {
+	usernameKey := strings.ToLower(msg.Username)
+	if existingID, taken := engine.usernames[usernameKey]; taken {
+		// A cluster node copying the registration again, not having heard
+		// back the first time, finds it already done
+		if msg.MemberID != "" && msg.MemberID == existingID {
+			member := engine.members[existingID]
+			engine.respond(context, &MemberRegistered{MemberID: member.ID, CreatedAt: member.CreatedAt})
+			return
+		}
+		fmt.Printf("[Engine] Failed to register member: Username=%s already taken\n", msg.Username)
+		engine.fail(context, ErrUsernameTaken, "Username is already taken")
+		return
+	}
+	if !engine.accept(context, msg) {
+		return
+	}
+	memberID := msg.MemberID
+	if memberID == "" {
+		memberID = engine.newID(context, MemberIDPrefix)
+	}
+	member := &Member{
+		ID:           memberID,
+		Username:     msg.Username,
+		PasswordHash: msg.PasswordHash,
+		Karma:        0,
+		CreatedAt:    engine.now(context),
+	}
+	if !engine.persist(context, engine.store.PutMember(member)) {
+		return
+	}
+	engine.members[memberID] = member
+	engine.memberShards[memberID] = newMemberShard(engine.engineBase, memberID)
+	engine.usernames[usernameKey] = memberID
+	fmt.Printf("[Engine] New member registered: Username=%s, ID=%s\n", msg.Username, memberID)
+	engine.respond(context, &MemberRegistered{MemberID: memberID, CreatedAt: member.CreatedAt})
+}
+
+// memberByUsername looks a member up case-insensitively.
+func (engine *CommunityEngine) memberByUsername(username string) (*Member, bool) {
+	member, exists := engine.members[engine.usernames[strings.ToLower(username)]]
+	return member, exists
+}
+
+// memberByIDOrUsername accepts either form of member reference.
+func (engine *CommunityEngine) memberByIDOrUsername(idOrUsername string) (*Member, bool) {
+	if member, exists := engine.members[idOrUsername]; exists {
+		return member, true
+	}
+	return engine.memberByUsername(idOrUsername)
+}
+
+func (engine *CommunityEngine) fetchProfile(context actor.Context, msg *FetchProfile) {
+	member, exists := engine.members[msg.MemberID]
+	if !exists {
+		member, exists = engine.memberByUsername(msg.Username)
+	}
+	if !exists {
+		engine.fail(context, ErrMemberNotFound, "Member not found")
+		return
+	}
+	profile := &MemberProfile{
+		ID:          member.ID,
+		Username:    member.Username,
+		Karma:       member.Karma,
+		ThreadCount: member.ThreadCount,
+		ReplyCount:  member.ReplyCount,
+		JoinedAt:    member.CreatedAt,
+	}
+	engine.respond(context, profile)
+}
+
+// login checks the password on a goroutine of its own, against the stored
+// hash, then opens the session.
+func (engine *CommunityEngine) login(context actor.Context, msg *Login) {
+	member, exists := engine.memberByUsername(msg.Username)
+	passwordHash := dummyPasswordHash
+	if exists {
+		passwordHash = member.PasswordHash
+	}
+
+	engine.offload(context, func(later *answerLater) {
+		if !verifyPassword(passwordHash, msg.Password) || !exists {
+			fmt.Printf("[Engine] Failed login: Username=%s\n", msg.Username)
+			later.fail(ErrInvalidCredentials, "Invalid username or password")
+			return
+		}
+		later.submit(&openSession{MemberID: member.ID})
+	})
+}
+
+func (engine *CommunityEngine) openSession(context actor.Context, msg *openSession) {
+	token, err := newSessionToken(msg.MemberID)
+	if err != nil {
+		fmt.Printf("[Engine] Failed to create session: %v\n", err)
+		engine.fail(context, ErrInvalidRequest, "Could not create session")
+		return
+	}
+	now := time.Now()
+	session := &Session{MemberID: msg.MemberID, CreatedAt: now, ExpiresAt: now.Add(sessionTTL)}
+	member, exists := engine.members[msg.MemberID]
+	if !exists {
+		engine.fail(context, ErrInvalidCredentials, "Invalid username or password")
+		return
+	}
+	engine.pruneSessions(now)
+	engine.sessions[sessionKey(token)] = session
+
+	fmt.Printf("[Engine] Member logged in: Username=%s, ID=%s\n", member.Username, member.ID)
+	engine.respond(context, &LoginSucceeded{
+		MemberID:  member.ID,
+		Username:  member.Username,
+		Token:     token,
+		ExpiresAt: session.ExpiresAt,
+	})
+}
+
+// newSessionToken starts the token with the member's ID, so a cluster node
+// can tell which member's grain holds the session.
+func newSessionToken(memberID string) (string, error) {
+	token := make([]byte, sessionTokenBytes)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return memberID + "." + base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// sessionMemberID reads the member ID back out of a session token.
+func sessionMemberID(token string) (string, bool) {
+	memberID, _, found := strings.Cut(token, ".")
+	return memberID, found && strings.HasPrefix(memberID, MemberIDPrefix)
+}
+
+// sessionKey is the map key for a bearer token.
+func sessionKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// pruneSessions drops expired sessions.
+func (engine *CommunityEngine) pruneSessions(now time.Time) {
+	for key, session := range engine.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(engine.sessions, key)
+		}
+	}
+}
+
+func (engine *CommunityEngine) resolveSession(context actor.Context, msg *ResolveSession) {
+	key := sessionKey(msg.Token)
+	session, exists := engine.sessions[key]
+	if exists && time.Now().After(session.ExpiresAt) {
+		delete(engine.sessions, key)
+		exists = false
+	}
+	var member *Member
+	if exists {
+		member, exists = engine.members[session.MemberID]
+	}
+
+	if !exists {
+		engine.fail(context, ErrInvalidSession, "Session is invalid or expired")
+		return
+	}
+	engine.respond(context, &SessionResolved{MemberID: member.ID, Username: member.Username})
+}
+
+func (engine *CommunityEngine) logout(context actor.Context, msg *Logout) {
+	key := sessionKey(msg.Token)
+	session, exists := engine.sessions[key]
+	delete(engine.sessions, key)
+
+	if !exists {
+		engine.fail(context, ErrInvalidSession, "Session is invalid or expired")
+		return
+	}
+	fmt.Printf("[Engine] Member logged out: ID=%s\n", session.MemberID)
+	engine.respond(context, &LoggedOut{MemberID: session.MemberID, Sessions: 1})
+}
+
+func (engine *CommunityEngine) revokeSessions(context actor.Context, msg *RevokeSessions) {
+	revoked := 0
+	for key, session := range engine.sessions {
+		if session.MemberID == msg.MemberID {
+			delete(engine.sessions, key)
+			revoked++
+		}
+	}
+
+	fmt.Printf("[Engine] Sessions revoked: ID=%s, Count=%d\n", msg.MemberID, revoked)
+	engine.respond(context, &LoggedOut{MemberID: msg.MemberID, Sessions: revoked})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegisterAndLogin(t *testing.T) {
+	test := startTestEngine(t, NewMemoryStore(), false)
+	registered := expect[*MemberRegistered](test, &RegisterMember{Username: "alice", Password: "secret"})
+	expectFailure(test, &RegisterMember{Username: "ALICE", Password: "other"}, ErrUsernameTaken)
+
+	login := expect[*LoginSucceeded](test, &Login{Username: "Alice", Password: "secret"})
+	if login.MemberID != registered.MemberID {
+		t.Errorf("logged in as %s, want %s", login.MemberID, registered.MemberID)
+	}
+	resolved := expect[*SessionResolved](test, &ResolveSession{Token: login.Token})
+	if resolved.MemberID != registered.MemberID {
+		t.Errorf("session resolved to %s, want %s", resolved.MemberID, registered.MemberID)
+	}
+	expectFailure(test, &Login{Username: "alice", Password: "wrong"}, ErrInvalidCredentials)
+	expectFailure(test, &Login{Username: "bob", Password: "secret"}, ErrInvalidCredentials)
+}
+
+// Password hashing runs off the engine actor, so other commands are answered
+// while registrations are still being hashed.
+func TestRegistrationDoesNotHoldUpEngine(t *testing.T) {
+	test := startTestEngine(t, NewMemoryStore(), false)
+	const registrations = 6
+	finished := make([]time.Time, registrations)
+	var wg sync.WaitGroup
+	for i := 0; i < registrations; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			username := string(rune('a'+i)) + "_member"
+			future := test.system.Root.RequestFuture(test.pid, &RegisterMember{Username: username, Password: "secret"}, 10*time.Second)
+			if result, err := future.Result(); err != nil {
+				t.Errorf("register %s: %v", username, err)
+			} else if _, ok := result.(*MemberRegistered); !ok {
+				t.Errorf("register %s answered %#v", username, result)
+			}
+			finished[i] = time.Now()
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	expect[*CommunityList](test, &ListCommunities{})
+	listed := time.Now()
+	wg.Wait()
+	for i, at := range finished {
+		if !at.After(listed) {
+			t.Errorf("registration %d finished before the listing was answered", i)
+		}
+	}
+}
+
+// Once shutdown has started, work that would hash a password is refused.
+func TestPasswordChecksRefusedAtShutdown(t *testing.T) {
+	test := startTestEngine(t, NewMemoryStore(), false)
+	expect[*MemberRegistered](test, &RegisterMember{Username: "alice", Password: "secret"})
+	test.host.current().background.stop()
+	expectFailure(test, &RegisterMember{Username: "bob", Password: "secret"}, ErrUnavailable)
+	expectFailure(test, &Login{Username: "alice", Password: "secret"}, ErrUnavailable)
+}
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		valid    bool
+	}{
+		{"bob", true},
+		{"Alice_Smith-2", true},
+		{"abcdefghijklmnopqrst", true},
+		{"ab", false},
+		{"abcdefghijklmnopqrstu", false},
+		{"", false},
+		{"with space", false},
+		{"slash/name", false},
+		{"dot.name", false},
+		{"naïve", false},
+		{"u_02t8e9dhh7lz4", false},
+		{"U_abc", false},
+		{"user_u_", true},
+		{"_leading", true},
+	}
+	for _, test := range tests {
+		if err := validateUsername(test.username); (err == nil) != test.valid {
+			t.Errorf("validateUsername(%q) = %v, want valid %v", test.username, err, test.valid)
+		}
+	}
+}
+
+func TestRegisterRejectsInvalidUsername(t *testing.T) {
+	test := startTestEngine(t, NewMemoryStore(), false)
+	expectFailure(test, &RegisterMember{Username: "u_02t8e9dhh7lz4", Password: "secret"}, ErrInvalidUsername)
+	expectFailure(test, &RegisterMember{Username: "a/b", Password: "secret"}, ErrInvalidUsername)
+	expectFailure(test, &RegisterMember{Username: "", Password: "secret"}, ErrInvalidRequest)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type callerKey struct{}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[len("Bearer "):])
+}
+
+// authenticate resolves the request's bearer token to a member through the
+// engine and makes it available to next via callerFrom.
+func (s *Server) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized, "Authentication required")
+			return
+		}
+		result, err := s.request(&ResolveSession{Token: token})
+		caller, ok := result.(*SessionResolved)
+		if err != nil || !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			s.writeEngineError(w, result, err)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)))
+	}
+}
+
+// callerFrom returns the member resolved by authenticate.
+func callerFrom(r *http.Request) *SessionResolved {
+	caller, _ := r.Context().Value(callerKey{}).(*SessionResolved)
+	return caller
+}
+
+// actAs fills an empty member ID from the request body with the caller's ID
+// and rejects bodies that name a different member.
+func actAs(w http.ResponseWriter, r *http.Request, memberID *string) bool {
+	caller := callerFrom(r)
+	if *memberID == "" {
+		*memberID = caller.MemberID
+		return true
+	}
+	if *memberID != caller.MemberID {
+		writeError(w, http.StatusForbidden, ErrForbidden, "Cannot act on behalf of another member")
+		return false
+	}
+	return true
+}
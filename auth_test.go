@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"Bearer abc", "abc"},
+		{"bearer abc ", "abc"},
+		{"Basic abc", ""},
+		{"Bearer", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+		if got := bearerToken(r); got != test.want {
+			t.Errorf("bearerToken(%q) = %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+// serve sends a request with token and body to handler and decodes the
+// response into out.
+func serve(t *testing.T, handler http.HandlerFunc, token, body string, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("response %q: %v", w.Body, err)
+		}
+	}
+	return w
+}
+
+func TestAuthenticate(t *testing.T) {
+	test := startTestEngine(t, NewMemoryStore(), true)
+	memberID := test.addMember("member")
+	otherID := test.addMember("other")
+	token := expect[*LoginSucceeded](test, &openSession{MemberID: memberID}).Token
+	server := NewServer(test.system, test.pid)
+	// whoami answers with the caller, acting as the member the body names
+	whoami := server.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		var req JoinCommunity
+		json.NewDecoder(r.Body).Decode(&req)
+		if actAs(w, r, &req.MemberID) {
+			writeJSON(w, http.StatusOK, callerFrom(r))
+		}
+	})
+
+	tests := []struct {
+		name          string
+		token         string
+		body          string
+		status        int
+		code          ErrorCode
+		authenticates string
+	}{
+		{name: "no token", status: http.StatusUnauthorized, code: ErrUnauthorized, authenticates: "Bearer"},
+		{name: "unknown token", token: memberID + ".unknown", status: http.StatusUnauthorized, code: ErrInvalidSession, authenticates: `Bearer error="invalid_token"`},
+		{name: "valid token", token: token, status: http.StatusOK},
+		{name: "acting as the caller", token: token, body: `{"MemberID":"` + memberID + `"}`, status: http.StatusOK},
+		{name: "acting as another member", token: token, body: `{"MemberID":"` + otherID + `"}`, status: http.StatusForbidden, code: ErrForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.code != "" {
+				var response ErrorResponse
+				w := serve(t, whoami, tc.token, tc.body, &response)
+				if w.Code != tc.status || response.Error == nil || response.Error.Code != tc.code {
+					t.Errorf("answered %d %s, want %d with %s", w.Code, w.Body, tc.status, tc.code)
+				}
+				if got := w.Header().Get("WWW-Authenticate"); got != tc.authenticates {
+					t.Errorf("WWW-Authenticate = %q, want %q", got, tc.authenticates)
+				}
+				return
+			}
+			var caller SessionResolved
+			if w := serve(t, whoami, tc.token, tc.body, &caller); w.Code != tc.status || caller.MemberID != memberID {
+				t.Errorf("answered %d %s, want %d with the caller", w.Code, w.Body, tc.status)
+			}
+		})
+	}
+}
+
+func TestLogoutAndRevokeSessions(t *testing.T) {
+	test := startTestEngine(t, NewMemoryStore(), true)
+	memberID := test.addMember("member")
+	var tokens []string
+	for i := 0; i < 3; i++ {
+		tokens = append(tokens, expect[*LoginSucceeded](test, &openSession{MemberID: memberID}).Token)
+	}
+	server := NewServer(test.system, test.pid)
+
+	var loggedOut LoggedOut
+	if w := serve(t, server.authenticate(server.Logout), tokens[0], "", &loggedOut); w.Code != http.StatusOK || loggedOut.Sessions != 1 {
+		t.Errorf("logout answered %d %s, want one session ended", w.Code, w.Body)
+	}
+	expectFailure(test, &ResolveSession{Token: tokens[0]}, ErrInvalidSession)
+	expect[*SessionResolved](test, &ResolveSession{Token: tokens[1]})
+
+	if w := serve(t, server.authenticate(server.RevokeSessions), tokens[1], "", &loggedOut); w.Code != http.StatusOK || loggedOut.Sessions != 2 {
+		t.Errorf("revoking answered %d %s, want two sessions ended", w.Code, w.Body)
+	}
+	for _, token := range tokens {
+		expectFailure(test, &ResolveSession{Token: token}, ErrInvalidSession)
+	}
+}
@@ -2,122 +2,509 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const defaultClientTimeout = 10 * time.Second
+
+// Client talks to the HTTP server. After Login it sends the session token
+// with every request. Failed requests return an *APIError carrying the
+// server's error code.
 type Client struct {
-	baseURL string
+	baseURL    string
+	httpClient *http.Client
+	token      string
+	verbose    bool
 }
 
-func NewClient(baseURL string) *Client {
-	return &Client{baseURL: baseURL}
+type ClientOption func(*Client)
+
+// WithHTTPClient replaces the default *http.Client, e.g. to change its
+// timeout or transport.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
 }
 
-func logRequest(method, url string, payload map[string]string) {
-	fmt.Printf("[HTTP Request] Method: %s, URL: %s, Payload: %v\n", method, url, payload)
+// WithVerbose logs the method, URL and status of every request. Bodies are
+// never logged since they carry passwords and session tokens.
+func WithVerbose(verbose bool) ClientOption {
+	return func(c *Client) {
+		c.verbose = verbose
+	}
 }
 
-func logResponse(body []byte) {
-	fmt.Printf("[HTTP Response] Body: %s\n", string(body))
+func NewClient(baseURL string, options ...ClientOption) *Client {
+	c := &Client{
+		baseURL:    baseURL,
+		httpClient: &http.Client{Timeout: defaultClientTimeout},
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
 }
 
-func (c *Client) RegisterMember(username, password string) {
-	payload := map[string]string{
-		"Username": username,
-		"Password": password,
+// do sends payload as JSON, if not nil, and decodes the response into out,
+// if not nil.
+func (c *Client) do(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
 	}
-	data, _ := json.Marshal(payload)
-	url := fmt.Sprintf("%s/register", c.baseURL)
-	logRequest("POST", url, payload)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
 	if err != nil {
-		fmt.Printf("Error registering member: %v\n", err)
-		return
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-	logResponse(body)
+	if c.verbose {
+		fmt.Printf("[HTTP] %s %s -> %d\n", method, req.URL, resp.StatusCode)
+	}
+	return decodeResponse(resp, out)
 }
 
-func (c *Client) CreateCommunity(name, description string) {
-	payload := map[string]string{
-		"Name":        name,
-		"Description": description,
+// decodeResponse turns error statuses into an *APIError built from the
+// error envelope; otherwise the body is decoded into out, which may be nil
+// when the caller does not need it.
+func decodeResponse(resp *http.Response, out interface{}) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var envelope ErrorResponse
+		if err := json.Unmarshal(body, &envelope); err != nil || envelope.Error == nil {
+			return &APIError{StatusCode: resp.StatusCode, Code: ErrUnexpectedResponse, Message: http.StatusText(resp.StatusCode)}
+		}
+		envelope.Error.StatusCode = resp.StatusCode
+		return envelope.Error
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
+
+// pageQuery builds the query string shared by the paginated listings.
+func pageQuery(feedSort FeedSort, after string, limit int) string {
+	query := url.Values{}
+	if feedSort != "" {
+		query.Set("sort", string(feedSort))
+	}
+	if after != "" {
+		query.Set("after", after)
 	}
-	data, _ := json.Marshal(payload)
-	url := fmt.Sprintf("%s/community", c.baseURL)
-	logRequest("POST", url, payload)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
+
+func (c *Client) RegisterMember(ctx context.Context, username, password string) (*CreatedResponse, error) {
+	var created CreatedResponse
+	err := c.do(ctx, http.MethodPost, "/register", &RegisterMember{Username: username, Password: password}, &created)
 	if err != nil {
-		fmt.Printf("Error creating community: %v\n", err)
-		return
+		return nil, err
 	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-	logResponse(body)
+	return &created, nil
 }
 
-func (c *Client) CreateThread(title, content, creatorID, communityID string) string {
-	payload := map[string]string{
-		"Title":       title,
-		"Content":     content,
-		"CreatorID":   creatorID,
-		"CommunityID": communityID,
+// Login verifies the credentials and keeps the session token for later
+// requests.
+func (c *Client) Login(ctx context.Context, username, password string) (*LoginSucceeded, error) {
+	var session LoginSucceeded
+	if err := c.do(ctx, http.MethodPost, "/login", &Login{Username: username, Password: password}, &session); err != nil {
+		return nil, err
 	}
-	data, _ := json.Marshal(payload)
-	url := fmt.Sprintf("%s/thread", c.baseURL)
-	logRequest("POST", url, payload)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	c.token = session.Token
+	return &session, nil
+}
+
+// Logout ends the client's session. The token is dropped even if the server
+// could not be reached.
+func (c *Client) Logout(ctx context.Context) (*LoggedOut, error) {
+	var loggedOut LoggedOut
+	err := c.do(ctx, http.MethodPost, "/logout", nil, &loggedOut)
+	c.token = ""
 	if err != nil {
-		fmt.Printf("Error creating thread: %v\n", err)
-		return ""
+		return nil, err
 	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-	logResponse(body)
-
-	// Extract the thread ID from the response
-	var threadID string
-	fmt.Sscanf(string(body), "Thread created with ID: %s", &threadID)
-	return threadID
-}
-
-func (c *Client) CreateReply(content, creatorID, threadID, parentID string) {
-	payload := map[string]string{
-		"Content":   content,
-		"CreatorID": creatorID,
-		"ThreadID":  threadID,
-		"ParentID":  parentID,
-	}
-	data, _ := json.Marshal(payload)
-	url := fmt.Sprintf("%s/reply", c.baseURL)
-	logRequest("POST", url, payload)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	return &loggedOut, nil
+}
+
+// RevokeSessions logs the member out of every session, including this one.
+func (c *Client) RevokeSessions(ctx context.Context) (*LoggedOut, error) {
+	var loggedOut LoggedOut
+	err := c.do(ctx, http.MethodPost, "/logout/all", nil, &loggedOut)
+	c.token = ""
 	if err != nil {
-		fmt.Printf("Error creating reply: %v\n", err)
-		return
+		return nil, err
 	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-	logResponse(body)
+	return &loggedOut, nil
+}
+
+func (c *Client) CreateCommunity(ctx context.Context, name, description string) (*CreatedResponse, error) {
+	var created CreatedResponse
+	err := c.do(ctx, http.MethodPost, "/community", &CreateCommunity{Name: name, Description: description}, &created)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) CreateThread(ctx context.Context, communityName, title, content string) (*CreatedResponse, error) {
+	payload := &CreateThread{Title: title, Content: content, CommunityID: communityName}
+	var created CreatedResponse
+	if err := c.do(ctx, http.MethodPost, "/thread", payload, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// CreateReply replies to a thread, or to the reply parentID within it.
+func (c *Client) CreateReply(ctx context.Context, threadID, parentID, content string) (*CreatedResponse, error) {
+	payload := &CreateReply{Content: content, ThreadID: threadID, ParentID: parentID}
+	var created CreatedResponse
+	if err := c.do(ctx, http.MethodPost, "/reply", payload, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) ListCommunities(ctx context.Context, after string, limit int) (*CommunityList, error) {
+	var communities CommunityList
+	if err := c.do(ctx, http.MethodGet, "/communities"+pageQuery("", after, limit), nil, &communities); err != nil {
+		return nil, err
+	}
+	return &communities, nil
+}
+
+func (c *Client) FetchCommunity(ctx context.Context, communityName string) (*CommunityView, error) {
+	var community CommunityView
+	if err := c.do(ctx, http.MethodGet, "/community/"+url.PathEscape(communityName), nil, &community); err != nil {
+		return nil, err
+	}
+	return &community, nil
+}
+
+func (c *Client) FetchCommunityThreads(ctx context.Context, communityName string, feedSort FeedSort, after string, limit int) (*FeedResult, error) {
+	path := "/community/" + url.PathEscape(communityName) + "/threads" + pageQuery(feedSort, after, limit)
+	var threads FeedResult
+	if err := c.do(ctx, http.MethodGet, path, nil, &threads); err != nil {
+		return nil, err
+	}
+	return &threads, nil
+}
+
+func (c *Client) JoinCommunity(ctx context.Context, communityName string) (*MembershipChanged, error) {
+	return c.postMembership(ctx, communityName, "join")
+}
+
+func (c *Client) LeaveCommunity(ctx context.Context, communityName string) (*MembershipChanged, error) {
+	return c.postMembership(ctx, communityName, "leave")
+}
+
+func (c *Client) postMembership(ctx context.Context, communityName, action string) (*MembershipChanged, error) {
+	var changed MembershipChanged
+	if err := c.do(ctx, http.MethodPost, "/community/"+url.PathEscape(communityName)+"/"+action, nil, &changed); err != nil {
+		return nil, err
+	}
+	return &changed, nil
+}
+
+// FetchProfile looks a member up by member ID or username.
+func (c *Client) FetchProfile(ctx context.Context, memberIDOrUsername string) (*MemberProfile, error) {
+	var profile MemberProfile
+	if err := c.do(ctx, http.MethodGet, "/user/"+url.PathEscape(memberIDOrUsername), nil, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func (c *Client) ListMemberCommunities(ctx context.Context, memberID string) (*MemberCommunities, error) {
+	var communities MemberCommunities
+	if err := c.do(ctx, http.MethodGet, "/user/"+url.PathEscape(memberID)+"/communities", nil, &communities); err != nil {
+		return nil, err
+	}
+	return &communities, nil
+}
+
+// FetchFeed returns a page of the logged-in member's feed.
+func (c *Client) FetchFeed(ctx context.Context, feedSort FeedSort, after string, limit int) (*FeedResult, error) {
+	var feed FeedResult
+	if err := c.do(ctx, http.MethodGet, "/feed"+pageQuery(feedSort, after, limit), nil, &feed); err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// FetchThread loads a thread's reply tree. Pass a replyID from a MoreReplies
+// stub to expand a collapsed branch; zero depth and limit use server defaults.
+func (c *Client) FetchThread(ctx context.Context, threadID, replyID string, depth, limit int) (*ThreadTree, error) {
+	query := url.Values{}
+	if replyID != "" {
+		query.Set("comment", replyID)
+	}
+	if depth > 0 {
+		query.Set("depth", strconv.Itoa(depth))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	path := "/thread/" + url.PathEscape(threadID)
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var tree ThreadTree
+	if err := c.do(ctx, http.MethodGet, path, nil, &tree); err != nil {
+		return nil, err
+	}
+	return &tree, nil
+}
+
+// EditThread changes a thread's title and content; empty values keep the
+// current ones.
+func (c *Client) EditThread(ctx context.Context, threadID, title, content string) (*ContentEdited, error) {
+	var edited ContentEdited
+	if err := c.do(ctx, http.MethodPatch, "/thread/"+url.PathEscape(threadID), &EditThread{Title: title, Content: content}, &edited); err != nil {
+		return nil, err
+	}
+	return &edited, nil
+}
+
+func (c *Client) EditReply(ctx context.Context, replyID, content string) (*ContentEdited, error) {
+	var edited ContentEdited
+	if err := c.do(ctx, http.MethodPatch, "/reply/"+url.PathEscape(replyID), &EditReply{Content: content}, &edited); err != nil {
+		return nil, err
+	}
+	return &edited, nil
+}
+
+func (c *Client) DeleteThread(ctx context.Context, threadID string) (*ContentDeleted, error) {
+	var deleted ContentDeleted
+	if err := c.do(ctx, http.MethodDelete, "/thread/"+url.PathEscape(threadID), nil, &deleted); err != nil {
+		return nil, err
+	}
+	return &deleted, nil
+}
+
+func (c *Client) DeleteReply(ctx context.Context, replyID string) (*ContentDeleted, error) {
+	var deleted ContentDeleted
+	if err := c.do(ctx, http.MethodDelete, "/reply/"+url.PathEscape(replyID), nil, &deleted); err != nil {
+		return nil, err
+	}
+	return &deleted, nil
+}
+
+// FetchRevisions returns the edit history of a thread or reply.
+func (c *Client) FetchRevisions(ctx context.Context, targetID string) (*Revisions, error) {
+	kind := "reply"
+	if strings.HasPrefix(targetID, ThreadIDPrefix) {
+		kind = "thread"
+	}
+	var revisions Revisions
+	if err := c.do(ctx, http.MethodGet, "/"+kind+"/"+url.PathEscape(targetID)+"/history", nil, &revisions); err != nil {
+		return nil, err
+	}
+	return &revisions, nil
+}
+
+// SendMessage sends a private message to a member ID or username.
+func (c *Client) SendMessage(ctx context.Context, receiver, content string) (*CreatedResponse, error) {
+	return c.postMessage(ctx, &SendMessage{ReceiverID: receiver, Content: content})
+}
+
+// ReplyToMessage answers a message in its conversation.
+func (c *Client) ReplyToMessage(ctx context.Context, messageID, content string) (*CreatedResponse, error) {
+	return c.postMessage(ctx, &SendMessage{ReplyToID: messageID, Content: content})
 }
 
-func mainClient() {
-	client := NewClient("http://localhost:8080")
+func (c *Client) postMessage(ctx context.Context, payload *SendMessage) (*CreatedResponse, error) {
+	var created CreatedResponse
+	if err := c.do(ctx, http.MethodPost, "/messages", payload, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
 
-	client.RegisterMember("test_user", "password123")
-	client.CreateCommunity("test_community", "A test community description.")
+func (c *Client) FetchInbox(ctx context.Context, unreadOnly bool, after string, limit int) (*MessageList, error) {
+	query := url.Values{}
+	if unreadOnly {
+		query.Set("unread", "true")
+	}
+	if after != "" {
+		query.Set("after", after)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	path := "/messages/inbox"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var messages MessageList
+	if err := c.do(ctx, http.MethodGet, path, nil, &messages); err != nil {
+		return nil, err
+	}
+	return &messages, nil
+}
 
-	// Create a thread and dynamically capture its ID
-	threadID := client.CreateThread("Welcome Thread", "Welcome to the community!", "test_user", "test_community")
-	if threadID == "" {
-		fmt.Println("Failed to create thread. Exiting...")
-		return
+func (c *Client) FetchSent(ctx context.Context, after string, limit int) (*MessageList, error) {
+	var messages MessageList
+	if err := c.do(ctx, http.MethodGet, "/messages/sent"+pageQuery("", after, limit), nil, &messages); err != nil {
+		return nil, err
 	}
+	return &messages, nil
+}
 
-	// Use the captured thread ID to create a reply
-	client.CreateReply("Thanks for the welcome!", "test_user", threadID, "")
+func (c *Client) ListConversations(ctx context.Context) (*ConversationList, error) {
+	var conversations ConversationList
+	if err := c.do(ctx, http.MethodGet, "/messages/conversations", nil, &conversations); err != nil {
+		return nil, err
+	}
+	return &conversations, nil
+}
+
+// FetchConversation pages through the messages exchanged with another
+// member, newest first.
+func (c *Client) FetchConversation(ctx context.Context, other, after string, limit int) (*MessageList, error) {
+	path := "/messages/conversations/" + url.PathEscape(other) + pageQuery("", after, limit)
+	var messages MessageList
+	if err := c.do(ctx, http.MethodGet, path, nil, &messages); err != nil {
+		return nil, err
+	}
+	return &messages, nil
+}
+
+func (c *Client) MarkMessageRead(ctx context.Context, messageID string, read bool) (*PrivateMessage, error) {
+	var message PrivateMessage
+	if err := c.do(ctx, http.MethodPost, "/message/"+url.PathEscape(messageID)+"/read", &MarkMessageRead{Read: read}, &message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func (c *Client) BlockMember(ctx context.Context, member string, blocked bool) (*BlockChanged, error) {
+	var changed BlockChanged
+	if err := c.do(ctx, http.MethodPost, "/messages/block", &BlockMember{BlockedID: member, Blocked: blocked}, &changed); err != nil {
+		return nil, err
+	}
+	return &changed, nil
+}
+
+func (c *Client) AddModerator(ctx context.Context, communityName, memberID string) (*ModLogEntry, error) {
+	return c.postModAction(ctx, communityName, "moderators/add", &AddModerator{MemberID: memberID})
+}
+
+func (c *Client) RemoveModerator(ctx context.Context, communityName, memberID string) (*ModLogEntry, error) {
+	return c.postModAction(ctx, communityName, "moderators/remove", &RemoveModerator{MemberID: memberID})
+}
+
+// RemoveContent removes a thread or reply from the community.
+func (c *Client) RemoveContent(ctx context.Context, communityName, targetID, reason string) (*ModLogEntry, error) {
+	return c.postModAction(ctx, communityName, "remove", &RemoveContent{TargetID: targetID, Reason: reason})
+}
+
+func (c *Client) LockThread(ctx context.Context, communityName, threadID string, locked bool) (*ModLogEntry, error) {
+	return c.postModAction(ctx, communityName, "lock", &LockThread{ThreadID: threadID, Locked: locked})
+}
+
+func (c *Client) PinThread(ctx context.Context, communityName, threadID string, pinned bool) (*ModLogEntry, error) {
+	return c.postModAction(ctx, communityName, "pin", &PinThread{ThreadID: threadID, Pinned: pinned})
+}
+
+func (c *Client) BanMember(ctx context.Context, communityName, memberID string, banned bool, reason string) (*ModLogEntry, error) {
+	return c.postModAction(ctx, communityName, "ban", &BanMember{MemberID: memberID, Banned: banned, Reason: reason})
+}
+
+func (c *Client) postModAction(ctx context.Context, communityName, action string, payload interface{}) (*ModLogEntry, error) {
+	var entry ModLogEntry
+	if err := c.do(ctx, http.MethodPost, "/community/"+url.PathEscape(communityName)+"/mod/"+action, payload, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (c *Client) FetchModLog(ctx context.Context, communityName string) (*ModLog, error) {
+	var modLog ModLog
+	if err := c.do(ctx, http.MethodGet, "/community/"+url.PathEscape(communityName)+"/mod/log", nil, &modLog); err != nil {
+		return nil, err
+	}
+	return &modLog, nil
+}
+
+func (c *Client) CastVote(ctx context.Context, targetID string, isUpvote bool) (*VoteRecorded, error) {
+	return c.postVote(ctx, &CastVote{TargetID: targetID, IsUpvote: isUpvote})
+}
+
+func (c *Client) RetractVote(ctx context.Context, targetID string) (*VoteRecorded, error) {
+	return c.postVote(ctx, &CastVote{TargetID: targetID, Retract: true})
+}
+
+func (c *Client) postVote(ctx context.Context, payload *CastVote) (*VoteRecorded, error) {
+	var recorded VoteRecorded
+	if err := c.do(ctx, http.MethodPost, "/vote", payload, &recorded); err != nil {
+		return nil, err
+	}
+	return &recorded, nil
+}
+
+// mainClient registers a member, logs in and posts a thread and a reply,
+// logging each step.
+func mainClient(serverURL, username, password, community string, verbose bool) error {
+	ctx := context.Background()
+	client := NewClient(serverURL, WithVerbose(verbose))
+
+	if _, err := client.RegisterMember(ctx, username, password); err != nil {
+		fmt.Printf("[Client] Failed to register: %v\n", err)
+	}
+	session, err := client.Login(ctx, username, password)
+	if err != nil {
+		fmt.Printf("[Client] Failed to log in: %v\n", err)
+		return err
+	}
+	fmt.Printf("[Client] Logged in as %s\n", session.MemberID)
+
+	if _, err := client.CreateCommunity(ctx, community, "A test community description."); err != nil {
+		fmt.Printf("[Client] Failed to create community: %v\n", err)
+	}
+	thread, err := client.CreateThread(ctx, community, "Welcome Thread", "Welcome to the community!")
+	if err != nil {
+		fmt.Printf("[Client] Failed to create thread: %v\n", err)
+		return err
+	}
+	reply, err := client.CreateReply(ctx, thread.ID, "", "Thanks for the welcome!")
+	if err != nil {
+		fmt.Printf("[Client] Failed to create reply: %v\n", err)
+		return err
+	}
+	fmt.Printf("[Client] Created thread %s and reply %s\n", thread.ID, reply.ID)
+	return nil
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   *APIError
+	}{
+		{"success", http.StatusOK, `{"MemberID":"m_1","Sessions":2}`, nil},
+		{"error envelope", http.StatusForbidden, `{"error":{"code":"not_moderator","message":"No"}}`, &APIError{StatusCode: http.StatusForbidden, Code: ErrNotModerator, Message: "No"}},
+		{"body without an envelope", http.StatusBadGateway, "<html>", &APIError{StatusCode: http.StatusBadGateway, Code: ErrUnexpectedResponse, Message: "Bad Gateway"}},
+		{"envelope without an error", http.StatusNotFound, `{}`, &APIError{StatusCode: http.StatusNotFound, Code: ErrUnexpectedResponse, Message: "Not Found"}},
+	}
+	for _, test := range tests {
+		resp := &http.Response{StatusCode: test.status, Body: io.NopCloser(strings.NewReader(test.body))}
+		var out LoggedOut
+		err := decodeResponse(resp, &out)
+		if test.want == nil {
+			if err != nil || out.MemberID != "m_1" || out.Sessions != 2 {
+				t.Errorf("%s: decoded %+v, %v", test.name, out, err)
+			}
+			continue
+		}
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || *apiErr != *test.want {
+			t.Errorf("%s: decodeResponse = %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+// TestClientSession checks that the client sends the token Login returned,
+// and drops it on Logout even when the server rejects the request.
+func TestClientSession(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/login":
+			writeJSON(w, http.StatusOK, &LoginSucceeded{MemberID: "m_1", Token: "m_1.token"})
+		case "/community/golang/join":
+			writeJSON(w, http.StatusOK, &MembershipChanged{CommunityID: "golang", MemberCount: 2})
+		default:
+			writeError(w, http.StatusUnauthorized, ErrInvalidSession, "Session is invalid or expired")
+		}
+	}))
+	defer server.Close()
+	client := NewClient(server.URL)
+	ctx := context.Background()
+
+	if _, err := client.Login(ctx, "member", "secret"); err != nil || authorization != "" {
+		t.Fatalf("login failed with %v, sending %q", err, authorization)
+	}
+	if changed, err := client.JoinCommunity(ctx, "golang"); err != nil || changed.MemberCount != 2 || authorization != "Bearer m_1.token" {
+		t.Errorf("join answered %+v, %v, sending %q", changed, err, authorization)
+	}
+	var apiErr *APIError
+	if _, err := client.Logout(ctx); !errors.As(err, &apiErr) || apiErr.Code != ErrInvalidSession {
+		t.Errorf("logout failed with %v, want %s", err, ErrInvalidSession)
+	}
+	if _, err := client.JoinCommunity(ctx, "golang"); err != nil || authorization != "" {
+		t.Errorf("join after logout failed with %v, sending %q", err, authorization)
+	}
+}
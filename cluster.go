@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/cluster"
+	"github.com/asynkron/protoactor-go/cluster/clusterproviders/automanaged"
+	"github.com/asynkron/protoactor-go/cluster/identitylookup/disthash"
+	"github.com/asynkron/protoactor-go/remote"
+)
+
+// Grain kinds. Every node hosts every kind, and the cluster places each grain
+// on the node its identity hashes to. A grain's state lives in the engine of
+// that node.
+const (
+	// communityKind grains are named by the lower-cased community name and
+	// own the community, its threads, replies, votes and moderation log.
+	communityKind = "community"
+	// memberKind grains are named by member ID and own the member's sessions.
+	memberKind = "member"
+	// conversationKind grains are named by conversationID and own the
+	// messages between two members and the blocks between them.
+	conversationKind = "conversation"
+	// usernameKind grains are named by the lower-cased username. Every
+	// registration of a name passes through its grain, one at a time.
+	usernameKind = "username"
+
+	// nodeActorName is the actor on every node that answers for the node's
+	// whole engine, for requests that have to ask every node.
+	nodeActorName = "node"
+
+	defaultClusterName = "community"
+	membershipRefresh  = 2 * time.Second
+
+	// copyAttempts is how often a registration is copied to a node before
+	// the copy is left to retry in the background.
+	copyAttempts = 3
+	copyBackoff  = 100 * time.Millisecond
+	// maxCopyBackoff caps the wait between background copies.
+	maxCopyBackoff = 10 * time.Second
+)
+
+// ClusterConfig describes this node and the nodes it forms a cluster with.
+type ClusterConfig struct {
+	Name string
+	// Address is the host:port the node's actors listen on.
+	Address string
+	// MembershipPort serves this node's health to the other nodes.
+	MembershipPort int
+	// Peers lists host:MembershipPort of every node, this one included, in
+	// the same order on every node.
+	Peers   []string
+	Timeout time.Duration
+}
+
+// NodeNumber is this node's position in Peers, which also keeps the IDs it
+// generates apart from those of the other nodes.
+func (config *ClusterConfig) NodeNumber() (int, error) {
+	for i, peer := range config.Peers {
+		_, port, err := net.SplitHostPort(peer)
+		if err != nil {
+			return 0, fmt.Errorf("peer %q: %w", peer, err)
+		}
+		if port == strconv.Itoa(config.MembershipPort) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no peer uses membership port %d", config.MembershipPort)
+}
+
+// ClusterNode is one process of a clustered engine. Each node keeps the state
+// of the grains placed on it, plus a copy of every member, so that any node
+// can check member references. Requests that read across grains ask every
+// node and merge the answers. The membership is static: requests are only
+// served while every node in Peers is up, since a grain placed elsewhere in
+// the meantime would start without its state.
+type ClusterNode struct {
+	cluster   *cluster.Cluster
+	host      *engineHost
+	enginePID *actor.PID
+	peers     int
+	timeout   time.Duration
+
+	// located caches where threads, replies and messages live. They never
+	// move, so entries never go stale.
+	located sync.Map
+	// stopped is closed on shutdown to end copies still being retried.
+	stopped chan struct{}
+}
+
+// StartClusterNode joins the cluster described by config. enginePID is the
+// node's own engine actor, used for lookups that any node can answer; grains
+// apply commands to the engine host's current engine.
+func StartClusterNode(system *actor.ActorSystem, host *engineHost, enginePID *actor.PID, config ClusterConfig) (*ClusterNode, error) {
+	hostname, portText, err := net.SplitHostPort(config.Address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return nil, fmt.Errorf("node port %q: %w", portText, err)
+	}
+	node := &ClusterNode{
+		host:      host,
+		enginePID: enginePID,
+		peers:     len(config.Peers),
+		timeout:   config.Timeout,
+		stopped:   make(chan struct{}),
+	}
+
+	props := actor.PropsFromProducer(func() actor.Actor {
+		return &clusterActor{node: node}
+	})
+	kinds := []*cluster.Kind{
+		cluster.NewKind(communityKind, props),
+		cluster.NewKind(memberKind, props),
+		cluster.NewKind(conversationKind, props),
+		cluster.NewKind(usernameKind, props),
+	}
+	provider := automanaged.NewWithConfig(membershipRefresh, config.MembershipPort, config.Peers...)
+	clusterConfig := cluster.Configure(config.Name, provider, disthash.New(), remote.Configure(hostname, port),
+		cluster.WithKinds(kinds...), cluster.WithRequestTimeout(config.Timeout))
+	node.cluster = cluster.New(system, clusterConfig)
+
+	// The node actor exists before the other nodes can see this one
+	if _, err := system.Root.SpawnNamed(props, nodeActorName); err != nil {
+		return nil, err
+	}
+	node.cluster.StartMember()
+	fmt.Printf("[Cluster] Node %s joined cluster %s\n", system.Address(), config.Name)
+	return node, nil
+}
+
+// Shutdown leaves the cluster and stops the node's actor system.
+func (node *ClusterNode) Shutdown() {
+	close(node.stopped)
+	node.cluster.Shutdown(true)
+}
+
+// clusterActor runs both the grains and the node actor. It decodes a request,
+// passes it to the node's engine actor and encodes the answer. Going through
+// the engine actor keeps its supervisor in charge: a command that fails is
+// answered and the engine rebuilt, as for any other sender. Grains wait on
+// the engine and registrations on node actors, but the engine never waits,
+// so requests cannot deadlock.
+type clusterActor struct {
+	node *ClusterNode
+}
+
+func (grain *clusterActor) Receive(context actor.Context) {
+	request, ok := context.Message().(*cluster.GrainRequest)
+	if !ok {
+		return
+	}
+	wire := &wireContext{Context: context}
+	command, err := decodeWire(request.MessageTypeName, request.MessageData)
+	if err != nil {
+		fmt.Printf("[Cluster] Failed to decode %s: %v\n", request.MessageTypeName, err)
+		wire.Respond(&CommandFailed{Code: ErrInvalidRequest, Reason: "Could not decode request"})
+		return
+	}
+	if register, ok := command.(*addMember); ok && register.MemberID == "" {
+		grain.node.register(wire, register)
+		return
+	}
+	// Waiting here keeps each grain's commands in the order they arrived
+	result, err := grain.node.requestLocal(command)
+	if err != nil {
+		fmt.Printf("[Cluster] Engine did not answer %s: %v\n", request.MessageTypeName, err)
+		result = &CommandFailed{Code: ErrUnavailable, Reason: "The engine did not answer"}
+	}
+	wire.Respond(result)
+}
+
+// wireContext encodes the engine's answer for the node that asked.
+type wireContext struct {
+	actor.Context
+}
+
+func (context *wireContext) Respond(response interface{}) {
+	typeName, data, err := encodeWire(response)
+	if err != nil {
+		fmt.Printf("[Cluster] Failed to encode %T: %v\n", response, err)
+		typeName, data, _ = encodeWire(&CommandFailed{Code: ErrUnavailable, Reason: "Could not encode response"})
+	}
+	context.Context.Respond(&cluster.GrainResponse{MessageTypeName: typeName, MessageData: data})
+}
+
+// register adds a member on every node under one ID. This node answers
+// first: it knows every member, and every registration of the name passes
+// through this grain, so its answer settles whether the name is free. The
+// gateway has already hashed the password.
+//
+// The member then exists, so it is not taken back when a copy fails. Copies
+// are idempotent and are retried instead: a few times before answering, then
+// in the background until they reach the node.
+func (node *ClusterNode) register(context actor.Context, msg *addMember) {
+	engine := node.host.current()
+	command := &addMember{
+		Username:     msg.Username,
+		PasswordHash: msg.PasswordHash,
+		MemberID:     engine.idGenerator.NewID(MemberIDPrefix),
+	}
+
+	self := node.cluster.ActorSystem.Address()
+	result, err := node.requestNode(self, command)
+	if err != nil {
+		fmt.Printf("[Cluster] Failed to register %s: %v\n", msg.Username, err)
+		engine.fail(context, ErrUnavailable, "Could not register member")
+		return
+	}
+	if _, ok := result.(*MemberRegistered); !ok {
+		engine.respond(context, result)
+		return
+	}
+	for _, member := range node.cluster.MemberList.Members().Members() {
+		if member.Address() == self {
+			continue
+		}
+		if !node.copyMember(member.Address(), command, copyAttempts) {
+			go node.copyMember(member.Address(), command, 0)
+		}
+	}
+	engine.respond(context, result)
+}
+
+// copyMember copies a registration to the node at address, waiting longer
+// after each try the node did not answer. It reports false if it stopped
+// after attempts tries, or on shutdown, without the node having answered;
+// with attempts 0 it keeps trying until then. A node refusing the member is
+// logged, since trying again would not change its answer.
+func (node *ClusterNode) copyMember(address string, command *addMember, attempts int) bool {
+	backoff := copyBackoff
+	for attempt := 1; ; attempt++ {
+		result, err := node.requestNode(address, command)
+		if err == nil {
+			switch answer := result.(type) {
+			case *MemberRegistered:
+				return true
+			case *CommandFailed:
+				if answer.Code != ErrUnavailable {
+					fmt.Printf("[Cluster] Node %s refused member %s: %s\n", address, command.MemberID, answer.Reason)
+					return true
+				}
+			}
+		}
+		fmt.Printf("[Cluster] Failed to copy member %s to %s (attempt %d): %v %v\n", command.MemberID, address, attempt, err, result)
+		if attempt == attempts {
+			return false
+		}
+		select {
+		case <-node.stopped:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxCopyBackoff)
+	}
+}
+
+// wireMessages lists every message that travels between nodes, keyed by
+// type name. They travel as JSON inside the cluster's own GrainRequest and
+// GrainResponse, so they need no protobuf definitions.
+var wireMessages = map[string]func() interface{}{
+	"addMember":             wire[addMember],
+	"Login":                 wire[Login],
+	"FetchProfile":          wire[FetchProfile],
+	"Logout":                wire[Logout],
+	"RevokeSessions":        wire[RevokeSessions],
+	"ResolveSession":        wire[ResolveSession],
+	"CreateCommunity":       wire[CreateCommunity],
+	"FetchCommunity":        wire[FetchCommunity],
+	"FetchCommunityThreads": wire[FetchCommunityThreads],
+	"JoinCommunity":         wire[JoinCommunity],
+	"LeaveCommunity":        wire[LeaveCommunity],
+	"ListMemberCommunities": wire[ListMemberCommunities],
+	"AddModerator":          wire[AddModerator],
+	"RemoveModerator":       wire[RemoveModerator],
+	"RemoveContent":         wire[RemoveContent],
+	"LockThread":            wire[LockThread],
+	"PinThread":             wire[PinThread],
+	"BanMember":             wire[BanMember],
+	"FetchModLog":           wire[FetchModLog],
+	"CreateThread":          wire[CreateThread],
+	"CreateReply":           wire[CreateReply],
+	"FetchThread":           wire[FetchThread],
+	"EditThread":            wire[EditThread],
+	"EditReply":             wire[EditReply],
+	"DeleteThread":          wire[DeleteThread],
+	"DeleteReply":           wire[DeleteReply],
+	"FetchRevisions":        wire[FetchRevisions],
+	"CastVote":              wire[CastVote],
+	"SendMessage":           wire[SendMessage],
+	"ListConversations":     wire[ListConversations],
+	"FetchConversation":     wire[FetchConversation],
+	"MarkMessageRead":       wire[MarkMessageRead],
+	"BlockMember":           wire[BlockMember],
+	"locateContent":         wire[locateContent],
+	"collectFeed":           wire[collectFeed],
+	"collectCommunities":    wire[collectCommunities],
+	"collectMessages":       wire[collectMessages],
+
+	"CommandFailed":     wire[CommandFailed],
+	"MemberRegistered":  wire[MemberRegistered],
+	"LoginSucceeded":    wire[LoginSucceeded],
+	"MemberProfile":     wire[MemberProfile],
+	"LoggedOut":         wire[LoggedOut],
+	"SessionResolved":   wire[SessionResolved],
+	"CommunityCreated":  wire[CommunityCreated],
+	"CommunityView":     wire[CommunityView],
+	"CommunityList":     wire[CommunityList],
+	"MembershipChanged": wire[MembershipChanged],
+	"MemberCommunities": wire[MemberCommunities],
+	"FeedResult":        wire[FeedResult],
+	"ThreadCreated":     wire[ThreadCreated],
+	"ReplyCreated":      wire[ReplyCreated],
+	"ThreadTree":        wire[ThreadTree],
+	"ContentEdited":     wire[ContentEdited],
+	"ContentDeleted":    wire[ContentDeleted],
+	"Revisions":         wire[Revisions],
+	"VoteRecorded":      wire[VoteRecorded],
+	"ModActionRecorded": wire[ModActionRecorded],
+	"ModLog":            wire[ModLog],
+	"MessageDelivered":  wire[MessageDelivered],
+	"MessageList":       wire[MessageList],
+	"ConversationList":  wire[ConversationList],
+	"PrivateMessage":    wire[PrivateMessage],
+	"BlockChanged":      wire[BlockChanged],
+	"contentLocated":    wire[contentLocated],
+}
+
+func wire[T any]() interface{} {
+	return new(T)
+}
+
+func encodeWire(message interface{}) (string, []byte, error) {
+	typeName := commandType(message)
+	if _, exists := wireMessages[typeName]; !exists {
+		return "", nil, fmt.Errorf("%s cannot be sent between nodes", typeName)
+	}
+	data, err := json.Marshal(message)
+	return typeName, data, err
+}
+
+func decodeWire(typeName string, data []byte) (interface{}, error) {
+	newMessage, exists := wireMessages[typeName]
+	if !exists {
+		return nil, fmt.Errorf("unknown message type %q", typeName)
+	}
+	message := newMessage()
+	if err := json.Unmarshal(data, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// locateContent asks a node where a thread, reply or private message lives.
+type locateContent struct {
+	ID string
+}
+
+// contentLocated names the community holding a thread or reply, or the
+// conversation holding a message. Both are empty if the node has neither.
+type contentLocated struct {
+	CommunityID    string
+	ConversationID string
+}
+
+// collectFeed, collectCommunities and collectMessages ask a node for all of
+// its matching items, unpaged, so the node serving the request can merge the
+// answers of every node before cutting a page.
+type collectFeed struct {
+	MemberID string
+}
+
+type collectCommunities struct{}
+
+type collectMessages struct {
+	MemberID   string
+	Sent       bool
+	UnreadOnly bool
+}
+
+func (engine *CommunityEngine) locateContent(context actor.Context, msg *locateContent) {
+	located := &contentLocated{}
+	if shard, exists := engine.communityShard(engine.content[msg.ID]); exists {
+		located.CommunityID = shard.name
+	}
+	if entry, exists := engine.messages[msg.ID]; exists {
+		located.ConversationID = entry.conversationID
+	}
+	engine.respond(context, located)
+}
+
+func (engine *CommunityEngine) collectFeed(context actor.Context, msg *collectFeed) {
+	exists := engine.feedThreads(context, msg.MemberID, func(threads []*Thread) interface{} {
+		return &FeedResult{Threads: threads}
+	})
+	if !exists {
+		engine.fail(context, ErrMemberNotFound, "Member not found")
+	}
+}
+
+func (engine *CommunityEngine) collectCommunities(context actor.Context, msg *collectCommunities) {
+	engine.communityViews(context, func(views []*CommunityView) interface{} {
+		return &CommunityList{Communities: views}
+	})
+}
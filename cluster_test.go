@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/cluster"
+)
+
+func TestNodeNumber(t *testing.T) {
+	peers := []string{"127.0.0.1:6330", "127.0.0.1:6340", "[::1]:6350"}
+	tests := []struct {
+		peers          []string
+		membershipPort int
+		want           int
+		wantErr        string
+	}{
+		{peers, 6330, 0, ""},
+		{peers, 6350, 2, ""},
+		{peers, 6360, 0, "no peer uses membership port 6360"},
+		{[]string{"127.0.0.1"}, 6330, 0, `peer "127.0.0.1"`},
+	}
+	for _, test := range tests {
+		config := &ClusterConfig{Peers: test.peers, MembershipPort: test.membershipPort}
+		got, err := config.NodeNumber()
+		if got != test.want || (err == nil) != (test.wantErr == "") || (err != nil && !strings.Contains(err.Error(), test.wantErr)) {
+			t.Errorf("NodeNumber(%v, %d) = %d, %v; want %d, %q", test.peers, test.membershipPort, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestWireRoundTrip(t *testing.T) {
+	messages := []interface{}{
+		&addMember{Username: "member", PasswordHash: "hash", MemberID: "m_1"},
+		&CastVote{MemberID: "m_1", TargetID: "t_1", IsUpvote: true},
+		&CommandFailed{Code: ErrBanned, Reason: "Banned"},
+		&contentLocated{CommunityID: "golang"},
+		&collectCommunities{},
+	}
+	for _, message := range messages {
+		request, err := newGrainRequest(message)
+		if err != nil {
+			t.Errorf("%T: %v", message, err)
+			continue
+		}
+		decoded, err := readGrainResponse(&cluster.GrainResponse{MessageTypeName: request.MessageTypeName, MessageData: request.MessageData})
+		if err != nil || !reflect.DeepEqual(decoded, message) {
+			t.Errorf("%T came back as %#v, %v", message, decoded, err)
+		}
+	}
+}
+
+func TestWireRejections(t *testing.T) {
+	if _, _, err := encodeWire(&RegisterMember{Username: "member", Password: "secret"}); err == nil {
+		t.Error("RegisterMember was encoded for another node, want it hashed locally first")
+	}
+	tests := []struct {
+		typeName string
+		data     string
+		want     string
+	}{
+		{"Unknown", "{}", `unknown message type "Unknown"`},
+		{"CastVote", "[", "unexpected end of JSON input"},
+	}
+	for _, test := range tests {
+		if _, err := decodeWire(test.typeName, []byte(test.data)); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("decodeWire(%s, %s) failed with %v, want %q", test.typeName, test.data, err, test.want)
+		}
+	}
+	if _, err := readGrainResponse(&CommandFailed{}); err == nil {
+		t.Error("readGrainResponse accepted a message that is not a grain response")
+	}
+}
+
+// freePorts finds n ports on localhost that are free to listen on.
+func freePorts(t *testing.T, n int) []int {
+	t.Helper()
+	ports := make([]int, n)
+	for i := range ports {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ports[i] = listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+	}
+	return ports
+}
+
+// startTestCluster starts nodes on localhost, each with an empty sharded
+// engine, and waits until every node sees the others.
+func startTestCluster(t *testing.T, nodes int) []*ClusterNode {
+	t.Helper()
+	ports := freePorts(t, 2*nodes)
+	peers := make([]string, nodes)
+	for i := range peers {
+		peers[i] = fmt.Sprintf("127.0.0.1:%d", ports[nodes+i])
+	}
+	started := make([]*ClusterNode, nodes)
+	for i := range started {
+		idGenerator, err := NewSnowflakeGenerator(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		engine := NewCommunityEngine(idGenerator, NewMemoryStore())
+		engine.sharded = true
+		if err := engine.restore(); err != nil {
+			t.Fatal(err)
+		}
+		system := actor.NewActorSystem()
+		host := newEngineHost(engine)
+		node, err := StartClusterNode(system, host, system.Root.Spawn(host.props()), ClusterConfig{
+			Name:           "test",
+			Address:        fmt.Sprintf("127.0.0.1:%d", ports[i]),
+			MembershipPort: ports[nodes+i],
+			Peers:          peers,
+			Timeout:        5 * time.Second,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(node.Shutdown)
+		started[i] = node
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for _, node := range started {
+		for node.cluster.MemberList.Members().Len() < nodes {
+			if time.Now().After(deadline) {
+				t.Fatalf("node %s sees %d nodes, want %d", node.cluster.ActorSystem.Address(), node.cluster.MemberList.Members().Len(), nodes)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	return started
+}
+
+// clusterExpect sends a request through a node and fails the test unless it
+// is answered with a T.
+func clusterExpect[T any](t *testing.T, node *ClusterNode, message interface{}) T {
+	t.Helper()
+	result, err := node.Request(message)
+	if err != nil {
+		t.Fatalf("%T: %v", message, err)
+	}
+	response, ok := result.(T)
+	if !ok {
+		t.Fatalf("%T answered %#v, want %T", message, result, response)
+	}
+	return response
+}
+
+// localExpect asks a node's own engine, bypassing the grains.
+func localExpect[T any](t *testing.T, node *ClusterNode, message interface{}) T {
+	t.Helper()
+	result, err := node.requestLocal(message)
+	if err != nil {
+		t.Fatalf("%T: %v", message, err)
+	}
+	response, ok := result.(T)
+	if !ok {
+		t.Fatalf("%T answered %#v, want %T", message, result, response)
+	}
+	return response
+}
+
+// TestTwoNodeCluster runs two nodes on localhost and checks that members
+// reach both, that each community lives on the one node its grain is placed
+// on and that profiles add up what both nodes hold.
+func TestTwoNodeCluster(t *testing.T) {
+	nodes := startTestCluster(t, 2)
+
+	// Registration through either node copies the member to both
+	author := clusterExpect[*MemberRegistered](t, nodes[0], &RegisterMember{Username: "author", Password: "password"})
+	voter := clusterExpect[*MemberRegistered](t, nodes[1], &RegisterMember{Username: "voter", Password: "password"})
+	for _, node := range nodes {
+		for _, registered := range []*MemberRegistered{author, voter} {
+			if profile := localExpect[*MemberProfile](t, node, &FetchProfile{MemberID: registered.MemberID}); profile.ID != registered.MemberID {
+				t.Errorf("node %s holds member %s as %s", node.cluster.ActorSystem.Address(), registered.MemberID, profile.ID)
+			}
+		}
+	}
+	if failed := clusterExpect[*CommandFailed](t, nodes[1], &RegisterMember{Username: "Author", Password: "password"}); failed.Code != ErrUsernameTaken {
+		t.Errorf("registering a taken name failed with %s, want %s", failed.Code, ErrUsernameTaken)
+	}
+	// Copying a registration again is answered as done
+	copied, err := nodes[0].requestNode(nodes[1].cluster.ActorSystem.Address(), &addMember{Username: "author", PasswordHash: "hash", MemberID: author.MemberID})
+	if registered, ok := copied.(*MemberRegistered); err != nil || !ok || registered.MemberID != author.MemberID {
+		t.Errorf("copying a registration again answered %#v, %v", copied, err)
+	}
+
+	// Each community lives only on the node its grain is placed on, and is
+	// reached through either node
+	placed := make(map[int]string)
+	for _, name := range []string{"golang", "rustlang", "python", "haskell", "ocaml", "erlang", "elixir", "kotlin"} {
+		clusterExpect[*CommunityCreated](t, nodes[0], &CreateCommunity{Name: name, FounderID: author.MemberID})
+		holders := 0
+		for i, node := range nodes {
+			result, err := node.requestLocal(&FetchCommunity{Name: name})
+			if _, ok := result.(*CommunityView); err == nil && ok {
+				holders++
+				placed[i] = name
+			}
+			clusterExpect[*CommunityView](t, node, &FetchCommunity{Name: name})
+		}
+		if holders != 1 {
+			t.Errorf("community %s is held by %d nodes, want 1", name, holders)
+		}
+	}
+	if len(placed) != len(nodes) {
+		t.Fatalf("communities were placed on %d nodes, want %d", len(placed), len(nodes))
+	}
+
+	// A thread on each node, each upvoted once, adds up to karma 2
+	for i := range nodes {
+		thread := clusterExpect[*ThreadCreated](t, nodes[i], &CreateThread{Title: "Title", Content: "Content", CreatorID: author.MemberID, CommunityID: placed[i]})
+		clusterExpect[*VoteRecorded](t, nodes[1-i], &CastVote{MemberID: voter.MemberID, TargetID: thread.ThreadID, IsUpvote: true})
+		local := localExpect[*MemberProfile](t, nodes[i], &FetchProfile{MemberID: author.MemberID})
+		if local.Karma != 1 || local.ThreadCount != 1 {
+			t.Errorf("node %d holds karma %d and %d threads, want 1 and 1", i, local.Karma, local.ThreadCount)
+		}
+	}
+	for _, node := range nodes {
+		profile := clusterExpect[*MemberProfile](t, node, &FetchProfile{Username: "author"})
+		if profile.Karma != 2 || profile.ThreadCount != 2 {
+			t.Errorf("profile has karma %d and %d threads, want 2 and 2", profile.Karma, profile.ThreadCount)
+		}
+	}
+}
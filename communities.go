@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+const (
+	minCommunityNameLength = 3
+	maxCommunityNameLength = 21
+)
+
+// reservedCommunityNames cannot be claimed because they name site-wide
+// listings or would be confused with staff accounts.
+var reservedCommunityNames = map[string]bool{
+	"all":      true,
+	"popular":  true,
+	"random":   true,
+	"friends":  true,
+	"mod":      true,
+	"mods":     true,
+	"admin":    true,
+	"home":     true,
+	"new":      true,
+	"top":      true,
+	"hot":      true,
+	"settings": true,
+}
+
+// validateCommunityName applies subreddit-style rules: 3 to 21 letters,
+// digits or underscores, not starting with an underscore, and not reserved.
+func validateCommunityName(name string) error {
+	if len(name) < minCommunityNameLength || len(name) > maxCommunityNameLength {
+		return fmt.Errorf("community name must be %d to %d characters", minCommunityNameLength, maxCommunityNameLength)
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '_' && i > 0:
+		default:
+			return fmt.Errorf("community name may only contain letters, digits and underscores, and may not start with an underscore")
+		}
+	}
+	if reservedCommunityNames[strings.ToLower(name)] {
+		return fmt.Errorf("community name %q is reserved", name)
+	}
+	return nil
+}
+
+func (engine *CommunityEngine) createCommunity(context actor.Context, msg *CreateCommunity) {
+	if err := validateCommunityName(msg.Name); err != nil {
+		engine.fail(context, ErrInvalidCommunityName, err.Error())
+		return
+	}
+
+	if _, exists := engine.communityShard(msg.Name); exists {
+		fmt.Printf("[Engine] Failed to create community: Name=%s already exists\n", msg.Name)
+		engine.fail(context, ErrCommunityExists, "Community already exists")
+		return
+	}
+	if _, exists := engine.members[msg.FounderID]; !exists {
+		fmt.Printf("[Engine] Failed to create community: FounderID=%s not found\n", msg.FounderID)
+		engine.fail(context, ErrMemberNotFound, "Founder not found")
+		return
+	}
+	if !engine.accept(context, msg) {
+		return
+	}
+	community := &Community{
+		Name:         msg.Name,
+		Description:  msg.Description,
+		OwnerID:      msg.FounderID,
+		Moderators:   map[string]bool{msg.FounderID: true},
+		Banned:       make(map[string]bool),
+		Participants: map[string]bool{msg.FounderID: true},
+		MemberCount:  1,
+		Threads:      make([]*Thread, 0),
+		ModLog:       make([]*ModLogEntry, 0),
+		CreatedAt:    engine.now(context),
+	}
+	if !engine.persist(context, engine.store.PutCommunity(community)) {
+		return
+	}
+	engine.communities[strings.ToLower(msg.Name)] = newCommunityShard(engine.engineBase, community)
+	engine.changeMembership(msg.FounderID, community.Name, true)
+
+	fmt.Printf("[Engine] New community created: Name=%s, Description=%s, Owner=%s\n", msg.Name, msg.Description, msg.FounderID)
+	engine.respond(context, &CommunityCreated{Name: community.Name, CreatedAt: community.CreatedAt})
+}
+
+func (shard *communityShard) createThread(context actor.Context, msg *CreateThread, members map[string]bool) {
+	community := shard.community
+	if !members[msg.CreatorID] {
+		fmt.Printf("[Engine] Failed to create thread: CreatorID=%s not found\n", msg.CreatorID)
+		shard.fail(context, ErrMemberNotFound, "Member not found")
+		return
+	}
+	if community.Banned[msg.CreatorID] {
+		shard.fail(context, ErrBanned, "Member is banned from this community")
+		return
+	}
+	if !shard.accept(context, msg) {
+		return
+	}
+	threadID := shard.newID(context, ThreadIDPrefix)
+	thread := &Thread{
+		ID:          threadID,
+		Title:       msg.Title,
+		Content:     msg.Content,
+		CreatorID:   msg.CreatorID,
+		CommunityID: community.Name,
+		Replies:     make([]*Reply, 0),
+		CreatedAt:   shard.now(context),
+	}
+	if !shard.persist(context, shard.store.PutThread(thread)) {
+		return
+	}
+	shard.threads[threadID] = thread
+	community.Threads = append(community.Threads, thread)
+	shard.notify(context, &contentAdded{ID: threadID, CommunityID: community.Name})
+	shard.notify(context, &tallyChanged{MemberID: msg.CreatorID, Threads: 1})
+	fmt.Printf("[Engine] New thread created: Title=%s, Community=%s, Creator=%s\n", msg.Title, msg.CommunityID, msg.CreatorID)
+	shard.respond(context, &ThreadCreated{ThreadID: threadID, CreatedAt: thread.CreatedAt})
+}
+
+// viewCommunity snapshots a community.
+func viewCommunity(community *Community) *CommunityView {
+	view := &CommunityView{
+		Name:        community.Name,
+		Description: community.Description,
+		OwnerID:     community.OwnerID,
+		Moderators:  make([]string, 0, len(community.Moderators)),
+		MemberCount: community.MemberCount,
+		CreatedAt:   community.CreatedAt,
+	}
+	for moderatorID := range community.Moderators {
+		view.Moderators = append(view.Moderators, moderatorID)
+	}
+	sort.Strings(view.Moderators)
+	for _, thread := range community.Threads {
+		if listedThread(thread) {
+			view.ThreadCount++
+		}
+	}
+	return view
+}
+
+func (shard *communityShard) fetchCommunity(context actor.Context, msg *FetchCommunity) {
+	shard.respond(context, viewCommunity(shard.community))
+}
+
+// listedThreads copies the community's listed threads.
+func (shard *communityShard) listedThreads() []*Thread {
+	threads := make([]*Thread, 0, len(shard.community.Threads))
+	for _, thread := range shard.community.Threads {
+		if listedThread(thread) {
+			threads = append(threads, snapshotThread(thread))
+		}
+	}
+	return threads
+}
+
+func (shard *communityShard) fetchCommunityThreads(context actor.Context, msg *FetchCommunityThreads) {
+	feedSort := msg.Sort
+	if feedSort == "" {
+		feedSort = FeedSortHot
+	}
+	if !validFeedSort(feedSort) {
+		shard.fail(context, ErrInvalidRequest, "Unknown feed sort")
+		return
+	}
+
+	pinned := make([]*Thread, 0, maxPinnedThreads)
+	threads := make([]*Thread, 0, len(shard.community.Threads))
+	for _, thread := range shard.listedThreads() {
+		if thread.Pinned {
+			pinned = append(pinned, thread)
+		} else {
+			threads = append(threads, thread)
+		}
+	}
+
+	rankThreads(pinned, FeedSortNew)
+	rankThreads(threads, feedSort)
+	page, next, ok := pageAfter(append(pinned, threads...), threadID, msg.After, pageLimit(msg.Limit))
+	if !ok {
+		shard.fail(context, ErrInvalidRequest, "Unknown feed cursor")
+		return
+	}
+	shard.respond(context, &FeedResult{Threads: page, NextCursor: next})
+}
+
+// communityViews asks every community's shard for its view and answers with
+// what combine makes of them.
+func (engine *CommunityEngine) communityViews(context actor.Context, combine func([]*CommunityView) interface{}) {
+	shards := make([]shardActor, 0, len(engine.communities))
+	for _, shard := range engine.communities {
+		shards = append(shards, shard)
+	}
+	engine.askShards(context, shards, &describeCommunity{}, func(answers []interface{}) interface{} {
+		views := make([]*CommunityView, len(answers))
+		for i, answer := range answers {
+			views[i] = answer.(*CommunityView)
+		}
+		return combine(views)
+	})
+}
+
+// pageCommunities orders communities largest first and cuts one page out of
+// them, or fails if after does not name one of them.
+func pageCommunities(views []*CommunityView, after string, limit int) interface{} {
+	sort.Slice(views, func(i, j int) bool {
+		if views[i].MemberCount != views[j].MemberCount {
+			return views[i].MemberCount > views[j].MemberCount
+		}
+		return strings.ToLower(views[i].Name) < strings.ToLower(views[j].Name)
+	})
+	page, next, ok := pageAfter(views, func(view *CommunityView) string { return view.Name }, after, pageLimit(limit))
+	if !ok {
+		return &CommandFailed{Code: ErrInvalidRequest, Reason: "Unknown community cursor"}
+	}
+	return &CommunityList{Communities: page, NextCursor: next}
+}
+
+func (engine *CommunityEngine) listCommunities(context actor.Context, msg *ListCommunities) {
+	engine.communityViews(context, func(views []*CommunityView) interface{} {
+		return pageCommunities(views, msg.After, msg.Limit)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateCommunityName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"golang", true},
+		{"Go_Lang_2", true},
+		{"abc", true},
+		{strings.Repeat("a", maxCommunityNameLength), true},
+		{"ab", false},
+		{strings.Repeat("a", maxCommunityNameLength+1), false},
+		{"_golang", false},
+		{"go-lang", false},
+		{"go lang", false},
+		{"gölang", false},
+		{"popular", false},
+		{"Settings", false},
+	}
+	for _, test := range tests {
+		if err := validateCommunityName(test.name); (err == nil) != test.valid {
+			t.Errorf("validateCommunityName(%q) = %v, want valid %t", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestCreateCommunityRejections(t *testing.T) {
+	test := startTestEngine(t, NewMemoryStore(), false)
+	founderID := test.addMember("founder")
+	test.createCommunity("golang", founderID)
+
+	tests := []struct {
+		name      string
+		community *CreateCommunity
+		code      ErrorCode
+	}{
+		{"invalid name", &CreateCommunity{Name: "go", FounderID: founderID}, ErrInvalidCommunityName},
+		{"same name in another case", &CreateCommunity{Name: "GoLang", FounderID: founderID}, ErrCommunityExists},
+		{"unknown founder", &CreateCommunity{Name: "rust", FounderID: MemberIDPrefix + "missing"}, ErrMemberNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			expectFailure(test.in(t), tc.community, tc.code)
+		})
+	}
+}
+
+func TestCreateThreadRejections(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		test := startTestEngine(t, NewMemoryStore(), sharded)
+		ownerID := test.addMember("owner")
+		bannedID := test.addMember("banned")
+		test.createCommunity("golang", ownerID)
+		expect[*ModActionRecorded](test, &BanMember{ModeratorID: ownerID, CommunityID: "golang", MemberID: bannedID, Banned: true})
+
+		tests := []struct {
+			name   string
+			thread *CreateThread
+			code   ErrorCode
+		}{
+			{"unknown community", &CreateThread{Title: "T", CreatorID: ownerID, CommunityID: "rust"}, ErrCommunityNotFound},
+			{"unknown creator", &CreateThread{Title: "T", CreatorID: MemberIDPrefix + "missing", CommunityID: "golang"}, ErrMemberNotFound},
+			{"banned creator", &CreateThread{Title: "T", CreatorID: bannedID, CommunityID: "golang"}, ErrBanned},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				expectFailure(test.in(t), tc.thread, tc.code)
+			})
+		}
+	}
+}
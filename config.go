@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// configEnvPrefix starts the environment variable that sets each flag: the
+// flag -membership-port is read from COMMUNITY_MEMBERSHIP_PORT.
+const configEnvPrefix = "COMMUNITY_"
+
+// parseCommand parses a command's arguments. Flags missing from the command
+// line are taken from the environment, then from the command's section of
+// the config file, then from their defaults. The config file is JSON with one
+// object of flag values per command:
+//
+//	{"serve": {"http": ":9090", "store": "journal"}, "simulate": {"members": 50}}
+func parseCommand(flags *flag.FlagSet, args []string) error {
+	configPath := flags.String("config", "", "JSON file with flag values for each command, also read from "+envName("config"))
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	if !given["config"] {
+		*configPath = os.Getenv(envName("config"))
+	}
+	section, err := readConfigSection(*configPath, flags.Name())
+	if err != nil {
+		return err
+	}
+	for name := range section {
+		if flags.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("%s: %s has no flag -%s", *configPath, flags.Name(), name)
+		}
+	}
+
+	var setErr error
+	flags.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || f.Name == "config" || setErr != nil {
+			return
+		}
+		if value, exists := os.LookupEnv(envName(f.Name)); exists {
+			if err := flags.Set(f.Name, value); err != nil {
+				setErr = fmt.Errorf("%s: %w", envName(f.Name), err)
+			}
+			return
+		}
+		if value, exists := section[f.Name]; exists {
+			if err := flags.Set(f.Name, value); err != nil {
+				setErr = fmt.Errorf("%s: %s.%s: %w", *configPath, flags.Name(), f.Name, err)
+			}
+		}
+	})
+	return setErr
+}
+
+func envName(flagName string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// readConfigSection reads one command's flag values from the config file, as
+// the strings the command line would carry. An empty path has no values.
+func readConfigSection(path, command string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	section := make(map[string]string, len(config[command]))
+	for name, raw := range config[command] {
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("%s: %s.%s: %w", path, command, name, err)
+		}
+		switch value := value.(type) {
+		case string:
+			section[name] = value
+		case bool:
+			section[name] = strconv.FormatBool(value)
+		case float64:
+			section[name] = string(raw)
+		default:
+			return nil, fmt.Errorf("%s: %s.%s must be a string, number or boolean", path, command, name)
+		}
+	}
+	return section, nil
+}
+
+// commandFailed reports a failed command and gives its exit code. Asking for
+// -help is not a failure.
+func commandFailed(command string, err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "%s: %v\n", command, err)
+	return 2
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file for a test and returns its path.
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseCommandPrecedence(t *testing.T) {
+	config := `{"serve": {"http": ":9090", "shard": false}, "simulate": {"http": ":1111"}}`
+	tests := []struct {
+		name      string
+		args      []string
+		env       map[string]string
+		config    bool
+		wantHTTP  string
+		wantShard bool
+	}{
+		{"defaults", nil, nil, false, ":8080", true},
+		{"config file", nil, nil, true, ":9090", false},
+		{"environment over config file", nil, map[string]string{"COMMUNITY_HTTP": ":7070"}, true, ":7070", false},
+		{"flag over environment", []string{"-http", ":6060"}, map[string]string{"COMMUNITY_HTTP": ":7070"}, true, ":6060", false},
+		{"flag over config file", []string{"-shard=true"}, nil, true, ":9090", true},
+		{"environment names the config file", nil, map[string]string{"COMMUNITY_CONFIG": "from-env"}, false, ":9090", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeConfig(t, config)
+			args := test.args
+			if test.config {
+				args = append([]string{"-config", path}, args...)
+			}
+			for name, value := range test.env {
+				if value == "from-env" {
+					value = path
+				}
+				t.Setenv(name, value)
+			}
+			flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+			httpAddress := flags.String("http", ":8080", "")
+			sharded := flags.Bool("shard", true, "")
+			if err := parseCommand(flags, args); err != nil {
+				t.Fatal(err)
+			}
+			if *httpAddress != test.wantHTTP || *sharded != test.wantShard {
+				t.Errorf("http = %q, shard = %t; want %q, %t", *httpAddress, *sharded, test.wantHTTP, test.wantShard)
+			}
+		})
+	}
+}
+
+func TestParseCommandErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		env    map[string]string
+		want   string
+	}{
+		{"unknown flag in config file", `{"serve": {"port": 1}}`, nil, "serve has no flag -port"},
+		{"config file naming itself", `{"serve": {"config": "other.json"}}`, nil, "serve has no flag -config"},
+		{"invalid value in config file", `{"serve": {"members": "many"}}`, nil, "serve.members"},
+		{"invalid value in environment", `{}`, map[string]string{"COMMUNITY_MEMBERS": "many"}, "COMMUNITY_MEMBERS"},
+		{"malformed config file", `{"serve": [1]}`, nil, "config.json"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+			flags.Int("members", 10, "")
+			err := parseCommand(flags, []string{"-config", writeConfig(t, test.config)})
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("parseCommand failed with %v, want an error mentioning %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestReadConfigSection(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"values as the command line carries them", `{"serve": {"http": ":9090", "members": 50, "rate": 2.5, "shard": false}}`,
+			map[string]string{"http": ":9090", "members": "50", "rate": "2.5", "shard": "false"}, false},
+		{"missing section", `{"simulate": {"members": 50}}`, map[string]string{}, false},
+		{"nested object", `{"serve": {"http": {"port": 1}}}`, nil, true},
+		{"list", `{"serve": {"peers": ["a", "b"]}}`, nil, true},
+		{"null", `{"serve": {"http": null}}`, nil, true},
+	}
+	for _, test := range tests {
+		got, err := readConfigSection(writeConfig(t, test.config), "serve")
+		if (err != nil) != test.wantErr || (!test.wantErr && !reflect.DeepEqual(got, test.want)) {
+			t.Errorf("%s: readConfigSection = %v, %v; want %v, error %t", test.name, got, err, test.want, test.wantErr)
+		}
+	}
+	if section, err := readConfigSection("", "serve"); section != nil || err != nil {
+		t.Errorf("readConfigSection without a path = %v, %v; want no values", section, err)
+	}
+}
+
+func TestCommandFailed(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{flag.ErrHelp, 0},
+		{errors.New("bad flag"), 2},
+	}
+	for _, test := range tests {
+		if got := commandFailed("test", test.err); got != test.want {
+			t.Errorf("commandFailed(%v) = %d, want %d", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+// deletedPlaceholder replaces the content of threads and replies deleted by
+// their author.
+const deletedPlaceholder = "[deleted]"
+
+// authorizeChange checks that memberID may edit or delete content written by
+// authorID in the community. When a moderator changes someone else's content
+// it returns the community so the change can be logged; otherwise nil.
+func (shard *communityShard) authorizeChange(context actor.Context, community *Community, authorID, memberID string) (*Community, bool) {
+	if memberID == authorID {
+		return nil, true
+	}
+	if !community.Moderators[memberID] {
+		shard.fail(context, ErrNotModerator, "Only the author or a moderator can do that")
+		return nil, false
+	}
+	return community, true
+}
+
+// communityModAction describes a change to someone else's content for the
+// audit log of the community authorizeChange returned, or returns nil for a
+// change by the author, which is not logged.
+func (shard *communityShard) communityModAction(context actor.Context, community *Community, moderatorID, action, targetID string) *ModLogEntry {
+	if community == nil {
+		return nil
+	}
+	return shard.newModAction(context, moderatorID, action, targetID, "")
+}
+
+// editableThread resolves a thread that has been neither deleted nor removed.
+func (shard *communityShard) editableThread(context actor.Context, threadID string) (*Thread, bool) {
+	thread, exists := shard.threads[threadID]
+	if !exists {
+		shard.fail(context, ErrThreadNotFound, "Thread not found")
+		return nil, false
+	}
+	if thread.Deleted || thread.Removed {
+		shard.fail(context, ErrContentDeleted, "Thread has been deleted or removed")
+		return nil, false
+	}
+	return thread, true
+}
+
+func (shard *communityShard) editableReply(context actor.Context, replyID string) (*Reply, bool) {
+	reply, exists := shard.replies[replyID]
+	if !exists {
+		shard.fail(context, ErrReplyNotFound, "Reply not found")
+		return nil, false
+	}
+	if reply.Deleted || reply.Removed {
+		shard.fail(context, ErrContentDeleted, "Reply has been deleted or removed")
+		return nil, false
+	}
+	return reply, true
+}
+
+func (shard *communityShard) editThread(context actor.Context, msg *EditThread) {
+	if msg.Title == "" && msg.Content == "" {
+		shard.fail(context, ErrInvalidRequest, "Nothing to change")
+		return
+	}
+	thread, ok := shard.editableThread(context, msg.ThreadID)
+	if !ok {
+		return
+	}
+	community, ok := shard.authorizeChange(context, shard.community, thread.CreatorID, msg.EditorID)
+	if !ok || !shard.accept(context, msg) {
+		return
+	}
+
+	editedAt := shard.now(context)
+	edited := *thread
+	edited.History = append(append([]*Revision(nil), thread.History...), &Revision{
+		Title:    thread.Title,
+		Content:  thread.Content,
+		EditorID: msg.EditorID,
+		EditedAt: editedAt,
+	})
+	if msg.Title != "" {
+		edited.Title = msg.Title
+	}
+	if msg.Content != "" {
+		edited.Content = msg.Content
+	}
+	edited.EditedAt = &editedAt
+	entry := shard.communityModAction(context, community, msg.EditorID, ModActionEditThread, thread.ID)
+	if !shard.persist(context, shard.store.PutThread(&edited)) || !shard.storeModAction(context, community, entry) {
+		return
+	}
+	thread.History, thread.Title, thread.Content, thread.EditedAt = edited.History, edited.Title, edited.Content, edited.EditedAt
+	shard.logModAction(community, entry)
+	fmt.Printf("[Engine] Thread edited: ThreadID=%s, Editor=%s\n", thread.ID, msg.EditorID)
+	shard.respond(context, &ContentEdited{TargetID: thread.ID, EditedAt: editedAt})
+}
+
+func (shard *communityShard) editReply(context actor.Context, msg *EditReply) {
+	if msg.Content == "" {
+		shard.fail(context, ErrInvalidRequest, "Nothing to change")
+		return
+	}
+	reply, ok := shard.editableReply(context, msg.ReplyID)
+	if !ok {
+		return
+	}
+	community, ok := shard.authorizeChange(context, shard.community, reply.CreatorID, msg.EditorID)
+	if !ok || !shard.accept(context, msg) {
+		return
+	}
+
+	editedAt := shard.now(context)
+	edited := *reply
+	edited.History = append(append([]*Revision(nil), reply.History...), &Revision{
+		Content:  reply.Content,
+		EditorID: msg.EditorID,
+		EditedAt: editedAt,
+	})
+	edited.Content = msg.Content
+	edited.EditedAt = &editedAt
+	entry := shard.communityModAction(context, community, msg.EditorID, ModActionEditReply, reply.ID)
+	if !shard.persist(context, shard.store.PutReply(&edited)) || !shard.storeModAction(context, community, entry) {
+		return
+	}
+	reply.History, reply.Content, reply.EditedAt = edited.History, edited.Content, edited.EditedAt
+	shard.logModAction(community, entry)
+	fmt.Printf("[Engine] Reply edited: ReplyID=%s, Editor=%s\n", reply.ID, msg.EditorID)
+	shard.respond(context, &ContentEdited{TargetID: reply.ID, EditedAt: editedAt})
+}
+
+// deleteThread hides the thread from listings and blanks it in reads. Its
+// replies stay where they are.
+func (shard *communityShard) deleteThread(context actor.Context, msg *DeleteThread) {
+	thread, exists := shard.threads[msg.ThreadID]
+	if !exists {
+		shard.fail(context, ErrThreadNotFound, "Thread not found")
+		return
+	}
+	if thread.Deleted {
+		shard.fail(context, ErrContentDeleted, "Thread has already been deleted")
+		return
+	}
+	community, ok := shard.authorizeChange(context, shard.community, thread.CreatorID, msg.MemberID)
+	if !ok || !shard.accept(context, msg) {
+		return
+	}
+	deleted := *thread
+	deleted.Deleted, deleted.Pinned = true, false
+	entry := shard.communityModAction(context, community, msg.MemberID, ModActionDeleteThread, thread.ID)
+	if !shard.persist(context, shard.store.PutThread(&deleted)) || !shard.storeModAction(context, community, entry) {
+		return
+	}
+	thread.Deleted, thread.Pinned = true, false
+	shard.logModAction(community, entry)
+	fmt.Printf("[Engine] Thread deleted: ThreadID=%s, By=%s\n", thread.ID, msg.MemberID)
+	shard.respond(context, &ContentDeleted{TargetID: thread.ID, DeletedAt: shard.now(context)})
+}
+
+func (shard *communityShard) deleteReply(context actor.Context, msg *DeleteReply) {
+	reply, exists := shard.replies[msg.ReplyID]
+	if !exists {
+		shard.fail(context, ErrReplyNotFound, "Reply not found")
+		return
+	}
+	if reply.Deleted {
+		shard.fail(context, ErrContentDeleted, "Reply has already been deleted")
+		return
+	}
+	community, ok := shard.authorizeChange(context, shard.community, reply.CreatorID, msg.MemberID)
+	if !ok || !shard.accept(context, msg) {
+		return
+	}
+	deleted := *reply
+	deleted.Deleted = true
+	entry := shard.communityModAction(context, community, msg.MemberID, ModActionDeleteReply, reply.ID)
+	if !shard.persist(context, shard.store.PutReply(&deleted)) || !shard.storeModAction(context, community, entry) {
+		return
+	}
+	reply.Deleted = true
+	shard.logModAction(community, entry)
+	fmt.Printf("[Engine] Reply deleted: ReplyID=%s, By=%s\n", reply.ID, msg.MemberID)
+	shard.respond(context, &ContentDeleted{TargetID: reply.ID, DeletedAt: shard.now(context)})
+}
+
+// fetchRevisions returns the edit history of live content. Deleted and
+// removed content keeps its history, but it is no longer served.
+func (shard *communityShard) fetchRevisions(context actor.Context, msg *FetchRevisions) {
+	var history []*Revision
+	if _, isThread := shard.threads[msg.TargetID]; isThread {
+		thread, ok := shard.editableThread(context, msg.TargetID)
+		if !ok {
+			return
+		}
+		history = thread.History
+	} else {
+		reply, ok := shard.editableReply(context, msg.TargetID)
+		if !ok {
+			return
+		}
+		history = reply.History
+	}
+	revisions := make([]*Revision, len(history))
+	copy(revisions, history)
+	shard.respond(context, &Revisions{TargetID: msg.TargetID, Revisions: revisions})
+}
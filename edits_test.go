@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestEditAndDeleteContent(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		test := startTestEngine(t, NewMemoryStore(), sharded)
+		ownerID := test.addMember("owner")
+		authorID := test.addMember("author")
+		otherID := test.addMember("other")
+		test.createCommunity("golang", ownerID)
+		threadID := test.createThread("golang", authorID)
+		doomedID := test.createThread("golang", authorID)
+		replyID := test.createReply(threadID, "", authorID)
+		doomedReplyID := test.createReply(threadID, "", authorID)
+
+		tests := []struct {
+			name    string
+			command interface{}
+			code    ErrorCode
+		}{
+			{name: "nothing to change", command: &EditThread{EditorID: authorID, ThreadID: threadID}, code: ErrInvalidRequest},
+			{name: "unknown thread", command: &EditThread{EditorID: authorID, ThreadID: "missing", Title: "New"}, code: ErrThreadNotFound},
+			{name: "another member edits a thread", command: &EditThread{EditorID: otherID, ThreadID: threadID, Title: "New"}, code: ErrNotModerator},
+			{name: "author edits a thread", command: &EditThread{EditorID: authorID, ThreadID: threadID, Title: "New"}},
+			{name: "moderator edits a thread", command: &EditThread{EditorID: ownerID, ThreadID: threadID, Content: "Moderated"}},
+			{name: "unknown reply", command: &EditReply{EditorID: authorID, ReplyID: "missing", Content: "New"}, code: ErrReplyNotFound},
+			{name: "another member edits a reply", command: &EditReply{EditorID: otherID, ReplyID: replyID, Content: "New"}, code: ErrNotModerator},
+			{name: "author edits a reply", command: &EditReply{EditorID: authorID, ReplyID: replyID, Content: "New"}},
+			{name: "another member deletes a reply", command: &DeleteReply{MemberID: otherID, ReplyID: doomedReplyID}, code: ErrNotModerator},
+			{name: "moderator deletes a reply", command: &DeleteReply{MemberID: ownerID, ReplyID: doomedReplyID}},
+			{name: "delete a reply twice", command: &DeleteReply{MemberID: ownerID, ReplyID: doomedReplyID}, code: ErrContentDeleted},
+			{name: "edit a deleted reply", command: &EditReply{EditorID: authorID, ReplyID: doomedReplyID, Content: "Back"}, code: ErrContentDeleted},
+			{name: "author deletes a thread", command: &DeleteThread{MemberID: authorID, ThreadID: doomedID}},
+			{name: "delete a thread twice", command: &DeleteThread{MemberID: authorID, ThreadID: doomedID}, code: ErrContentDeleted},
+			{name: "edit a deleted thread", command: &EditThread{EditorID: authorID, ThreadID: doomedID, Title: "Back"}, code: ErrContentDeleted},
+			{name: "history of a deleted thread", command: &FetchRevisions{TargetID: doomedID}, code: ErrContentDeleted},
+			{name: "history of unknown content", command: &FetchRevisions{TargetID: "missing"}, code: ErrTargetNotFound},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				test := test.in(t)
+				if tc.code != "" {
+					expectFailure(test, tc.command, tc.code)
+					return
+				}
+				if failed, ok := test.request(tc.command).(*CommandFailed); ok {
+					t.Fatalf("%T failed with %s (%s)", tc.command, failed.Code, failed.Reason)
+				}
+			})
+		}
+
+		deleted := expect[*ThreadTree](test, &FetchThread{ThreadID: doomedID})
+		if deleted.Title != deletedPlaceholder || deleted.Content != deletedPlaceholder || deleted.CreatorID != "" {
+			t.Errorf("deleted thread shows %q, %q by %q, want placeholders and no author", deleted.Title, deleted.Content, deleted.CreatorID)
+		}
+		replies := expect[*ThreadTree](test, &FetchThread{ThreadID: threadID}).Replies
+		if len(replies) != 2 || replies[1].Content != deletedPlaceholder || replies[1].CreatorID != "" {
+			t.Errorf("thread replies %v, want the deleted reply as a placeholder without an author", replies)
+		}
+
+		history := expect[*Revisions](test, &FetchRevisions{TargetID: threadID}).Revisions
+		if len(history) != 2 || history[0].Title != "Title" || history[1].Title != "New" || history[1].EditorID != ownerID {
+			t.Errorf("thread history %v, want the original then the author's edit", history)
+		}
+		if history := expect[*Revisions](test, &FetchRevisions{TargetID: replyID}).Revisions; len(history) != 1 || history[0].Content != "Reply" {
+			t.Errorf("reply history %v, want the original", history)
+		}
+		log := expect[*ModLog](test, &FetchModLog{ModeratorID: ownerID, CommunityID: "golang"}).Entries
+		if len(log) != 2 || log[0].Action != ModActionEditThread || log[1].Action != ModActionDeleteReply {
+			t.Errorf("mod log %v, want only the moderator's edit and deletion", log)
+		}
+	}
+}
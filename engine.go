@@ -1,123 +1,237 @@
-
 package main
 
 import (
 	"fmt"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/asynkron/protoactor-go/actor"
 )
 
 type CommunityEngine struct {
-	members         map[string]*Member
-	communities     map[string]*Community
-	privateMessages map[string][]*PrivateMessage
-	threads         map[string]*Thread
-	lock            sync.RWMutex
+	*engineBase
+
+	// The engine's directories: every member, username, session and
+	// community, where each thread, reply and message lives, and who joined
+	// what. Communities are keyed by their lower-cased name. Only the
+	// engine's actor touches them; karma and counts change as its shards
+	// report votes and new content.
+	members      map[string]*Member
+	usernames    map[string]string
+	sessions     map[string]*Session
+	communities  map[string]*communityShard
+	memberShards map[string]*memberShard
+	content      map[string]string // thread or reply ID to community name
+	messages     map[string]*messageEntry
+	memberships  map[string]map[string]bool
+
+	// sharded runs the commands of each community and member on a child
+	// actor owning that shard. Without it the engine applies every command
+	// itself.
+	sharded bool
+	// background is shared by every incarnation of the engine.
+	background *backgroundWork
+}
+
+// engineBase is what the engine shares with its shards: the store and
+// journal every change is written to, the ID generator, and notes, which
+// takes the notes of shards whose commands the engine applies itself.
+type engineBase struct {
+	idGenerator IDGenerator
+	store       Store
+	journal     *Journal
+	notes       func(context actor.Context, note interface{})
+}
+
+func NewCommunityEngine(idGenerator IDGenerator, store Store) *CommunityEngine {
+	engine := &CommunityEngine{
+		engineBase:   &engineBase{idGenerator: idGenerator, store: store},
+		members:      make(map[string]*Member),
+		usernames:    make(map[string]string),
+		sessions:     make(map[string]*Session),
+		communities:  make(map[string]*communityShard),
+		memberShards: make(map[string]*memberShard),
+		content:      make(map[string]string),
+		messages:     make(map[string]*messageEntry),
+		memberships:  make(map[string]map[string]bool),
+		background:   &backgroundWork{},
+	}
+	engine.notes = engine.applyNote
+	return engine
+}
+
+// eventContext carries the time and ID recorded with the journaled event a
+// command is applied from, so replaying it rebuilds exactly the same state.
+// Sequence is zero until accept journals the command. Replayed events have
+// no actor context.
+type eventContext struct {
+	actor.Context
+	at         time.Time
+	reservedID string
+	sequence   uint64
+}
+
+func (context *eventContext) Sender() *actor.PID {
+	if context.Context == nil {
+		return nil
+	}
+	return context.Context.Sender()
 }
 
-func NewCommunityEngine() *CommunityEngine {
-	return &CommunityEngine{
-		members:         make(map[string]*Member),
-		communities:     make(map[string]*Community),
-		privateMessages: make(map[string][]*PrivateMessage),
-		threads:         make(map[string]*Thread),
+// newID draws the next ID for a thing of the given kind, or takes the one
+// reserved for the event being applied.
+func (engine *engineBase) newID(context actor.Context, prefix string) string {
+	if event, ok := context.(*eventContext); ok && strings.HasPrefix(event.reservedID, prefix) {
+		id := event.reservedID
+		event.reservedID = ""
+		return id
 	}
+	return engine.idGenerator.NewID(prefix)
 }
 
-func generateID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
+// now is the time a command takes effect: the time recorded with the event
+// being applied, or the wall clock when nothing is journaled.
+func (engine *engineBase) now(context actor.Context) time.Time {
+	if event, ok := context.(*eventContext); ok && event.sequence != 0 {
+		return event.at
+	}
+	return time.Now()
+}
+
+// persist reports whether the store took a write. Commands write what they
+// change before changing it in memory, so a command whose write fails is
+// rejected and leaves the engine as it was; its journal event is discarded.
+// Records the command wrote before the failed one stay in the store.
+func (engine *engineBase) persist(context actor.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+	fmt.Printf("[Engine] Failed to persist change: %v\n", err)
+	engine.discard(context)
+	engine.fail(context, ErrUnavailable, "Could not store the change")
+	return false
+}
+
+// respond replies to the sender of the current message. Commands delivered
+// with Send have no sender and replayed commands no context, so there is
+// nobody to answer.
+func (engine *engineBase) respond(context actor.Context, response interface{}) {
+	if context != nil && context.Sender() != nil {
+		context.Respond(response)
+	}
+}
+
+// fail reports a rejected command back to its sender.
+func (engine *engineBase) fail(context actor.Context, code ErrorCode, reason string) {
+	engine.respond(context, &CommandFailed{Code: code, Reason: reason})
+}
+
+// running reports whether a command came from the engine's actor, rather
+// than from the journal being replayed before the actor starts.
+func running(context actor.Context) bool {
+	if event, ok := context.(*eventContext); ok {
+		return event.Context != nil
+	}
+	return context != nil
 }
 
 func (engine *CommunityEngine) Receive(context actor.Context) {
+	defer engine.answerFailure(context)
 	switch msg := context.Message().(type) {
+	case *actor.Restarting:
+		fmt.Println("[Engine] Restarting after a failure")
+		return
+	case *actor.Stopping:
+		engine.stopShards(context)
+		return
+	case *shardNote:
+		engine.receiveNote(context, msg)
+	default:
+		engine.journaling(context, msg, func(context actor.Context) {
+			engine.apply(context, msg)
+		})
+	}
+	if engine.journal != nil && engine.journal.snapshotDue() {
+		engine.saveSnapshot(context)
+	}
+}
+
+// apply runs a command against the engine's state, passing commands on a
+// single community or member to its shard.
+func (engine *CommunityEngine) apply(context actor.Context, command interface{}) {
+	if reference, _ := communityCommand(command); reference != "" {
+		engine.routeCommunity(context, command)
+		return
+	}
+	switch msg := command.(type) {
 
 	case *RegisterMember:
-		engine.lock.Lock()
-		memberID := generateID()
-		member := &Member{
-			ID:       memberID,
-			Username: msg.Username,
-			Password: msg.Password,
-			Karma:    0,
-		}
-		engine.members[memberID] = member
-		engine.lock.Unlock()
-		fmt.Printf("[Engine] New member registered: Username=%s, ID=%s\n", msg.Username, memberID)
+		engine.registerMember(context, msg)
+
+	case *addMember:
+		engine.addMember(context, msg)
+
+	case *FetchProfile:
+		engine.fetchProfile(context, msg)
+
+	case *Login:
+		engine.login(context, msg)
+
+	case *openSession:
+		engine.openSession(context, msg)
+
+	case *Logout:
+		engine.logout(context, msg)
+
+	case *RevokeSessions:
+		engine.revokeSessions(context, msg)
+
+	case *ResolveSession:
+		engine.resolveSession(context, msg)
 
 	case *CreateCommunity:
-		engine.lock.Lock()
-		community := &Community{
-			Name:         msg.Name,
-			Description:  msg.Description,
-			Participants: make(map[string]bool),
-			Threads:      make([]*Thread, 0),
-		}
-		engine.communities[msg.Name] = community
-		engine.lock.Unlock()
-		fmt.Printf("[Engine] New community created: Name=%s, Description=%s\n", msg.Name, msg.Description)
-
-	case *CreateThread:
-		engine.lock.Lock()
-		threadID := generateID()
-		thread := &Thread{
-			ID:          threadID,
-			Title:       msg.Title,
-			Content:     msg.Content,
-			CreatorID:   msg.CreatorID,
-			CommunityID: msg.CommunityID,
-			Replies:     make([]*Reply, 0),
-			CreatedAt:   time.Now(),
-		}
-		engine.threads[threadID] = thread
-		if community, exists := engine.communities[msg.CommunityID]; exists {
-			community.Threads = append(community.Threads, thread)
-		}
-		engine.lock.Unlock()
-		fmt.Printf("[Engine] New thread created: Title=%s, Community=%s, Creator=%s\n", msg.Title, msg.CommunityID, msg.CreatorID)
-
-	case *CreateReply:
-		engine.lock.Lock()
-		replyID := generateID()
-		if thread, exists := engine.threads[msg.ThreadID]; exists {
-			reply := &Reply{
-				ID:        replyID,
-				Content:   msg.Content,
-				CreatorID: msg.CreatorID,
-				ThreadID:  msg.ThreadID,
-				ParentID:  msg.ParentID,
-				Replies:   make([]*Reply, 0),
-				CreatedAt: time.Now(),
-			}
-			thread.Replies = append(thread.Replies, reply)
-			fmt.Printf("[Engine] New reply added: ThreadID=%s, Content=%s, Creator=%s\n", msg.ThreadID, msg.Content, msg.CreatorID)
-		} else {
-			fmt.Printf("[Engine] Failed to add reply: ThreadID=%s not found\n", msg.ThreadID)
-		}
-		engine.lock.Unlock()
-
-	case *CastVote:
-		engine.lock.Lock()
-		if msg.IsUpvote {
-			fmt.Printf("[Engine] Upvote recorded: TargetID=%s, MemberID=%s\n", msg.TargetID, msg.MemberID)
-		} else {
-			fmt.Printf("[Engine] Downvote recorded: TargetID=%s, MemberID=%s\n", msg.TargetID, msg.MemberID)
-		}
-		engine.lock.Unlock()
+		engine.createCommunity(context, msg)
+
+	case *ListCommunities:
+		engine.listCommunities(context, msg)
+
+	case *ListMemberCommunities:
+		engine.listMemberCommunities(context, msg)
+
+	case *FetchFeed:
+		engine.fetchFeed(context, msg)
 
 	case *SendMessage:
-		engine.lock.Lock()
-		messageID := generateID()
-		privateMessage := &PrivateMessage{
-			ID:         messageID,
-			SenderID:   msg.SenderID,
-			ReceiverID: msg.ReceiverID,
-			Content:    msg.Content,
-			CreatedAt:  time.Now(),
-		}
-		engine.privateMessages[msg.ReceiverID] = append(engine.privateMessages[msg.ReceiverID], privateMessage)
-		engine.lock.Unlock()
-		fmt.Printf("[Engine] Message sent: From=%s, To=%s, Content=%s\n", msg.SenderID, msg.ReceiverID, msg.Content)
+		engine.sendMessage(context, msg)
+
+	case *FetchInbox:
+		engine.routeMember(context, msg.MemberID, msg)
+
+	case *FetchSent:
+		engine.routeMember(context, msg.MemberID, msg)
+
+	case *ListConversations:
+		engine.routeMember(context, msg.MemberID, msg)
+
+	case *FetchConversation:
+		engine.fetchConversation(context, msg)
+
+	case *MarkMessageRead:
+		engine.markMessageRead(context, msg)
+
+	case *BlockMember:
+		engine.blockMember(context, msg)
+
+	case *locateContent:
+		engine.locateContent(context, msg)
+
+	case *collectFeed:
+		engine.collectFeed(context, msg)
+
+	case *collectCommunities:
+		engine.collectCommunities(context, msg)
+
+	case *collectMessages:
+		engine.routeMember(context, msg.MemberID, msg)
 	}
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+// testEngine runs an engine actor for a test and sends it commands.
+type testEngine struct {
+	t      testing.TB
+	system *actor.ActorSystem
+	pid    *actor.PID
+	host   *engineHost
+}
+
+// startTestEngine restores an engine from store and starts its actor.
+func startTestEngine(t testing.TB, store Store, sharded bool) *testEngine {
+	t.Helper()
+	engine := newTestEngine(t, store, sharded)
+	if err := engine.restore(); err != nil {
+		t.Fatal(err)
+	}
+	return runTestEngine(t, engine)
+}
+
+func newTestEngine(t testing.TB, store Store, sharded bool) *CommunityEngine {
+	t.Helper()
+	idGenerator, err := NewSnowflakeGenerator(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := NewCommunityEngine(idGenerator, store)
+	engine.sharded = sharded
+	return engine
+}
+
+// runTestEngine starts the actor of an engine already holding its state. The
+// actor system is shut down when the test ends.
+func runTestEngine(t testing.TB, engine *CommunityEngine) *testEngine {
+	system := actor.NewActorSystem()
+	t.Cleanup(system.Shutdown)
+	host := newEngineHost(engine)
+	return &testEngine{t: t, system: system, pid: system.Root.Spawn(host.props()), host: host}
+}
+
+// in is the same engine reporting to a subtest.
+func (test *testEngine) in(t *testing.T) *testEngine {
+	sub := *test
+	sub.t = t
+	return &sub
+}
+
+// request sends a command and waits for its response.
+func (test *testEngine) request(message interface{}) interface{} {
+	test.t.Helper()
+	result, err := test.system.Root.RequestFuture(test.pid, message, 5*time.Second).Result()
+	if err != nil {
+		test.t.Fatalf("%T: %v", message, err)
+	}
+	return result
+}
+
+// expect sends a command and fails the test unless the response has type T.
+func expect[T any](test *testEngine, message interface{}) T {
+	test.t.Helper()
+	result := test.request(message)
+	response, ok := result.(T)
+	if !ok {
+		test.t.Fatalf("%T answered %#v, want %T", message, result, response)
+	}
+	return response
+}
+
+// expectFailure sends a command and fails the test unless it is rejected
+// with code.
+func expectFailure(test *testEngine, message interface{}, code ErrorCode) {
+	test.t.Helper()
+	failed := expect[*CommandFailed](test, message)
+	if failed.Code != code {
+		test.t.Fatalf("%T failed with %s (%s), want %s", message, failed.Code, failed.Reason, code)
+	}
+}
+
+// addMember registers a member with a placeholder hash, skipping the cost
+// of hashing a password.
+func (test *testEngine) addMember(username string) string {
+	test.t.Helper()
+	return expect[*MemberRegistered](test, &addMember{Username: username, PasswordHash: "test"}).MemberID
+}
+
+func (test *testEngine) createCommunity(name, founderID string) {
+	test.t.Helper()
+	expect[*CommunityCreated](test, &CreateCommunity{Name: name, Description: "Test community", FounderID: founderID})
+}
+
+func (test *testEngine) createThread(communityID, creatorID string) string {
+	test.t.Helper()
+	return expect[*ThreadCreated](test, &CreateThread{Title: "Title", Content: "Content", CreatorID: creatorID, CommunityID: communityID}).ThreadID
+}
+
+func (test *testEngine) createReply(threadID, parentID, creatorID string) string {
+	test.t.Helper()
+	return expect[*ReplyCreated](test, &CreateReply{Content: "Reply", CreatorID: creatorID, ThreadID: threadID, ParentID: parentID}).ReplyID
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// exportVersion is written to every export. Imports reject other versions.
+const exportVersion = 1
+
+// maxImportProblems caps how many integrity problems an import reports.
+const maxImportProblems = 20
+
+// dataset is the export file format: every record the engine holds, with
+// replies nested under their threads. Password hashes are included, so
+// members can log in to an environment seeded from an export.
+type dataset struct {
+	Version     int
+	ExportedAt  time.Time
+	Members     []*storedMember
+	Communities []*Community
+	Threads     []*Thread
+	Votes       []*Vote
+	Messages    []*PrivateMessage
+	Blocks      []*Block
+}
+
+// exportFile writes the engine's state to path. Like importFile, it runs
+// before the engine's actor starts, or after it stopped.
+func (engine *CommunityEngine) exportFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := engine.exportState(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (engine *CommunityEngine) exportState(writer io.Writer) error {
+	snapshot, err := engine.snapshot(nil)
+	if err != nil {
+		return err
+	}
+	export := &dataset{
+		Version:     exportVersion,
+		ExportedAt:  time.Now(),
+		Members:     make([]*storedMember, len(snapshot.Members)),
+		Communities: snapshot.Communities,
+		Threads:     snapshot.Threads,
+		Votes:       snapshot.Votes,
+		Messages:    snapshot.Messages,
+		Blocks:      snapshot.Blocks,
+	}
+	for i, member := range snapshot.Members {
+		export.Members[i] = &storedMember{Member: member, PasswordHash: member.PasswordHash}
+	}
+
+	// Replies are ordered by ID, so parents come before their children
+	threads := make(map[string]*Thread, len(snapshot.Threads))
+	for _, thread := range snapshot.Threads {
+		thread.Replies = make([]*Reply, 0)
+		threads[thread.ID] = thread
+	}
+	replies := make(map[string]*Reply, len(snapshot.Replies))
+	for _, reply := range snapshot.Replies {
+		reply.Replies = make([]*Reply, 0)
+		replies[reply.ID] = reply
+		if parent, exists := replies[reply.ParentID]; exists {
+			parent.Replies = append(parent.Replies, reply)
+		} else if thread, exists := threads[reply.ThreadID]; exists {
+			thread.Replies = append(thread.Replies, reply)
+		}
+	}
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return err
+	}
+	fmt.Printf("[Engine] Exported %d members, %d communities, %d threads, %d replies, %d messages\n",
+		len(snapshot.Members), len(snapshot.Communities), len(snapshot.Threads), len(snapshot.Replies), len(snapshot.Messages))
+	return nil
+}
+
+// importFile loads the export at path into an empty engine and writes every
+// record through to the store, or to a new journal snapshot.
+func (engine *CommunityEngine) importFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return engine.importState(file)
+}
+
+func (engine *CommunityEngine) importState(reader io.Reader) error {
+	export := &dataset{}
+	if err := json.NewDecoder(reader).Decode(export); err != nil {
+		return err
+	}
+	if export.Version != exportVersion {
+		return fmt.Errorf("unsupported export version %d, expected %d", export.Version, exportVersion)
+	}
+	snapshot, err := checkDataset(export)
+	if err != nil {
+		return err
+	}
+
+	existing := len(engine.members) + len(engine.communities)
+	if existing > 0 {
+		return fmt.Errorf("imports need an empty engine, found %d members and communities", existing)
+	}
+	if err := engine.load(snapshot); err != nil {
+		return err
+	}
+
+	if engine.journal != nil {
+		state, err := engine.snapshot(nil)
+		if err != nil {
+			return err
+		}
+		return engine.journal.saveSnapshot(state)
+	}
+	for _, member := range snapshot.Members {
+		if err := engine.store.PutMember(member); err != nil {
+			return err
+		}
+	}
+	for _, community := range snapshot.Communities {
+		if err := engine.store.PutCommunity(community); err != nil {
+			return err
+		}
+		for index, entry := range community.ModLog {
+			if err := engine.store.PutModLogEntry(&ModLogRecord{CommunityID: community.Name, Index: index, ModLogEntry: entry}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, thread := range snapshot.Threads {
+		if err := engine.store.PutThread(thread); err != nil {
+			return err
+		}
+	}
+	for _, reply := range snapshot.Replies {
+		if err := engine.store.PutReply(reply); err != nil {
+			return err
+		}
+	}
+	for _, vote := range snapshot.Votes {
+		if err := engine.store.PutVote(vote); err != nil {
+			return err
+		}
+	}
+	for _, message := range snapshot.Messages {
+		if err := engine.store.PutMessage(message); err != nil {
+			return err
+		}
+	}
+	for _, block := range snapshot.Blocks {
+		if err := engine.store.PutBlock(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importCheck collects the integrity problems found in an import.
+type importCheck struct {
+	problems []string
+}
+
+func (check *importCheck) fail(format string, args ...interface{}) {
+	check.problems = append(check.problems, fmt.Sprintf(format, args...))
+}
+
+func (check *importCheck) err() error {
+	if len(check.problems) == 0 {
+		return nil
+	}
+	shown := check.problems
+	if len(shown) > maxImportProblems {
+		shown = shown[:maxImportProblems]
+	}
+	return fmt.Errorf("import failed %d integrity checks:\n  %s", len(check.problems), strings.Join(shown, "\n  "))
+}
+
+// checkDataset verifies that every reference in export points at a record
+// it contains, and flattens it into a Snapshot ordered as Store.Load orders
+// one. Threads are given their community's name as the community spells it.
+func checkDataset(export *dataset) (*Snapshot, error) {
+	check := &importCheck{}
+	snapshot := &Snapshot{
+		Communities: export.Communities,
+		Threads:     export.Threads,
+		Votes:       export.Votes,
+		Messages:    export.Messages,
+		Blocks:      export.Blocks,
+	}
+
+	members := make(map[string]bool)
+	usernames := make(map[string]bool)
+	for _, stored := range export.Members {
+		if stored == nil || stored.Member == nil || !strings.HasPrefix(stored.ID, MemberIDPrefix) {
+			check.fail("member without a valid ID")
+			continue
+		}
+		if members[stored.ID] {
+			check.fail("member %s appears twice", stored.ID)
+		}
+		if usernames[strings.ToLower(stored.Username)] {
+			check.fail("username %s appears twice", stored.Username)
+		}
+		if stored.PasswordHash == "" {
+			check.fail("member %s has no password hash", stored.ID)
+		}
+		members[stored.ID] = true
+		usernames[strings.ToLower(stored.Username)] = true
+		stored.Member.PasswordHash = stored.PasswordHash
+		snapshot.Members = append(snapshot.Members, stored.Member)
+	}
+	memberExists := func(kind, id, memberID string) {
+		if !members[memberID] {
+			check.fail("%s %s refers to unknown member %s", kind, id, memberID)
+		}
+	}
+
+	// Community names by lower-case key, since the engine looks communities
+	// up case-insensitively but keys their actors by the exact name
+	communities := make(map[string]string)
+	for _, community := range export.Communities {
+		if community == nil {
+			check.fail("empty community record")
+			continue
+		}
+		if err := validateCommunityName(community.Name); err != nil {
+			check.fail("community %q: %v", community.Name, err)
+			continue
+		}
+		key := strings.ToLower(community.Name)
+		if communities[key] != "" {
+			check.fail("community %s appears twice", community.Name)
+		}
+		communities[key] = community.Name
+		memberExists("community", community.Name, community.OwnerID)
+		for _, flags := range []map[string]bool{community.Moderators, community.Banned, community.Participants} {
+			for memberID := range flags {
+				memberExists("community", community.Name, memberID)
+			}
+		}
+		if community.MemberCount != len(community.Participants) {
+			check.fail("community %s counts %d members but lists %d", community.Name, community.MemberCount, len(community.Participants))
+		}
+		for _, entry := range community.ModLog {
+			memberExists("community", community.Name, entry.ModeratorID)
+		}
+		if len(community.Threads) > 0 {
+			check.fail("community %s lists threads; threads belong at the top level", community.Name)
+		}
+	}
+
+	upvotes := make(map[string]int)
+	downvotes := make(map[string]int)
+	voted := make(map[Vote]bool)
+	for _, vote := range export.Votes {
+		key := Vote{TargetID: vote.TargetID, MemberID: vote.MemberID}
+		if voted[key] {
+			check.fail("member %s votes on %s more than once", vote.MemberID, vote.TargetID)
+			continue
+		}
+		voted[key] = true
+		switch vote.Value {
+		case upvote:
+			upvotes[vote.TargetID]++
+		case downvote:
+			downvotes[vote.TargetID]++
+		default:
+			check.fail("vote by %s on %s has value %d", vote.MemberID, vote.TargetID, vote.Value)
+		}
+	}
+	checkTally := func(kind, id string, up, down int) {
+		if up != upvotes[id] || down != downvotes[id] {
+			check.fail("%s %s tallies %d/%d votes but %d/%d are listed", kind, id, up, down, upvotes[id], downvotes[id])
+		}
+	}
+
+	targets := make(map[string]bool)
+	var checkReplies func(thread *Thread, parent *Reply, replies []*Reply)
+	checkReplies = func(thread *Thread, parent *Reply, replies []*Reply) {
+		for _, reply := range replies {
+			if reply == nil || !strings.HasPrefix(reply.ID, ReplyIDPrefix) {
+				check.fail("reply without a valid ID in thread %s", thread.ID)
+				continue
+			}
+			if targets[reply.ID] {
+				check.fail("reply %s appears twice", reply.ID)
+			}
+			targets[reply.ID] = true
+			if reply.ThreadID != thread.ID {
+				check.fail("reply %s is nested in thread %s but names thread %s", reply.ID, thread.ID, reply.ThreadID)
+			}
+			parentID, depth := "", 0
+			if parent != nil {
+				parentID, depth = parent.ID, parent.Depth+1
+			}
+			if reply.ParentID != parentID {
+				check.fail("reply %s is nested under %q but names parent %q", reply.ID, parentID, reply.ParentID)
+			}
+			// The engine loads replies in ID order, so a parent must sort
+			// first
+			if parent != nil && reply.ID <= parent.ID {
+				check.fail("reply %s sorts before its parent %s", reply.ID, parent.ID)
+			}
+			if reply.Depth != depth {
+				check.fail("reply %s has depth %d, expected %d", reply.ID, reply.Depth, depth)
+			}
+			memberExists("reply", reply.ID, reply.CreatorID)
+			checkTally("reply", reply.ID, reply.Upvotes, reply.Downvotes)
+			snapshot.Replies = append(snapshot.Replies, reply)
+			checkReplies(thread, reply, reply.Replies)
+		}
+	}
+	for _, thread := range export.Threads {
+		if thread == nil || !strings.HasPrefix(thread.ID, ThreadIDPrefix) {
+			check.fail("thread without a valid ID")
+			continue
+		}
+		if targets[thread.ID] {
+			check.fail("thread %s appears twice", thread.ID)
+		}
+		targets[thread.ID] = true
+		if name := communities[strings.ToLower(thread.CommunityID)]; name == "" {
+			check.fail("thread %s refers to unknown community %s", thread.ID, thread.CommunityID)
+		} else {
+			thread.CommunityID = name
+		}
+		memberExists("thread", thread.ID, thread.CreatorID)
+		checkTally("thread", thread.ID, thread.Upvotes, thread.Downvotes)
+		checkReplies(thread, nil, thread.Replies)
+	}
+
+	for _, vote := range export.Votes {
+		if !targets[vote.TargetID] {
+			check.fail("vote by %s refers to unknown target %s", vote.MemberID, vote.TargetID)
+		}
+		memberExists("vote on", vote.TargetID, vote.MemberID)
+	}
+
+	messages := make(map[string]*PrivateMessage)
+	for _, message := range export.Messages {
+		if message == nil || !strings.HasPrefix(message.ID, MessageIDPrefix) {
+			check.fail("message without a valid ID")
+			continue
+		}
+		if messages[message.ID] != nil {
+			check.fail("message %s appears twice", message.ID)
+		}
+		messages[message.ID] = message
+		memberExists("message", message.ID, message.SenderID)
+		memberExists("message", message.ID, message.ReceiverID)
+		if message.ConversationID != conversationID(message.SenderID, message.ReceiverID) {
+			check.fail("message %s has conversation %s, expected %s", message.ID, message.ConversationID, conversationID(message.SenderID, message.ReceiverID))
+		}
+	}
+	for _, message := range export.Messages {
+		if message == nil || message.ReplyToID == "" {
+			continue
+		}
+		if original := messages[message.ReplyToID]; original == nil || original.ConversationID != message.ConversationID {
+			check.fail("message %s replies to %s, which is not in its conversation", message.ID, message.ReplyToID)
+		}
+	}
+	for _, block := range export.Blocks {
+		memberExists("block by", block.MemberID, block.MemberID)
+		memberExists("block by", block.MemberID, block.BlockedID)
+	}
+
+	if err := check.err(); err != nil {
+		return nil, err
+	}
+
+	// Threads are flattened depth first; the engine expects replies by ID
+	for _, thread := range snapshot.Threads {
+		thread.Replies = nil
+	}
+	for _, reply := range snapshot.Replies {
+		reply.Replies = nil
+	}
+	sort.Slice(snapshot.Members, func(i, j int) bool { return snapshot.Members[i].ID < snapshot.Members[j].ID })
+	sort.Slice(snapshot.Threads, func(i, j int) bool { return snapshot.Threads[i].ID < snapshot.Threads[j].ID })
+	sort.Slice(snapshot.Replies, func(i, j int) bool { return snapshot.Replies[i].ID < snapshot.Replies[j].ID })
+	sort.Slice(snapshot.Messages, func(i, j int) bool { return snapshot.Messages[i].ID < snapshot.Messages[j].ID })
+	return snapshot, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// exportTestState builds a community with a thread, a nested reply and a
+// vote, and returns its export.
+func exportTestState(t *testing.T) []byte {
+	t.Helper()
+	test := startTestEngine(t, NewMemoryStore(), true)
+	ownerID := test.addMember("owner")
+	voterID := test.addMember("voter")
+	test.createCommunity("golang", ownerID)
+	threadID := test.createThread("golang", ownerID)
+	replyID := test.createReply(threadID, "", voterID)
+	test.createReply(threadID, replyID, ownerID)
+	expect[*VoteRecorded](test, &CastVote{MemberID: voterID, TargetID: threadID, IsUpvote: true})
+
+	// Exports are taken while the engine's actor is stopped
+	test.system.Root.PoisonFuture(test.pid).Wait()
+	var exported bytes.Buffer
+	if err := test.host.current().exportState(&exported); err != nil {
+		t.Fatal(err)
+	}
+	return exported.Bytes()
+}
+
+func TestImportChecks(t *testing.T) {
+	exported := exportTestState(t)
+	tests := []struct {
+		name    string
+		change  func(export *dataset)
+		wantErr string
+	}{
+		{"unchanged", func(export *dataset) {}, ""},
+		{"community named in another case", func(export *dataset) {
+			export.Threads[0].CommunityID = "GoLang"
+		}, ""},
+		{"unknown community", func(export *dataset) {
+			export.Threads[0].CommunityID = "rust"
+		}, "unknown community rust"},
+		{"reply sorting before its parent", func(export *dataset) {
+			export.Threads[0].Replies[0].Replies[0].ID = ReplyIDPrefix + "0"
+		}, "sorts before its parent"},
+		{"duplicate vote", func(export *dataset) {
+			vote := *export.Votes[0]
+			export.Votes = append(export.Votes, &vote)
+		}, "more than once"},
+		{"duplicate vote in the other direction", func(export *dataset) {
+			vote := *export.Votes[0]
+			vote.Value = downvote
+			export.Votes = append(export.Votes, &vote)
+		}, "more than once"},
+		{"unknown member", func(export *dataset) {
+			export.Threads[0].CreatorID = MemberIDPrefix + "missing"
+		}, "unknown member"},
+		{"wrong tally", func(export *dataset) {
+			export.Threads[0].Upvotes = 2
+		}, "tallies 2/0 votes but 1/0 are listed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			export := &dataset{}
+			if err := json.Unmarshal(exported, export); err != nil {
+				t.Fatal(err)
+			}
+			test.change(export)
+			changed, err := json.Marshal(export)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			engine := newTestEngine(t, NewMemoryStore(), true)
+			err = engine.importState(bytes.NewReader(changed))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("import error = %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("import failed: %v", err)
+			}
+			imported := runTestEngine(t, engine)
+			tree := expect[*ThreadTree](imported, &FetchThread{ThreadID: export.Threads[0].ID})
+			if tree.CommunityID != "golang" || tree.Upvotes != 1 || len(tree.Replies) != 1 || len(tree.Replies[0].Replies) != 1 {
+				t.Errorf("imported thread %+v, want it in golang with one upvote and a nested reply", tree)
+			}
+		})
+	}
+}
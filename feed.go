@@ -0,0 +1,185 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+const (
+	defaultFeedLimit = 25
+	maxFeedLimit     = 100
+)
+
+// hotScore follows Reddit's hot formula: the order of magnitude of the net
+// score plus a time bonus worth one order of magnitude every 12.5 hours,
+// counted from the same epoch as the IDs.
+func hotScore(thread *Thread) float64 {
+	score := thread.Upvotes - thread.Downvotes
+	order := math.Log10(math.Max(math.Abs(float64(score)), 1))
+	sign := 0.0
+	if score > 0 {
+		sign = 1
+	} else if score < 0 {
+		sign = -1
+	}
+	seconds := thread.CreatedAt.Sub(snowflakeEpoch).Seconds()
+	return sign*order + seconds/45000
+}
+
+// controversialScore ranks threads with many, evenly split votes highest.
+func controversialScore(thread *Thread) float64 {
+	if thread.Upvotes <= 0 || thread.Downvotes <= 0 {
+		return 0
+	}
+	magnitude := float64(thread.Upvotes + thread.Downvotes)
+	balance := float64(thread.Downvotes) / float64(thread.Upvotes)
+	if thread.Upvotes <= thread.Downvotes {
+		balance = float64(thread.Upvotes) / float64(thread.Downvotes)
+	}
+	return math.Pow(magnitude, balance)
+}
+
+// rankThreads orders threads in place. Ties fall back to newest first and
+// then ID so that cursors stay stable between pages.
+func rankThreads(threads []*Thread, feedSort FeedSort) {
+	var key func(*Thread) float64
+	switch feedSort {
+	case FeedSortNew:
+		key = func(thread *Thread) float64 { return 0 }
+	case FeedSortTop:
+		key = func(thread *Thread) float64 { return float64(thread.Upvotes - thread.Downvotes) }
+	case FeedSortControversial:
+		key = controversialScore
+	default:
+		key = hotScore
+	}
+	sort.SliceStable(threads, func(i, j int) bool {
+		ki, kj := key(threads[i]), key(threads[j])
+		if ki != kj {
+			return ki > kj
+		}
+		if !threads[i].CreatedAt.Equal(threads[j].CreatedAt) {
+			return threads[i].CreatedAt.After(threads[j].CreatedAt)
+		}
+		return threads[i].ID > threads[j].ID
+	})
+}
+
+func validFeedSort(feedSort FeedSort) bool {
+	switch feedSort {
+	case FeedSortHot, FeedSortNew, FeedSortTop, FeedSortControversial:
+		return true
+	}
+	return false
+}
+
+// pageLimit clamps a requested page size to (0, maxFeedLimit].
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return defaultFeedLimit
+	}
+	if limit > maxFeedLimit {
+		return maxFeedLimit
+	}
+	return limit
+}
+
+// pageAfter returns up to limit items following the one whose ID is after,
+// and the cursor for the next page, which is empty on the last page. It
+// reports false if after does not name an item.
+func pageAfter[T any](items []T, idOf func(T) string, after string, limit int) ([]T, string, bool) {
+	start := 0
+	if after != "" {
+		start = -1
+		for i, item := range items {
+			if idOf(item) == after {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return nil, "", false
+		}
+	}
+	end := start + limit
+	if end > len(items) {
+		end = len(items)
+	}
+	page := items[start:end]
+	next := ""
+	if end < len(items) && len(page) > 0 {
+		next = idOf(page[len(page)-1])
+	}
+	return page, next, true
+}
+
+func threadID(thread *Thread) string {
+	return thread.ID
+}
+
+// listedThread reports whether a thread appears in feeds and listings.
+func listedThread(thread *Thread) bool {
+	return !thread.Removed && !thread.Deleted
+}
+
+// snapshotThread copies a thread for listings, leaving out its replies and
+// edit history.
+func snapshotThread(thread *Thread) *Thread {
+	snapshot := *thread
+	snapshot.Replies = nil
+	snapshot.History = nil
+	return &snapshot
+}
+
+// feedThreads asks the shards of every community the member has joined for
+// their listed threads and answers with what combine makes of them. It
+// reports false if the member does not exist.
+func (engine *CommunityEngine) feedThreads(context actor.Context, memberID string, combine func([]*Thread) interface{}) bool {
+	if _, exists := engine.members[memberID]; !exists {
+		return false
+	}
+	shards := make([]shardActor, 0, len(engine.memberships[memberID]))
+	for name := range engine.memberships[memberID] {
+		if shard, exists := engine.communityShard(name); exists {
+			shards = append(shards, shard)
+		}
+	}
+	engine.askShards(context, shards, &listedThreads{}, func(answers []interface{}) interface{} {
+		threads := make([]*Thread, 0)
+		for _, answer := range answers {
+			threads = append(threads, answer.(*FeedResult).Threads...)
+		}
+		return combine(threads)
+	})
+	return true
+}
+
+// pageFeed ranks threads and cuts one page out of them, or fails if after
+// does not name one of them.
+func pageFeed(threads []*Thread, feedSort FeedSort, after string, limit int) interface{} {
+	rankThreads(threads, feedSort)
+	page, next, ok := pageAfter(threads, threadID, after, pageLimit(limit))
+	if !ok {
+		return &CommandFailed{Code: ErrInvalidRequest, Reason: "Unknown feed cursor"}
+	}
+	return &FeedResult{Threads: page, NextCursor: next}
+}
+
+func (engine *CommunityEngine) fetchFeed(context actor.Context, msg *FetchFeed) {
+	feedSort := msg.Sort
+	if feedSort == "" {
+		feedSort = FeedSortHot
+	}
+	if !validFeedSort(feedSort) {
+		engine.fail(context, ErrInvalidRequest, "Unknown feed sort")
+		return
+	}
+	exists := engine.feedThreads(context, msg.MemberID, func(threads []*Thread) interface{} {
+		return pageFeed(threads, feedSort, msg.After, msg.Limit)
+	})
+	if !exists {
+		engine.fail(context, ErrMemberNotFound, "Member not found")
+	}
+}
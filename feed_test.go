@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHotScore(t *testing.T) {
+	tests := []struct {
+		name      string
+		upvotes   int
+		downvotes int
+		age       time.Duration
+		want      float64
+	}{
+		{"no votes at the epoch", 0, 0, 0, 0},
+		{"ten net upvotes", 11, 1, 0, 1},
+		{"hundred net downvotes", 0, 100, 0, -2},
+		{"one net upvote", 1, 0, 0, 0},
+		{"no votes 12.5 hours later", 0, 0, 45000 * time.Second, 1},
+		{"ten net upvotes a day later", 10, 0, 24 * time.Hour, 1 + 86400.0/45000},
+	}
+	for _, test := range tests {
+		thread := &Thread{Upvotes: test.upvotes, Downvotes: test.downvotes, CreatedAt: snowflakeEpoch.Add(test.age)}
+		if got := hotScore(thread); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: hotScore = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestControversialScore(t *testing.T) {
+	tests := []struct {
+		name      string
+		upvotes   int
+		downvotes int
+		want      float64
+	}{
+		{"no votes", 0, 0, 0},
+		{"only upvotes", 10, 0, 0},
+		{"only downvotes", 0, 10, 0},
+		{"evenly split", 5, 5, 10},
+		{"more upvotes", 8, 2, math.Pow(10, 0.25)},
+		{"more downvotes", 2, 8, math.Pow(10, 0.25)},
+	}
+	for _, test := range tests {
+		thread := &Thread{Upvotes: test.upvotes, Downvotes: test.downvotes}
+		if got := controversialScore(thread); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: controversialScore = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRankThreads(t *testing.T) {
+	older := snowflakeEpoch.Add(time.Hour)
+	newer := snowflakeEpoch.Add(2 * time.Hour)
+	threads := func() []*Thread {
+		return []*Thread{
+			{ID: "a", Upvotes: 10, CreatedAt: older},
+			{ID: "b", Upvotes: 3, Downvotes: 3, CreatedAt: newer},
+			{ID: "c", CreatedAt: newer},
+			{ID: "d", Upvotes: 1, Downvotes: 4, CreatedAt: older},
+		}
+	}
+	tests := []struct {
+		sort FeedSort
+		want []string
+	}{
+		{FeedSortNew, []string{"c", "b", "d", "a"}},
+		{FeedSortTop, []string{"a", "c", "b", "d"}},
+		{FeedSortControversial, []string{"b", "d", "c", "a"}},
+		{FeedSortHot, []string{"a", "c", "b", "d"}},
+	}
+	for _, test := range tests {
+		ranked := threads()
+		rankThreads(ranked, test.sort)
+		var got []string
+		for _, thread := range ranked {
+			got = append(got, thread.ID)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("rankThreads(%s) = %v, want %v", test.sort, got, test.want)
+		}
+	}
+}
+
+func TestPageLimit(t *testing.T) {
+	tests := []struct{ limit, want int }{
+		{-1, defaultFeedLimit},
+		{0, defaultFeedLimit},
+		{1, 1},
+		{maxFeedLimit, maxFeedLimit},
+		{maxFeedLimit + 1, maxFeedLimit},
+	}
+	for _, test := range tests {
+		if got := pageLimit(test.limit); got != test.want {
+			t.Errorf("pageLimit(%d) = %d, want %d", test.limit, got, test.want)
+		}
+	}
+}
+
+func TestPageAfter(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+	idOf := func(item string) string { return item }
+	tests := []struct {
+		after    string
+		limit    int
+		want     []string
+		wantNext string
+		wantOK   bool
+	}{
+		{"", 2, []string{"a", "b"}, "b", true},
+		{"b", 2, []string{"c", "d"}, "d", true},
+		{"d", 2, []string{"e"}, "", true},
+		{"c", 2, []string{"d", "e"}, "", true},
+		{"e", 2, []string{}, "", true},
+		{"", 10, items, "", true},
+		{"z", 2, nil, "", false},
+	}
+	for _, test := range tests {
+		page, next, ok := pageAfter(items, idOf, test.after, test.limit)
+		if ok != test.wantOK || next != test.wantNext || !reflect.DeepEqual(page, test.want) {
+			t.Errorf("pageAfter(after=%q, limit=%d) = %v, %q, %v; want %v, %q, %v",
+				test.after, test.limit, page, next, ok, test.want, test.wantNext, test.wantOK)
+		}
+	}
+}
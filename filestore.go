@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// logEntry is one line of a FileStore log. Entries without Data delete the
+// record.
+type logEntry struct {
+	Kind string          `json:"kind"`
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// FileStore is a MemoryStore that appends every change to a JSON Lines log.
+// Each change is written and synced to disk before Put returns, so it
+// survives the process or the machine crashing. Opening the store replays
+// the log and rewrites it with one line per live record.
+type FileStore struct {
+	*MemoryStore
+	path string
+	file *os.File
+	// size is the length of the log up to its last complete line.
+	size int64
+}
+
+func OpenFileStore(path string) (*FileStore, error) {
+	records, err := replayLog(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := splitModLogs(records); err != nil {
+		return nil, err
+	}
+	if err := compactLog(path, records); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	store := &FileStore{MemoryStore: NewMemoryStore(), path: path, file: file, size: info.Size()}
+	store.records = records
+	store.persist = store.appendEntry
+	fmt.Printf("[Store] Opened %s with %d records\n", path, len(records))
+	return store, nil
+}
+
+// appendEntry writes one change as a single line and syncs it. A failed
+// write is cut off again, so the next line does not follow a partial one and
+// the log stays readable. It runs under the MemoryStore lock.
+func (store *FileStore) appendEntry(key recordKey, data json.RawMessage) error {
+	line, err := json.Marshal(&logEntry{Kind: key.Kind, ID: key.ID, Data: data})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := store.file.Write(line); err != nil {
+		store.file.Truncate(store.size)
+		return err
+	}
+	if err := store.file.Sync(); err != nil {
+		store.file.Truncate(store.size)
+		return err
+	}
+	store.size += int64(len(line))
+	return nil
+}
+
+func (store *FileStore) Close() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if err := store.file.Sync(); err != nil {
+		store.file.Close()
+		return err
+	}
+	return store.file.Close()
+}
+
+// replayLog reads the log at path, keeping the last value of every record.
+// A final line without a newline is the remains of an interrupted write and
+// is dropped.
+func replayLog(path string) (map[recordKey]json.RawMessage, error) {
+	records := make(map[recordKey]json.RawMessage)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				fmt.Printf("[Store] Dropping incomplete last line %d of %s\n", lineNumber, path)
+			}
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry logEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		key := recordKey{Kind: entry.Kind, ID: entry.ID}
+		if entry.Data == nil {
+			delete(records, key)
+		} else {
+			records[key] = entry.Data
+		}
+	}
+}
+
+// compactLog replaces the log with one line per record. The new log is
+// written beside the old one and renamed over it, so a crash leaves one or
+// the other intact.
+func compactLog(path string, records map[recordKey]json.RawMessage) error {
+	temporaryPath := path + ".tmp"
+	file, err := os.OpenFile(temporaryPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for key, data := range records {
+		if err := encoder.Encode(&logEntry{Kind: key.Kind, ID: key.ID, Data: data}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, path)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReplayLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		log     string
+		want    map[string]string
+		wantErr string
+	}{
+		{name: "missing log", want: map[string]string{}},
+		{name: "last value wins", log: `{"kind":"vote","id":"a","data":1}` + "\n" + `{"kind":"vote","id":"a","data":2}` + "\n",
+			want: map[string]string{"vote a": "2"}},
+		{name: "entry without data deletes", log: `{"kind":"block","id":"a","data":1}` + "\n" + `{"kind":"block","id":"a"}` + "\n" + `{"kind":"block","id":"b","data":3}` + "\n",
+			want: map[string]string{"block b": "3"}},
+		{name: "blank lines", log: "\n" + `{"kind":"vote","id":"a","data":1}` + "\n\n",
+			want: map[string]string{"vote a": "1"}},
+		{name: "incomplete last line", log: `{"kind":"vote","id":"a","data":1}` + "\n" + `{"kind":"vote","id":"a","da`,
+			want: map[string]string{"vote a": "1"}},
+		{name: "malformed line", log: `{"kind":"vote","id":"a","data":1}` + "\nnot json\n", wantErr: "community.log:2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "community.log")
+			if test.log != "" {
+				if err := os.WriteFile(path, []byte(test.log), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			records, err := replayLog(path)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("replayLog failed with %v, want an error mentioning %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for key, data := range records {
+				got[key.Kind+" "+key.ID] = string(data)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("replayLog = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestFileStoreCompactsLog overwrites and deletes records, then checks that
+// reopening the store leaves one line per live record.
+func TestFileStoreCompactsLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "community.log")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	puts := []func() error{
+		func() error { return store.PutMember(&Member{ID: "m_1", Username: "alice", PasswordHash: "test"}) },
+		func() error { return store.PutVote(&Vote{TargetID: "t_1", MemberID: "m_1", Value: 1}) },
+		func() error { return store.PutVote(&Vote{TargetID: "t_1", MemberID: "m_1", Value: -1}) },
+		func() error { return store.PutBlock(&Block{MemberID: "m_1", BlockedID: "m_2", Blocked: true}) },
+		func() error { return store.PutBlock(&Block{MemberID: "m_1", BlockedID: "m_2"}) },
+	}
+	for _, put := range puts {
+		if err := put(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("compacted log holds %d lines, want 2:\n%s", lines, data)
+	}
+	snapshot, err := reopened.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Members) != 1 || snapshot.Members[0].PasswordHash != "test" {
+		t.Errorf("loaded members %v, want alice with the password hash", snapshot.Members)
+	}
+	if len(snapshot.Votes) != 1 || snapshot.Votes[0].Value != -1 || len(snapshot.Blocks) != 0 {
+		t.Errorf("loaded votes %v and blocks %v, want the downvote alone", snapshot.Votes, snapshot.Blocks)
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/cluster"
+)
+
+// Request sends a command to the grain that owns what it names and waits for
+// the answer. Commands reading across grains ask every node and merge their
+// answers; lookups any node can answer go to this node's engine.
+func (node *ClusterNode) Request(message interface{}) (interface{}, error) {
+	if node.cluster.MemberList.Members().Len() < node.peers {
+		return &CommandFailed{Code: ErrUnavailable, Reason: "Not every cluster node is up"}, nil
+	}
+
+	switch msg := message.(type) {
+
+	case *RegisterMember:
+		// The password is hashed here rather than on the grain, which would
+		// hold up every registration queued behind it
+		if msg.Username == "" || msg.Password == "" {
+			return &CommandFailed{Code: ErrInvalidRequest, Reason: "Username and password are required"}, nil
+		}
+		if err := validateUsername(msg.Username); err != nil {
+			return &CommandFailed{Code: ErrInvalidUsername, Reason: err.Error()}, nil
+		}
+		passwordHash, err := hashPassword(msg.Password)
+		if err != nil {
+			fmt.Printf("[Cluster] Failed to hash password: %v\n", err)
+			return &CommandFailed{Code: ErrInvalidRequest, Reason: "Could not register member"}, nil
+		}
+		return node.requestGrain(usernameKind, strings.ToLower(msg.Username), &addMember{Username: msg.Username, PasswordHash: passwordHash})
+
+	case *Login:
+		profile, err := node.requestLocal(&FetchProfile{Username: msg.Username})
+		if found, ok := profile.(*MemberProfile); err == nil && ok {
+			return node.requestGrain(memberKind, found.ID, msg)
+		}
+		return node.requestLocal(msg)
+
+	case *ResolveSession:
+		return node.requestSession(msg.Token, msg)
+
+	case *Logout:
+		return node.requestSession(msg.Token, msg)
+
+	case *RevokeSessions:
+		return node.requestGrain(memberKind, msg.MemberID, msg)
+
+	case *FetchProfile:
+		return node.fetchProfile(msg)
+
+	case *ListMemberCommunities:
+		return node.listMemberCommunities(msg)
+
+	case *FetchFeed:
+		return node.fetchFeed(msg)
+
+	case *ListCommunities:
+		results, err := node.broadcast(&collectCommunities{})
+		if err != nil {
+			return nil, err
+		}
+		views := make([]*CommunityView, 0)
+		for _, result := range results {
+			if list, ok := result.(*CommunityList); ok {
+				views = append(views, list.Communities...)
+			}
+		}
+		return pageCommunities(views, msg.After, msg.Limit), nil
+
+	case *FetchInbox:
+		return node.fetchMessages(&collectMessages{MemberID: msg.MemberID, UnreadOnly: msg.UnreadOnly}, msg.After, msg.Limit)
+
+	case *FetchSent:
+		return node.fetchMessages(&collectMessages{MemberID: msg.MemberID, Sent: true}, msg.After, msg.Limit)
+
+	case *ListConversations:
+		return node.listConversations(msg)
+
+	case *CreateCommunity:
+		return node.requestCommunity(msg.Name, msg)
+	case *FetchCommunity:
+		return node.requestCommunity(msg.Name, msg)
+	case *FetchCommunityThreads:
+		return node.requestCommunity(msg.Name, msg)
+	case *JoinCommunity:
+		return node.requestCommunity(msg.CommunityID, msg)
+	case *LeaveCommunity:
+		return node.requestCommunity(msg.CommunityID, msg)
+	case *CreateThread:
+		return node.requestCommunity(msg.CommunityID, msg)
+	case *AddModerator:
+		return node.requestCommunity(msg.CommunityID, msg)
+	case *RemoveModerator:
+		return node.requestCommunity(msg.CommunityID, msg)
+	case *RemoveContent:
+		return node.requestCommunity(msg.CommunityID, msg)
+	case *LockThread:
+		return node.requestCommunity(msg.CommunityID, msg)
+	case *PinThread:
+		return node.requestCommunity(msg.CommunityID, msg)
+	case *BanMember:
+		return node.requestCommunity(msg.CommunityID, msg)
+	case *FetchModLog:
+		return node.requestCommunity(msg.CommunityID, msg)
+
+	case *CreateReply:
+		return node.requestContent(msg.ThreadID, msg)
+	case *FetchThread:
+		return node.requestContent(msg.ThreadID, msg)
+	case *CastVote:
+		return node.requestContent(msg.TargetID, msg)
+	case *EditThread:
+		return node.requestContent(msg.ThreadID, msg)
+	case *EditReply:
+		return node.requestContent(msg.ReplyID, msg)
+	case *DeleteThread:
+		return node.requestContent(msg.ThreadID, msg)
+	case *DeleteReply:
+		return node.requestContent(msg.ReplyID, msg)
+	case *FetchRevisions:
+		return node.requestContent(msg.TargetID, msg)
+
+	case *SendMessage:
+		if msg.ReceiverID == "" && msg.ReplyToID != "" {
+			located, err := node.locate(msg.ReplyToID)
+			if err != nil {
+				return nil, err
+			}
+			if located.ConversationID == "" {
+				return node.requestLocal(msg)
+			}
+			return node.requestGrain(conversationKind, located.ConversationID, msg)
+		}
+		return node.requestConversation(msg.SenderID, msg.ReceiverID, msg)
+
+	case *FetchConversation:
+		return node.requestConversation(msg.MemberID, msg.OtherID, msg)
+
+	case *BlockMember:
+		return node.requestConversation(msg.MemberID, msg.BlockedID, msg)
+
+	case *MarkMessageRead:
+		located, err := node.locate(msg.MessageID)
+		if err != nil {
+			return nil, err
+		}
+		if located.ConversationID == "" {
+			return node.requestLocal(msg)
+		}
+		return node.requestGrain(conversationKind, located.ConversationID, msg)
+	}
+
+	return node.requestLocal(message)
+}
+
+// requestSession sends a session command to the grain of the member the
+// token names. A token naming nobody is left to the local engine to reject.
+func (node *ClusterNode) requestSession(token string, message interface{}) (interface{}, error) {
+	memberID, ok := sessionMemberID(token)
+	if !ok {
+		return node.requestLocal(message)
+	}
+	return node.requestGrain(memberKind, memberID, message)
+}
+
+// requestCommunity sends a command to the community's grain. Community names
+// are matched case-insensitively, so the grain is named in lower case.
+func (node *ClusterNode) requestCommunity(name string, message interface{}) (interface{}, error) {
+	if name == "" {
+		return node.requestLocal(message)
+	}
+	return node.requestGrain(communityKind, strings.ToLower(name), message)
+}
+
+// requestContent sends a command on a thread or reply to its community's
+// grain. Unknown content is left to the local engine to report.
+func (node *ClusterNode) requestContent(id string, message interface{}) (interface{}, error) {
+	located, err := node.locate(id)
+	if err != nil {
+		return nil, err
+	}
+	if located.CommunityID == "" {
+		return node.requestLocal(message)
+	}
+	return node.requestCommunity(located.CommunityID, message)
+}
+
+// requestConversation sends a command to the grain of the conversation
+// between a member and another member, named by ID or username.
+func (node *ClusterNode) requestConversation(memberID, otherRef string, message interface{}) (interface{}, error) {
+	other, err := node.requestLocal(&FetchProfile{MemberID: otherRef, Username: otherRef})
+	if err != nil {
+		return nil, err
+	}
+	profile, ok := other.(*MemberProfile)
+	if !ok || memberID == "" {
+		return node.requestLocal(message)
+	}
+	return node.requestGrain(conversationKind, conversationID(memberID, profile.ID), message)
+}
+
+// locate finds the community or conversation holding a thread, reply or
+// message, asking every node the first time.
+func (node *ClusterNode) locate(id string) (*contentLocated, error) {
+	if located, exists := node.located.Load(id); exists {
+		return located.(*contentLocated), nil
+	}
+	results, err := node.broadcast(&locateContent{ID: id})
+	if err != nil {
+		return nil, err
+	}
+	located := &contentLocated{}
+	for _, result := range results {
+		if found, ok := result.(*contentLocated); ok && (found.CommunityID != "" || found.ConversationID != "") {
+			located = found
+			node.located.Store(id, located)
+			break
+		}
+	}
+	return located, nil
+}
+
+// fetchProfile adds up the karma and counts every node holds for a member,
+// since each node only sees the threads, replies and votes of its own
+// communities.
+func (node *ClusterNode) fetchProfile(msg *FetchProfile) (interface{}, error) {
+	results, err := node.broadcast(msg)
+	if err != nil {
+		return nil, err
+	}
+	var profile *MemberProfile
+	for _, result := range results {
+		part, ok := result.(*MemberProfile)
+		if !ok {
+			continue
+		}
+		if profile == nil {
+			copy := *part
+			profile = &copy
+			continue
+		}
+		profile.Karma += part.Karma
+		profile.ThreadCount += part.ThreadCount
+		profile.ReplyCount += part.ReplyCount
+	}
+	if profile == nil {
+		return results[0], nil
+	}
+	return profile, nil
+}
+
+func (node *ClusterNode) listMemberCommunities(msg *ListMemberCommunities) (interface{}, error) {
+	results, err := node.broadcast(msg)
+	if err != nil {
+		return nil, err
+	}
+	merged := &MemberCommunities{MemberID: msg.MemberID, Communities: make([]string, 0)}
+	for _, result := range results {
+		part, ok := result.(*MemberCommunities)
+		if !ok {
+			return result, nil
+		}
+		merged.Communities = append(merged.Communities, part.Communities...)
+	}
+	sort.Strings(merged.Communities)
+	return merged, nil
+}
+
+func (node *ClusterNode) fetchFeed(msg *FetchFeed) (interface{}, error) {
+	feedSort := msg.Sort
+	if feedSort == "" {
+		feedSort = FeedSortHot
+	}
+	if !validFeedSort(feedSort) {
+		return &CommandFailed{Code: ErrInvalidRequest, Reason: "Unknown feed sort"}, nil
+	}
+	results, err := node.broadcast(&collectFeed{MemberID: msg.MemberID})
+	if err != nil {
+		return nil, err
+	}
+	threads := make([]*Thread, 0)
+	for _, result := range results {
+		part, ok := result.(*FeedResult)
+		if !ok {
+			return result, nil
+		}
+		threads = append(threads, part.Threads...)
+	}
+	return pageFeed(threads, feedSort, msg.After, msg.Limit), nil
+}
+
+func (node *ClusterNode) fetchMessages(msg *collectMessages, after string, limit int) (interface{}, error) {
+	results, err := node.broadcast(msg)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*PrivateMessage, 0)
+	for _, result := range results {
+		part, ok := result.(*MessageList)
+		if !ok {
+			return result, nil
+		}
+		messages = append(messages, part.Messages...)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].CreatedAt.Equal(messages[j].CreatedAt) {
+			return messages[i].CreatedAt.After(messages[j].CreatedAt)
+		}
+		return messages[i].ID > messages[j].ID
+	})
+	return pageMessages(messages, after, limit), nil
+}
+
+func (node *ClusterNode) listConversations(msg *ListConversations) (interface{}, error) {
+	results, err := node.broadcast(msg)
+	if err != nil {
+		return nil, err
+	}
+	merged := &ConversationList{MemberID: msg.MemberID, Conversations: make([]*ConversationSummary, 0)}
+	for _, result := range results {
+		part, ok := result.(*ConversationList)
+		if !ok {
+			return result, nil
+		}
+		merged.Conversations = append(merged.Conversations, part.Conversations...)
+	}
+	sortConversations(merged.Conversations)
+	return merged, nil
+}
+
+// requestGrain sends a command to a grain, activating it on its node if
+// needed.
+func (node *ClusterNode) requestGrain(kind, identity string, message interface{}) (interface{}, error) {
+	request, err := newGrainRequest(message)
+	if err != nil {
+		return nil, err
+	}
+	response, err := node.cluster.Request(identity, kind, request, cluster.WithTimeout(node.timeout))
+	if err != nil {
+		return nil, err
+	}
+	return readGrainResponse(response)
+}
+
+// requestLocal asks this node's engine.
+func (node *ClusterNode) requestLocal(message interface{}) (interface{}, error) {
+	system := node.cluster.ActorSystem
+	return system.Root.RequestFuture(node.enginePID, message, node.timeout).Result()
+}
+
+// requestNode asks the node actor at address about its whole engine.
+func (node *ClusterNode) requestNode(address string, message interface{}) (interface{}, error) {
+	request, err := newGrainRequest(message)
+	if err != nil {
+		return nil, err
+	}
+	system := node.cluster.ActorSystem
+	response, err := system.Root.RequestFuture(actor.NewPID(address, nodeActorName), request, node.timeout).Result()
+	if err != nil {
+		return nil, err
+	}
+	return readGrainResponse(response)
+}
+
+// broadcast asks every node at once and returns their answers. It fails if
+// any node does not answer, since a merged answer would be missing its part.
+func (node *ClusterNode) broadcast(message interface{}) ([]interface{}, error) {
+	members := node.cluster.MemberList.Members().Members()
+	results := make([]interface{}, len(members))
+	errs := make([]error, len(members))
+	var wg sync.WaitGroup
+	for i, member := range members {
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			results[i], errs[i] = node.requestNode(address, message)
+		}(i, member.Address())
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %w", members[i].Address(), err)
+		}
+	}
+	return results, nil
+}
+
+func newGrainRequest(message interface{}) (*cluster.GrainRequest, error) {
+	typeName, data, err := encodeWire(message)
+	if err != nil {
+		return nil, err
+	}
+	return &cluster.GrainRequest{MessageTypeName: typeName, MessageData: data}, nil
+}
+
+func readGrainResponse(response interface{}) (interface{}, error) {
+	grainResponse, ok := response.(*cluster.GrainResponse)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response %T", response)
+	}
+	return decodeWire(grainResponse.MessageTypeName, grainResponse.MessageData)
+}
@@ -5,29 +5,44 @@ go 1.23.3
 require github.com/asynkron/protoactor-go v0.0.0-20240822202345-3c0e61ca19c9
 
 require (
-	github.com/Workiva/go-datastructures v1.1.3 
-	github.com/beorn7/perks v1.0.1 
-	github.com/cespare/xxhash/v2 v2.2.0 
-	github.com/emirpasic/gods v1.18.1 
-	github.com/go-logr/logr v1.3.0 
-	github.com/go-logr/stdr v1.2.2 
-	github.com/golang/protobuf v1.5.3 
-	github.com/google/uuid v1.5.0 
-	github.com/lithammer/shortuuid/v4 v4.0.0 
-	github.com/lmittmann/tint v1.0.3 
-	github.com/matttproud/golang_protobuf_extensions v1.0.4 
-	github.com/orcaman/concurrent-map v1.0.0 
-	github.com/prometheus/client_golang v1.17.0 
-	github.com/prometheus/client_model v0.5.0 
-	github.com/prometheus/common v0.44.0 
-	github.com/prometheus/procfs v0.11.1 
-	github.com/twmb/murmur3 v1.1.8 
-	go.opentelemetry.io/otel v1.21.0 
-	go.opentelemetry.io/otel/exporters/prometheus v0.44.0 
-	go.opentelemetry.io/otel/metric v1.21.0 
-	go.opentelemetry.io/otel/sdk v1.21.0 
-	go.opentelemetry.io/otel/sdk/metric v1.21.0 
-	go.opentelemetry.io/otel/trace v1.21.0 
-	golang.org/x/sys v0.19.0 
-	google.golang.org/protobuf v1.33.0 
+	github.com/Workiva/go-datastructures v1.1.3 // indirect
+	github.com/asynkron/gofun v0.0.0-20220329210725-34fed760f4c2 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/emirpasic/gods v1.18.1 // indirect
+	github.com/go-logr/logr v1.3.0 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/gogo/protobuf v1.3.2 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/google/uuid v1.5.0 // indirect
+	github.com/labstack/echo v3.3.10+incompatible // indirect
+	github.com/labstack/gommon v0.3.1 // indirect
+	github.com/lithammer/shortuuid/v4 v4.0.0 // indirect
+	github.com/lmittmann/tint v1.0.3 // indirect
+	github.com/mattn/go-colorable v0.1.13 // indirect
+	github.com/mattn/go-isatty v0.0.17 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
+	github.com/orcaman/concurrent-map v1.0.0 // indirect
+	github.com/prometheus/client_golang v1.17.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.44.0 // indirect
+	github.com/prometheus/procfs v0.11.1 // indirect
+	github.com/twmb/murmur3 v1.1.8 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	github.com/valyala/fasttemplate v1.2.1 // indirect
+	go.opentelemetry.io/otel v1.21.0 // indirect
+	go.opentelemetry.io/otel/exporters/prometheus v0.44.0 // indirect
+	go.opentelemetry.io/otel/metric v1.21.0 // indirect
+	go.opentelemetry.io/otel/sdk v1.21.0 // indirect
+	go.opentelemetry.io/otel/sdk/metric v1.21.0 // indirect
+	go.opentelemetry.io/otel/trace v1.21.0 // indirect
+	golang.org/x/crypto v0.22.0 // indirect
+	golang.org/x/exp v0.0.0-20231110203233-9a3e6036ecaa // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sync v0.5.0 // indirect
+	golang.org/x/sys v0.19.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20231002182017-d307bd883b97 // indirect
+	google.golang.org/grpc v1.60.1 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ID prefixes follow Reddit's "thing" kinds so an ID names its own type.
+const (
+	ReplyIDPrefix   = "t1_"
+	ThreadIDPrefix  = "t3_"
+	MessageIDPrefix = "t4_"
+	MemberIDPrefix  = "u_"
+)
+
+// IDGenerator hands out unique IDs. Implementations must be safe for
+// concurrent use.
+type IDGenerator interface {
+	NewID(prefix string) string
+}
+
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	maxSnowflakeNode      = 1<<snowflakeNodeBits - 1
+	maxSnowflakeSequence  = 1<<snowflakeSequenceBits - 1
+
+	// snowflakeIDWidth is the number of base36 digits needed for any uint64,
+	// so zero-padded IDs sort in creation order.
+	snowflakeIDWidth = 13
+)
+
+// snowflakeEpoch is the zero point for the millisecond timestamp in each ID.
+var snowflakeEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeGenerator builds 64-bit IDs from a millisecond timestamp, the node
+// ID and a per-millisecond sequence, so IDs from different nodes never collide
+// and sort by creation time.
+type SnowflakeGenerator struct {
+	node     uint64
+	lastTime int64
+	sequence uint64
+	lock     sync.Mutex
+}
+
+func NewSnowflakeGenerator(node int) (*SnowflakeGenerator, error) {
+	if node < 0 || node > maxSnowflakeNode {
+		return nil, fmt.Errorf("node ID %d out of range [0, %d]", node, maxSnowflakeNode)
+	}
+	return &SnowflakeGenerator{node: uint64(node)}, nil
+}
+
+func (g *SnowflakeGenerator) NewID(prefix string) string {
+	g.lock.Lock()
+	now := time.Since(snowflakeEpoch).Milliseconds()
+	// Never step backwards if the wall clock does
+	if now < g.lastTime {
+		now = g.lastTime
+	}
+	if now == g.lastTime {
+		g.sequence = (g.sequence + 1) & maxSnowflakeSequence
+		if g.sequence == 0 {
+			// Sequence exhausted for this millisecond, wait for the next one
+			for now <= g.lastTime {
+				time.Sleep(100 * time.Microsecond)
+				now = time.Since(snowflakeEpoch).Milliseconds()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastTime = now
+	id := uint64(now)<<(snowflakeNodeBits+snowflakeSequenceBits) | g.node<<snowflakeSequenceBits | g.sequence
+	g.lock.Unlock()
+
+	encoded := strconv.FormatUint(id, 36)
+	return prefix + strings.Repeat("0", snowflakeIDWidth-len(encoded)) + encoded
+}
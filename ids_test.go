@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSnowflakeGeneratorConcurrentIDs(t *testing.T) {
+	const (
+		workers   = 16
+		perWorker = 1 << 16
+	)
+	generator, err := NewSnowflakeGenerator(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := make([][]string, workers)
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			ids := make([]string, perWorker)
+			for i := range ids {
+				ids[i] = generator.NewID(ThreadIDPrefix)
+			}
+			results[worker] = ids
+		}(worker)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool, workers*perWorker)
+	for worker, ids := range results {
+		for i, id := range ids {
+			if seen[id] {
+				t.Fatalf("duplicate ID %s", id)
+			}
+			seen[id] = true
+			if !strings.HasPrefix(id, ThreadIDPrefix) || len(id) != len(ThreadIDPrefix)+snowflakeIDWidth {
+				t.Fatalf("malformed ID %q", id)
+			}
+			// Each worker's IDs were made one after another, so must sort
+			// in that order
+			if i > 0 && id <= ids[i-1] {
+				t.Fatalf("worker %d: ID %s sorts before the earlier %s", worker, id, ids[i-1])
+			}
+		}
+	}
+}
+
+func TestSnowflakeGeneratorNodesDoNotCollide(t *testing.T) {
+	first, _ := NewSnowflakeGenerator(1)
+	second, _ := NewSnowflakeGenerator(2)
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		for _, id := range []string{first.NewID(MemberIDPrefix), second.NewID(MemberIDPrefix)} {
+			if seen[id] {
+				t.Fatalf("duplicate ID %s across nodes", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestNewSnowflakeGeneratorNodeRange(t *testing.T) {
+	tests := []struct {
+		node    int
+		wantErr bool
+	}{
+		{-1, true},
+		{0, false},
+		{maxSnowflakeNode, false},
+		{maxSnowflakeNode + 1, true},
+	}
+	for _, test := range tests {
+		_, err := NewSnowflakeGenerator(test.node)
+		if (err != nil) != test.wantErr {
+			t.Errorf("NewSnowflakeGenerator(%d) error = %v, want error %v", test.node, err, test.wantErr)
+		}
+	}
+}
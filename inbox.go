@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+// maxMessageLength matches Reddit's limit on private message bodies.
+const maxMessageLength = 10000
+
+// conversationID names the conversation between two members regardless of
+// who wrote first.
+func conversationID(memberID, otherID string) string {
+	if memberID > otherID {
+		memberID, otherID = otherID, memberID
+	}
+	return memberID + ":" + otherID
+}
+
+func privateMessageID(message *PrivateMessage) string {
+	return message.ID
+}
+
+// insertMessage adds a message to a list kept in ID order. A member's
+// received and sent messages reach their shard from different actors, so
+// they may arrive slightly out of order; sorting them keeps lists the same
+// after a restart.
+func insertMessage(messages []*PrivateMessage, message *PrivateMessage) []*PrivateMessage {
+	index := sort.Search(len(messages), func(i int) bool { return messages[i].ID > message.ID })
+	messages = append(messages, nil)
+	copy(messages[index+1:], messages[index:])
+	messages[index] = message
+	return messages
+}
+
+// findMessage finds a message in a list kept in ID order.
+func findMessage(messages []*PrivateMessage, messageID string) (*PrivateMessage, bool) {
+	index := sort.Search(len(messages), func(i int) bool { return messages[i].ID >= messageID })
+	if index == len(messages) || messages[index].ID != messageID {
+		return nil, false
+	}
+	return messages[index], true
+}
+
+// messageReceiver resolves who a message goes to: the member named by
+// ReceiverID, or else the other member of the conversation it replies to.
+func (engine *CommunityEngine) messageReceiver(msg *SendMessage) (*Member, bool) {
+	receiverRef := msg.ReceiverID
+	if receiverRef == "" {
+		original, exists := engine.messages[msg.ReplyToID]
+		if !exists {
+			return nil, false
+		}
+		receiverRef = original.senderID
+		if receiverRef == msg.SenderID {
+			receiverRef = original.receiverID
+		}
+	}
+	return engine.memberByIDOrUsername(receiverRef)
+}
+
+// newestFirst copies the messages that pass keep, latest first.
+func newestFirst(messages []*PrivateMessage, keep func(*PrivateMessage) bool) []*PrivateMessage {
+	copies := make([]*PrivateMessage, 0, len(messages))
+	for i := len(messages) - 1; i >= 0; i-- {
+		if keep == nil || keep(messages[i]) {
+			message := *messages[i]
+			copies = append(copies, &message)
+		}
+	}
+	return copies
+}
+
+// pageMessages cuts one page out of messages, or fails if after does not
+// name one of them.
+func pageMessages(messages []*PrivateMessage, after string, limit int) interface{} {
+	page, next, ok := pageAfter(messages, privateMessageID, after, pageLimit(limit))
+	if !ok {
+		return &CommandFailed{Code: ErrInvalidRequest, Reason: "Unknown message cursor"}
+	}
+	return &MessageList{Messages: page, NextCursor: next}
+}
+
+// sendMessage checks what the engine knows of a message, then has the
+// receiver's shard deliver it, so it is ordered with the receiver's blocks.
+func (engine *CommunityEngine) sendMessage(context actor.Context, msg *SendMessage) {
+	if strings.TrimSpace(msg.Content) == "" {
+		engine.fail(context, ErrInvalidRequest, "Message content is required")
+		return
+	}
+	if len(msg.Content) > maxMessageLength {
+		engine.fail(context, ErrInvalidRequest, fmt.Sprintf("Messages are limited to %d characters", maxMessageLength))
+		return
+	}
+
+	if _, exists := engine.members[msg.SenderID]; !exists {
+		engine.fail(context, ErrMemberNotFound, "Sender not found")
+		return
+	}
+	var replyTo *messageEntry
+	if msg.ReplyToID != "" {
+		original, exists := engine.messages[msg.ReplyToID]
+		if !exists || (original.senderID != msg.SenderID && original.receiverID != msg.SenderID) {
+			engine.fail(context, ErrMessageNotFound, "Message to reply to not found")
+			return
+		}
+		replyTo = original
+	}
+	receiver, exists := engine.messageReceiver(msg)
+	if !exists {
+		fmt.Printf("[Engine] Failed to send message: Receiver=%s not found\n", msg.ReceiverID)
+		engine.fail(context, ErrMemberNotFound, "Receiver not found")
+		return
+	}
+	if receiver.ID == msg.SenderID {
+		engine.fail(context, ErrInvalidRequest, "Members cannot message themselves")
+		return
+	}
+	if replyTo != nil && replyTo.conversationID != conversationID(msg.SenderID, receiver.ID) {
+		engine.fail(context, ErrInvalidRequest, "A reply must go to the other member of the conversation")
+		return
+	}
+	engine.pass(context, engine.memberShards[receiver.ID], &shardRequest{command: msg})
+}
+
+// deliverMessage adds a message to the receiver's inbox. The sender's shard
+// is given a copy through the engine.
+func (shard *memberShard) deliverMessage(context actor.Context, msg *SendMessage) {
+	if shard.blocked[msg.SenderID] {
+		shard.fail(context, ErrBlocked, "Receiver does not accept messages from this member")
+		return
+	}
+	if !shard.accept(context, msg) {
+		return
+	}
+	privateMessage := &PrivateMessage{
+		ID:             shard.newID(context, MessageIDPrefix),
+		ConversationID: conversationID(msg.SenderID, shard.memberID),
+		SenderID:       msg.SenderID,
+		ReceiverID:     shard.memberID,
+		ReplyToID:      msg.ReplyToID,
+		Content:        msg.Content,
+		CreatedAt:      shard.now(context),
+	}
+	if !shard.persist(context, shard.store.PutMessage(privateMessage)) {
+		return
+	}
+	conversation := privateMessage.ConversationID
+	shard.inbox = insertMessage(shard.inbox, privateMessage)
+	shard.conversations[conversation] = insertMessage(shard.conversations[conversation], privateMessage)
+	copied := *privateMessage
+	shard.notify(context, &messageAdded{Message: &copied})
+
+	fmt.Printf("[Engine] Message sent: From=%s, To=%s, MessageID=%s\n", msg.SenderID, shard.memberID, privateMessage.ID)
+	shard.respond(context, &MessageDelivered{
+		MessageID:      privateMessage.ID,
+		ConversationID: privateMessage.ConversationID,
+		CreatedAt:      privateMessage.CreatedAt,
+	})
+}
+
+// copySent keeps the sender's copy of a delivered message.
+func (shard *memberShard) copySent(message *PrivateMessage) {
+	shard.sent = insertMessage(shard.sent, message)
+	shard.conversations[message.ConversationID] = insertMessage(shard.conversations[message.ConversationID], message)
+}
+
+// markSent updates the sender's copy of a message the receiver marked.
+func (shard *memberShard) markSent(msg *messageRead) {
+	if message, exists := findMessage(shard.sent, msg.MessageID); exists {
+		message.Read = msg.Read
+	}
+}
+
+func (shard *memberShard) fetchInbox(context actor.Context, msg *FetchInbox) {
+	var keep func(*PrivateMessage) bool
+	if msg.UnreadOnly {
+		keep = func(message *PrivateMessage) bool { return !message.Read }
+	}
+	shard.respond(context, pageMessages(newestFirst(shard.inbox, keep), msg.After, msg.Limit))
+}
+
+func (shard *memberShard) fetchSent(context actor.Context, msg *FetchSent) {
+	shard.respond(context, pageMessages(newestFirst(shard.sent, nil), msg.After, msg.Limit))
+}
+
+func (shard *memberShard) collectMessages(context actor.Context, msg *collectMessages) {
+	var keep func(*PrivateMessage) bool
+	if msg.UnreadOnly {
+		keep = func(message *PrivateMessage) bool { return !message.Read }
+	}
+	messages := shard.inbox
+	if msg.Sent {
+		messages = shard.sent
+	}
+	shard.respond(context, &MessageList{Messages: newestFirst(messages, keep)})
+}
+
+func (shard *memberShard) listConversations(context actor.Context, msg *ListConversations) {
+	summaries := make([]*ConversationSummary, 0, len(shard.conversations))
+	for id, messages := range shard.conversations {
+		last := *messages[len(messages)-1]
+		summary := &ConversationSummary{
+			ConversationID: id,
+			OtherID:        last.SenderID,
+			MessageCount:   len(messages),
+			LastMessage:    &last,
+		}
+		if summary.OtherID == msg.MemberID {
+			summary.OtherID = last.ReceiverID
+		}
+		for _, message := range messages {
+			if message.ReceiverID == msg.MemberID && !message.Read {
+				summary.UnreadCount++
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+
+	sortConversations(summaries)
+	shard.respond(context, &ConversationList{MemberID: msg.MemberID, Conversations: summaries})
+}
+
+// sortConversations puts the most recently active conversation first.
+func sortConversations(summaries []*ConversationSummary) {
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].LastMessage.CreatedAt.After(summaries[j].LastMessage.CreatedAt)
+	})
+}
+
+func (engine *CommunityEngine) fetchConversation(context actor.Context, msg *FetchConversation) {
+	other, exists := engine.memberByIDOrUsername(msg.OtherID)
+	if !exists {
+		engine.fail(context, ErrMemberNotFound, "Member not found")
+		return
+	}
+	shard, exists := engine.memberShards[msg.MemberID]
+	if !exists {
+		engine.respond(context, pageMessages(nil, msg.After, msg.Limit))
+		return
+	}
+	engine.pass(context, shard, &shardRequest{command: msg, otherID: other.ID})
+}
+
+func (shard *memberShard) fetchConversation(context actor.Context, msg *FetchConversation, otherID string) {
+	messages := newestFirst(shard.conversations[conversationID(msg.MemberID, otherID)], nil)
+	shard.respond(context, pageMessages(messages, msg.After, msg.Limit))
+}
+
+// markMessageRead has the receiver's shard mark the message, which holds it.
+func (engine *CommunityEngine) markMessageRead(context actor.Context, msg *MarkMessageRead) {
+	entry, exists := engine.messages[msg.MessageID]
+	if !exists || entry.receiverID != msg.MemberID {
+		engine.fail(context, ErrMessageNotFound, "Message not found")
+		return
+	}
+	engine.pass(context, engine.memberShards[entry.receiverID], &shardRequest{command: msg})
+}
+
+func (shard *memberShard) markMessageRead(context actor.Context, msg *MarkMessageRead) {
+	message, exists := findMessage(shard.inbox, msg.MessageID)
+	if !exists {
+		shard.fail(context, ErrMessageNotFound, "Message not found")
+		return
+	}
+	if !shard.accept(context, msg) {
+		return
+	}
+	marked := *message
+	marked.Read = msg.Read
+	if !shard.persist(context, shard.store.PutMessage(&marked)) {
+		return
+	}
+	message.Read = msg.Read
+	shard.notify(context, &messageRead{MessageID: message.ID, Read: msg.Read})
+	shard.respond(context, &marked)
+}
+
+// blockMember only stops new messages; what was already delivered stays in
+// the inbox.
+func (engine *CommunityEngine) blockMember(context actor.Context, msg *BlockMember) {
+	blocked, exists := engine.memberByIDOrUsername(msg.BlockedID)
+	shard, member := engine.memberShards[msg.MemberID]
+	if !exists || !member {
+		engine.fail(context, ErrMemberNotFound, "Member not found")
+		return
+	}
+	if blocked.ID == msg.MemberID {
+		engine.fail(context, ErrInvalidRequest, "Members cannot block themselves")
+		return
+	}
+	engine.pass(context, shard, &shardRequest{command: msg, otherID: blocked.ID})
+}
+
+func (shard *memberShard) blockMember(context actor.Context, msg *BlockMember, blockedID string) {
+	if !shard.accept(context, msg) {
+		return
+	}
+	if !shard.persist(context, shard.store.PutBlock(&Block{MemberID: msg.MemberID, BlockedID: blockedID, Blocked: msg.Blocked})) {
+		return
+	}
+	if msg.Blocked {
+		shard.blocked[blockedID] = true
+	} else {
+		delete(shard.blocked, blockedID)
+	}
+
+	fmt.Printf("[Engine] Block updated: MemberID=%s, Blocked=%s, Active=%t\n", msg.MemberID, blockedID, msg.Blocked)
+	shard.respond(context, &BlockChanged{MemberID: msg.MemberID, BlockedID: blockedID, Blocked: msg.Blocked})
+}
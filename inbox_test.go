@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestInsertMessage(t *testing.T) {
+	tests := []struct {
+		existing []string
+		id       string
+		want     []string
+	}{
+		{nil, "b", []string{"b"}},
+		{[]string{"a", "c"}, "d", []string{"a", "c", "d"}},
+		{[]string{"a", "c"}, "b", []string{"a", "b", "c"}},
+		{[]string{"b", "c"}, "a", []string{"a", "b", "c"}},
+	}
+	for _, test := range tests {
+		var messages []*PrivateMessage
+		for _, id := range test.existing {
+			messages = append(messages, &PrivateMessage{ID: id})
+		}
+		var got []string
+		for _, message := range insertMessage(messages, &PrivateMessage{ID: test.id}) {
+			got = append(got, message.ID)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("insertMessage(%v, %s) = %v, want %v", test.existing, test.id, got, test.want)
+		}
+	}
+}
+
+// TestPrivateMessages exchanges messages between two members, then checks
+// what each of them sees, before and after a restart.
+func TestPrivateMessages(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		store := NewMemoryStore()
+		test := startTestEngine(t, store, sharded)
+		aliceID := test.addMember("alice")
+		bobID := test.addMember("bob")
+
+		first := expect[*MessageDelivered](test, &SendMessage{SenderID: aliceID, ReceiverID: "bob", Content: "Hi"})
+		reply := expect[*MessageDelivered](test, &SendMessage{SenderID: bobID, ReplyToID: first.MessageID, Content: "Hello"})
+		if reply.ConversationID != first.ConversationID {
+			t.Errorf("reply went to conversation %s, want %s", reply.ConversationID, first.ConversationID)
+		}
+		expect[*PrivateMessage](test, &MarkMessageRead{MemberID: bobID, MessageID: first.MessageID, Read: true})
+		expectFailure(test, &MarkMessageRead{MemberID: aliceID, MessageID: first.MessageID, Read: true}, ErrMessageNotFound)
+		expectFailure(test, &SendMessage{SenderID: aliceID, ReceiverID: aliceID, Content: "Me"}, ErrInvalidRequest)
+		expectFailure(test, &SendMessage{SenderID: aliceID, ReceiverID: "nobody", Content: "Hi"}, ErrMemberNotFound)
+		expect[*BlockChanged](test, &BlockMember{MemberID: bobID, BlockedID: "alice", Blocked: true})
+		expectFailure(test, &SendMessage{SenderID: aliceID, ReceiverID: bobID, Content: "Again"}, ErrBlocked)
+
+		views := func(test *testEngine) []interface{} {
+			return []interface{}{
+				expect[*MessageList](test, &FetchInbox{MemberID: bobID}),
+				expect[*MessageList](test, &FetchInbox{MemberID: aliceID, UnreadOnly: true}),
+				expect[*MessageList](test, &FetchSent{MemberID: aliceID}),
+				expect[*MessageList](test, &FetchConversation{MemberID: aliceID, OtherID: "bob"}),
+				expect[*ConversationList](test, &ListConversations{MemberID: bobID}),
+			}
+		}
+		want := views(test)
+		if inbox := want[0].(*MessageList); len(inbox.Messages) != 1 || !inbox.Messages[0].Read {
+			t.Errorf("bob's inbox holds %v, want the read message", inbox.Messages)
+		}
+		if conversation := want[3].(*MessageList); len(conversation.Messages) != 2 || conversation.Messages[0].ID != reply.MessageID {
+			t.Errorf("conversation holds %v, want the reply first", conversation.Messages)
+		}
+		if conversations := want[4].(*ConversationList).Conversations; len(conversations) != 1 || conversations[0].UnreadCount != 0 || conversations[0].OtherID != aliceID {
+			t.Errorf("bob's conversations are %v, want one with alice and nothing unread", conversations)
+		}
+
+		restored := startTestEngine(t, store, sharded)
+		// Compared as JSON, which drops the monotonic clock readings
+		gotJSON, _ := json.Marshal(views(restored))
+		wantJSON, _ := json.Marshal(want)
+		if string(gotJSON) != string(wantJSON) {
+			t.Errorf("restored messages %s, want %s", gotJSON, wantJSON)
+		}
+		expectFailure(restored, &SendMessage{SenderID: aliceID, ReceiverID: bobID, Content: "Again"}, ErrBlocked)
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+const (
+	journalEventsFile       = "events.log"
+	journalSnapshotFile     = "snapshot.json"
+	defaultSnapshotInterval = 1000
+)
+
+// Event is one state-changing command as the journal recorded it. At and ID
+// are the time and new ID the command was applied with; replaying the event
+// uses them again instead of the clock and the ID generator.
+type Event struct {
+	Sequence uint64
+	Type     string
+	At       time.Time
+	ID       string `json:",omitempty"`
+	Command  json.RawMessage
+
+	command interface{}
+}
+
+// journaledCommand describes one command type that changes state: the
+// prefix of the ID it creates, if any, and how to decode it.
+type journaledCommand struct {
+	idPrefix string
+	decode   func(data json.RawMessage) (interface{}, error)
+}
+
+func journaled[T any](idPrefix string) journaledCommand {
+	return journaledCommand{idPrefix: idPrefix, decode: func(data json.RawMessage) (interface{}, error) {
+		command := new(T)
+		err := json.Unmarshal(data, command)
+		return command, err
+	}}
+}
+
+// journaledCommands lists every command that changes state, keyed by type
+// name. The names are written to the journal, so renaming one of these types
+// breaks replay of existing logs. Commands missing here are applied without
+// being journaled and are lost on restart.
+var journaledCommands = map[string]journaledCommand{
+	"addMember":       journaled[addMember](MemberIDPrefix),
+	"CreateCommunity": journaled[CreateCommunity](""),
+	"JoinCommunity":   journaled[JoinCommunity](""),
+	"LeaveCommunity":  journaled[LeaveCommunity](""),
+	"AddModerator":    journaled[AddModerator](""),
+	"RemoveModerator": journaled[RemoveModerator](""),
+	"RemoveContent":   journaled[RemoveContent](""),
+	"LockThread":      journaled[LockThread](""),
+	"PinThread":       journaled[PinThread](""),
+	"BanMember":       journaled[BanMember](""),
+	"CreateThread":    journaled[CreateThread](ThreadIDPrefix),
+	"CreateReply":     journaled[CreateReply](ReplyIDPrefix),
+	"EditThread":      journaled[EditThread](""),
+	"EditReply":       journaled[EditReply](""),
+	"DeleteThread":    journaled[DeleteThread](""),
+	"DeleteReply":     journaled[DeleteReply](""),
+	"CastVote":        journaled[CastVote](""),
+	"SendMessage":     journaled[SendMessage](MessageIDPrefix),
+	"MarkMessageRead": journaled[MarkMessageRead](""),
+	"BlockMember":     journaled[BlockMember](""),
+	"discardEvent":    journaled[discardEvent](""),
+}
+
+// discardEvent marks an earlier event whose command was interrupted by a
+// failure of the engine, or whose changes the store refused. The engine went
+// on without it, so replay leaves it out too.
+type discardEvent struct {
+	Sequence uint64
+}
+
+func commandType(command interface{}) string {
+	commandType := reflect.TypeOf(command)
+	if commandType.Kind() == reflect.Pointer {
+		commandType = commandType.Elem()
+	}
+	return commandType.Name()
+}
+
+// journalSnapshot is the engine's state after the event numbered Sequence.
+type journalSnapshot struct {
+	Sequence uint64
+	State    *Snapshot
+}
+
+// Journal is an append-only log of the commands the engine accepted, kept in
+// a directory next to the latest snapshot of the engine's state. Every event
+// is synced to disk before it is applied. Every snapshotInterval events the
+// engine's state is written to a new snapshot and the log starts over, so
+// startup replays at most that many events.
+type Journal struct {
+	directory        string
+	events           *os.File
+	size             int64
+	sequence         uint64
+	snapshot         *journalSnapshot
+	snapshotInterval int
+	lock             sync.Mutex
+}
+
+func OpenJournal(directory string, snapshotInterval int) (*Journal, error) {
+	if err := os.MkdirAll(directory, 0o700); err != nil {
+		return nil, err
+	}
+	snapshot, err := readJournalSnapshot(filepath.Join(directory, journalSnapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	events, err := os.OpenFile(filepath.Join(directory, journalEventsFile), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{
+		directory:        directory,
+		events:           events,
+		sequence:         snapshot.Sequence,
+		snapshot:         snapshot,
+		snapshotInterval: snapshotInterval,
+	}, nil
+}
+
+func readJournalSnapshot(path string) (*journalSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &journalSnapshot{State: &Snapshot{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &journalSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if snapshot.State == nil {
+		return nil, fmt.Errorf("%s: snapshot has no state", path)
+	}
+	return snapshot, nil
+}
+
+// replay calls apply with every event after the snapshot, in order,
+// leaving out events a later discardEvent names. A final line without a
+// newline is the remains of an interrupted append and is cut off, so the next
+// append starts on a clean line.
+func (journal *Journal) replay(apply func(event *Event)) (int, error) {
+	path := journal.events.Name()
+	reader := bufio.NewReader(journal.events)
+	events := make([]*Event, 0)
+	discarded := make(map[uint64]bool)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				fmt.Printf("[Journal] Dropping incomplete last line %d of %s\n", lineNumber, path)
+				if err := journal.events.Truncate(journal.size); err != nil {
+					return 0, err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		journal.size += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		event := &Event{}
+		if err := json.Unmarshal(line, event); err != nil {
+			return 0, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		// Events up to the snapshot are left over from a crash between
+		// writing the snapshot and emptying the log
+		if event.Sequence <= journal.sequence {
+			continue
+		}
+		if event.Sequence != journal.sequence+1 {
+			return 0, fmt.Errorf("%s:%d: expected event %d, found %d", path, lineNumber, journal.sequence+1, event.Sequence)
+		}
+		command, exists := journaledCommands[event.Type]
+		if !exists {
+			return 0, fmt.Errorf("%s:%d: unknown event type %q", path, lineNumber, event.Type)
+		}
+		if event.command, err = command.decode(event.Command); err != nil {
+			return 0, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		if discard, ok := event.command.(*discardEvent); ok {
+			discarded[discard.Sequence] = true
+		}
+		events = append(events, event)
+		journal.sequence = event.Sequence
+	}
+
+	replayed := 0
+	for _, event := range events {
+		if discarded[event.Sequence] {
+			fmt.Printf("[Journal] Skipping event %d (%s), which failed when first applied\n", event.Sequence, event.Type)
+			continue
+		}
+		apply(event)
+		replayed++
+	}
+	return replayed, nil
+}
+
+// rewind moves back to the start of the log, so the events since the
+// snapshot can be replayed again.
+func (journal *Journal) rewind() error {
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+	if _, err := journal.events.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	journal.size = 0
+	journal.sequence = journal.snapshot.Sequence
+	return nil
+}
+
+// append records command as the next event and syncs it to disk.
+func (journal *Journal) append(command interface{}, id string) (*Event, error) {
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+	data, err := json.Marshal(command)
+	if err != nil {
+		return nil, err
+	}
+	event := &Event{
+		Sequence: journal.sequence + 1,
+		Type:     commandType(command),
+		At:       time.Now(),
+		ID:       id,
+		Command:  data,
+		command:  command,
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	line = append(line, '\n')
+	if _, err := journal.events.Write(line); err != nil {
+		// Cut off whatever part of the line was written
+		journal.events.Truncate(journal.size)
+		return nil, err
+	}
+	if err := journal.events.Sync(); err != nil {
+		journal.events.Truncate(journal.size)
+		return nil, err
+	}
+	journal.size += int64(len(line))
+	journal.sequence = event.Sequence
+	return event, nil
+}
+
+func (journal *Journal) snapshotDue() bool {
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+	return journal.sequence-journal.snapshot.Sequence >= uint64(journal.snapshotInterval)
+}
+
+// saveSnapshot records state as of the latest event and empties the log. The
+// snapshot is written beside the old one and renamed over it, so a crash
+// leaves one or the other intact.
+func (journal *Journal) saveSnapshot(state *Snapshot) error {
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+	snapshot := &journalSnapshot{Sequence: journal.sequence, State: state}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(journal.directory, journalSnapshotFile)
+	temporaryPath := path + ".tmp"
+	file, err := os.OpenFile(temporaryPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temporaryPath, path); err != nil {
+		return err
+	}
+	journal.snapshot = snapshot
+
+	if err := journal.events.Truncate(0); err != nil {
+		return err
+	}
+	journal.size = 0
+	return journal.events.Sync()
+}
+
+func (journal *Journal) Close() error {
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+	if err := journal.events.Sync(); err != nil {
+		journal.events.Close()
+		return err
+	}
+	return journal.events.Close()
+}
+
+// replayJournal rebuilds the engine from the journal's snapshot and the
+// events after it, then journals every new command. It runs before the
+// engine receives any message.
+func (engine *CommunityEngine) replayJournal(journal *Journal) error {
+	if err := engine.load(journal.snapshot.State); err != nil {
+		return err
+	}
+	replayed, err := journal.replay(func(event *Event) {
+		engine.applyEvent(nil, event)
+	})
+	if err != nil {
+		return err
+	}
+	engine.journal = journal
+	fmt.Printf("[Engine] Replayed %d events after snapshot %d\n", replayed, journal.snapshot.Sequence)
+	return nil
+}
+
+// journaling applies a command. A command that changes state is journaled by
+// its handler, through accept, once the handler has validated it. If the
+// command fails after that, its event is discarded before the failure goes
+// on to the supervisor.
+func (engine *engineBase) journaling(context actor.Context, command interface{}, apply func(context actor.Context)) {
+	_, isJournaled := journaledCommands[commandType(command)]
+	if _, applying := context.(*eventContext); applying || engine.journal == nil || !isJournaled {
+		apply(context)
+		return
+	}
+	event := &eventContext{Context: context}
+	defer engine.discardFailed(event)
+	apply(event)
+}
+
+// discardFailed discards the event of a command that panicked. It must be
+// deferred directly.
+func (engine *engineBase) discardFailed(event *eventContext) {
+	if reason := recover(); reason != nil {
+		engine.discard(event)
+		panic(reason)
+	}
+}
+
+// accept journals a command its handler has validated, before the handler
+// changes anything, so a rejected command never reaches the journal. The
+// handler then takes the event's time and reserved ID from now and newID. A
+// command the journal cannot record is rejected, since a restart would lose
+// it. Replayed commands, and commands of an engine without a journal, are
+// accepted as they are.
+func (engine *engineBase) accept(context actor.Context, command interface{}) bool {
+	event, ok := context.(*eventContext)
+	if !ok || event.sequence != 0 {
+		return true
+	}
+	var id string
+	if idPrefix := journaledCommands[commandType(command)].idPrefix; idPrefix != "" {
+		id = engine.idGenerator.NewID(idPrefix)
+	}
+	recorded, err := engine.journal.append(command, id)
+	if err != nil {
+		fmt.Printf("[Engine] Failed to journal %s: %v\n", commandType(command), err)
+		engine.fail(context, ErrUnavailable, "Could not record the change")
+		return false
+	}
+	event.at, event.reservedID, event.sequence = recorded.At, recorded.ID, recorded.Sequence
+	return true
+}
+
+// discard journals that the command being applied took no effect after all.
+// Replayed commands, which have no actor context, are left as they are.
+func (engine *engineBase) discard(context actor.Context) {
+	event, ok := context.(*eventContext)
+	if !ok || event.sequence == 0 || event.Context == nil {
+		return
+	}
+	if _, err := engine.journal.append(&discardEvent{Sequence: event.sequence}, ""); err != nil {
+		fmt.Printf("[Engine] Failed to discard event %d: %v\n", event.sequence, err)
+	}
+}
+
+// saveSnapshot writes a journal snapshot. The shards answer for their state
+// once they have applied every command passed to them, and the engine passes
+// them none while it waits, so the snapshot holds exactly the events
+// journaled so far.
+func (engine *CommunityEngine) saveSnapshot(context actor.Context) {
+	state, err := engine.snapshot(context)
+	if err == nil {
+		err = engine.journal.saveSnapshot(state)
+	}
+	if err != nil {
+		fmt.Printf("[Engine] Failed to write snapshot: %v\n", err)
+		return
+	}
+	fmt.Printf("[Engine] Snapshot written at event %d\n", engine.journal.snapshot.Sequence)
+}
+
+// applyEvent applies a journaled command with the time and ID it was
+// recorded with.
+func (engine *CommunityEngine) applyEvent(context actor.Context, event *Event) {
+	engine.apply(&eventContext{Context: context, at: event.At, reservedID: event.ID, sequence: event.Sequence}, event.command)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// journalLine encodes command as the journal line of event sequence.
+func journalLine(t *testing.T, sequence uint64, command interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := json.Marshal(&Event{Sequence: sequence, Type: commandType(command), At: time.Now(), Command: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(line) + "\n"
+}
+
+func TestJournalReplay(t *testing.T) {
+	member := &addMember{Username: "member", PasswordHash: "test"}
+	community := &CreateCommunity{Name: "golang", FounderID: "m_1"}
+	tests := []struct {
+		name     string
+		snapshot uint64
+		lines    []string
+		want     []string
+		next     uint64
+		wantErr  string
+	}{
+		{name: "empty log", next: 1},
+		{name: "events in order", lines: []string{journalLine(t, 1, member), journalLine(t, 2, community)}, want: []string{"addMember", "CreateCommunity"}, next: 3},
+		{name: "blank lines", lines: []string{journalLine(t, 1, member), "\n", journalLine(t, 2, community)}, want: []string{"addMember", "CreateCommunity"}, next: 3},
+		{name: "incomplete last line", lines: []string{journalLine(t, 1, member), `{"Sequence":2,"Ty`}, want: []string{"addMember"}, next: 2},
+		{name: "discarded event", lines: []string{journalLine(t, 1, member), journalLine(t, 2, community), journalLine(t, 3, &discardEvent{Sequence: 2})}, want: []string{"addMember", "discardEvent"}, next: 4},
+		{name: "events up to the snapshot", snapshot: 1, lines: []string{journalLine(t, 1, member), journalLine(t, 2, community)}, want: []string{"CreateCommunity"}, next: 3},
+		{name: "missing event", lines: []string{journalLine(t, 1, member), journalLine(t, 3, community)}, wantErr: "expected event 2, found 3"},
+		{name: "unknown event type", lines: []string{`{"Sequence":1,"Type":"Unknown","Command":{}}` + "\n"}, wantErr: `unknown event type "Unknown"`},
+		{name: "malformed line", lines: []string{"not json\n"}, wantErr: "events.log:1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			if test.snapshot != 0 {
+				data, _ := json.Marshal(&journalSnapshot{Sequence: test.snapshot, State: &Snapshot{}})
+				if err := os.WriteFile(filepath.Join(directory, journalSnapshotFile), data, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			path := filepath.Join(directory, journalEventsFile)
+			if err := os.WriteFile(path, []byte(strings.Join(test.lines, "")), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			journal, err := OpenJournal(directory, defaultSnapshotInterval)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer journal.Close()
+
+			var got []string
+			_, err = journal.replay(func(event *Event) {
+				got = append(got, commandType(event.command))
+			})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("replay failed with %v, want an error mentioning %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("replayed %v, want %v", got, test.want)
+			}
+			// The next event follows the last one read, on a line of its own
+			if _, err := journal.append(&JoinCommunity{MemberID: "m_1", CommunityID: "golang"}, ""); err != nil {
+				t.Fatal(err)
+			}
+			data, _ := os.ReadFile(path)
+			lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+			event := &Event{}
+			if err := json.Unmarshal([]byte(lines[len(lines)-1]), event); err != nil || event.Sequence != test.next {
+				t.Errorf("appended %q after replay, want event %d", lines[len(lines)-1], test.next)
+			}
+		})
+	}
+}
+
+// startJournaledEngine replays the journal in directory into a new engine
+// and starts its actor.
+func startJournaledEngine(t *testing.T, directory string, snapshotInterval int) *testEngine {
+	t.Helper()
+	journal, err := OpenJournal(directory, snapshotInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { journal.Close() })
+	engine := newTestEngine(t, NewMemoryStore(), true)
+	if err := engine.replayJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	return runTestEngine(t, engine)
+}
+
+// TestJournalRestart checks that an engine replayed from the journal, with
+// and without snapshots along the way, serves what the original served.
+func TestJournalRestart(t *testing.T) {
+	for _, snapshotInterval := range []int{defaultSnapshotInterval, 3} {
+		directory := t.TempDir()
+		test := startJournaledEngine(t, directory, snapshotInterval)
+		aliceID := test.addMember("alice")
+		bobID := test.addMember("bob")
+		test.createCommunity("golang", aliceID)
+		threadID := test.createThread("golang", aliceID)
+		replyID := test.createReply(threadID, "", bobID)
+		expect[*VoteRecorded](test, &CastVote{MemberID: bobID, TargetID: threadID, IsUpvote: true})
+		expect[*ContentEdited](test, &EditReply{EditorID: bobID, ReplyID: replyID, Content: "Edited"})
+		expect[*MessageDelivered](test, &SendMessage{SenderID: aliceID, ReceiverID: bobID, Content: "Hi"})
+
+		views := func(test *testEngine) string {
+			data, _ := json.Marshal([]interface{}{
+				expect[*ThreadTree](test, &FetchThread{ThreadID: threadID}),
+				expect[*MemberProfile](test, &FetchProfile{MemberID: aliceID}),
+				expect[*MessageList](test, &FetchInbox{MemberID: bobID}),
+				expect[*Revisions](test, &FetchRevisions{TargetID: replyID}),
+			})
+			return string(data)
+		}
+		want := views(test)
+		restored := startJournaledEngine(t, directory, snapshotInterval)
+		if got := views(restored); got != want {
+			t.Errorf("snapshot interval %d: restored %s, want %s", snapshotInterval, got, want)
+		}
+		_, err := os.Stat(filepath.Join(directory, journalSnapshotFile))
+		if snapshotted := err == nil; snapshotted != (snapshotInterval == 3) {
+			t.Errorf("snapshot interval %d: snapshot written %t", snapshotInterval, snapshotted)
+		}
+	}
+}
+
+// TestJournalLeavesOutRejectedCommands checks that only commands the engine
+// accepted reach the journal.
+func TestJournalLeavesOutRejectedCommands(t *testing.T) {
+	directory := t.TempDir()
+	test := startJournaledEngine(t, directory, defaultSnapshotInterval)
+	memberID := test.addMember("member")
+	expectFailure(test, &addMember{Username: "member", PasswordHash: "test"}, ErrUsernameTaken)
+	expectFailure(test, &CreateThread{Title: "Title", CreatorID: memberID, CommunityID: "golang"}, ErrCommunityNotFound)
+	test.createCommunity("golang", memberID)
+	expectFailure(test, &JoinCommunity{MemberID: memberID, CommunityID: "golang"}, ErrAlreadyMember)
+	expectFailure(test, &BlockMember{MemberID: memberID, BlockedID: memberID, Blocked: true}, ErrInvalidRequest)
+
+	data, err := os.ReadFile(filepath.Join(directory, journalEventsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		event := &Event{}
+		if err := json.Unmarshal([]byte(line), event); err != nil {
+			t.Fatalf("journal line %q: %v", line, err)
+		}
+		got = append(got, event.Type)
+	}
+	if want := []string{"addMember", "CreateCommunity"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("journaled %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+// shutdownTimeout bounds how long in-flight HTTP requests and the simulator
+// are waited for before shutting down regardless.
+const shutdownTimeout = 10 * time.Second
+
+// Lifecycle starts the process's parts and stops them in an order that loses
+// nothing: HTTP requests are drained first, then the simulator stops, then
+// password checks finish, then the engine applies what is already in its
+// mailbox and stops taking messages, and only then are the store and
+// journal closed.
+type Lifecycle struct {
+	actorSystem *actor.ActorSystem
+	enginePID   *actor.PID
+	host        *engineHost
+	store       Store
+	journal     *Journal
+	deadLetters *atomic.Int64
+
+	httpServer *http.Server
+	node       *ClusterNode
+	simulator  *CommunitySimulator
+	simulation chan struct{}
+
+	requests atomic.Int64
+	stop     chan string
+	failed   atomic.Bool
+	started  time.Time
+}
+
+func NewLifecycle(system *actor.ActorSystem, enginePID *actor.PID, host *engineHost, store Store, journal *Journal, deadLetters *atomic.Int64) *Lifecycle {
+	return &Lifecycle{
+		actorSystem: system,
+		enginePID:   enginePID,
+		host:        host,
+		store:       store,
+		journal:     journal,
+		deadLetters: deadLetters,
+		stop:        make(chan string, 1),
+		started:     time.Now(),
+	}
+}
+
+// Serve starts the HTTP server, counting the requests it handles. A server
+// that cannot listen shuts the process down.
+func (lifecycle *Lifecycle) Serve(server *http.Server) {
+	handler := server.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lifecycle.requests.Add(1)
+		handler.ServeHTTP(w, r)
+	})
+	lifecycle.httpServer = server
+	go func() {
+		fmt.Printf("[Main] HTTP server listening on %s\n", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			lifecycle.failed.Store(true)
+			lifecycle.Stop(fmt.Sprintf("HTTP server failed: %v", err))
+		}
+	}()
+}
+
+// Simulate runs the simulation in the background. Its end shuts the process
+// down.
+func (lifecycle *Lifecycle) Simulate(simulator *CommunitySimulator, run func()) {
+	lifecycle.simulator = simulator
+	lifecycle.simulation = make(chan struct{})
+	go func() {
+		defer close(lifecycle.simulation)
+		run()
+		lifecycle.Stop("simulation finished")
+	}()
+}
+
+// JoinedCluster makes shutdown leave the cluster.
+func (lifecycle *Lifecycle) JoinedCluster(node *ClusterNode) {
+	lifecycle.node = node
+}
+
+// Stop asks for the process to shut down. Only the first reason is kept.
+func (lifecycle *Lifecycle) Stop(reason string) {
+	select {
+	case lifecycle.stop <- reason:
+	default:
+	}
+}
+
+// Run blocks until a signal arrives or Stop is called, then shuts down. It
+// returns the process's exit code.
+func (lifecycle *Lifecycle) Run() int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	var reason string
+	select {
+	case received := <-signals:
+		reason = "received " + received.String()
+	case reason = <-lifecycle.stop:
+	}
+	signal.Stop(signals)
+	fmt.Printf("[Main] Shutting down: %s\n", reason)
+	lifecycle.shutdown()
+	if lifecycle.failed.Load() {
+		return 1
+	}
+	return 0
+}
+
+func (lifecycle *Lifecycle) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if lifecycle.httpServer != nil {
+		if err := lifecycle.httpServer.Shutdown(ctx); err != nil {
+			fmt.Printf("[Main] HTTP requests still running at shutdown: %v\n", err)
+		}
+		fmt.Println("[Main] HTTP server stopped")
+	}
+	if lifecycle.simulator != nil {
+		lifecycle.simulator.Stop()
+		select {
+		case <-lifecycle.simulation:
+		case <-ctx.Done():
+			fmt.Println("[Main] Simulator still running at shutdown")
+		}
+	}
+
+	// Password checks still running pass their results to the engine, so
+	// they finish first. Messages queued ahead of the poison pill are still
+	// applied; anything sent later goes to the dead letters
+	lifecycle.host.current().background.stop()
+	if err := lifecycle.actorSystem.Root.PoisonFuture(lifecycle.enginePID).Wait(); err != nil {
+		fmt.Printf("[Main] Engine did not stop cleanly: %v\n", err)
+	} else {
+		fmt.Println("[Main] Engine stopped")
+	}
+	if lifecycle.node != nil {
+		lifecycle.node.Shutdown()
+	} else {
+		lifecycle.actorSystem.Shutdown()
+	}
+	closeState(lifecycle.store, lifecycle.journal)
+
+	fmt.Printf("[Main] Shut down after %s: %d HTTP requests, %d engine restarts, %d dead letters\n",
+		time.Since(lifecycle.started).Round(time.Second), lifecycle.requests.Load(),
+		lifecycle.host.restartCount(), lifecycle.deadLetters.Load())
+}
+
+func closeState(store Store, journal *Journal) {
+	if err := store.Close(); err != nil {
+		fmt.Printf("[Main] Failed to close store: %v\n", err)
+	}
+	if journal != nil {
+		if err := journal.Close(); err != nil {
+			fmt.Printf("[Main] Failed to close journal: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+// startLifecycle starts an engine replaying the journal in directory and
+// returns a lifecycle owning it, the actor system and the journal.
+func startLifecycle(t *testing.T, directory string) *Lifecycle {
+	t.Helper()
+	journal, err := OpenJournal(directory, defaultSnapshotInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := newTestEngine(t, NewMemoryStore(), true)
+	if err := engine.replayJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	system := actor.NewActorSystem()
+	host := newEngineHost(engine)
+	pid := system.Root.Spawn(host.props())
+	return NewLifecycle(system, pid, host, engine.store, journal, &atomic.Int64{})
+}
+
+// TestShutdownAppliesQueuedCommands stops the process with commands still in
+// the engine's mailbox and checks that the journal holds every one of them.
+func TestShutdownAppliesQueuedCommands(t *testing.T) {
+	directory := t.TempDir()
+	lifecycle := startLifecycle(t, directory)
+	test := &testEngine{t: t, system: lifecycle.actorSystem, pid: lifecycle.enginePID}
+	memberID := test.addMember("member")
+	test.createCommunity("golang", memberID)
+	const queued = 20
+	for i := 0; i < queued; i++ {
+		lifecycle.actorSystem.Root.Send(lifecycle.enginePID, &CreateThread{Title: "Title", Content: "Content", CreatorID: memberID, CommunityID: "golang"})
+	}
+	lifecycle.Stop("test finished")
+	lifecycle.Stop("ignored")
+	if code := lifecycle.Run(); code != 0 {
+		t.Errorf("Run returned %d, want 0", code)
+	}
+
+	restored := startJournaledEngine(t, directory, defaultSnapshotInterval)
+	if profile := expect[*MemberProfile](restored, &FetchProfile{MemberID: memberID}); profile.ThreadCount != queued {
+		t.Errorf("restored %d threads, want %d", profile.ThreadCount, queued)
+	}
+}
+
+// TestShutdownWaitsForPasswordChecks stops the process while a registration
+// is still being hashed and checks that it is answered and journaled.
+func TestShutdownWaitsForPasswordChecks(t *testing.T) {
+	directory := t.TempDir()
+	lifecycle := startLifecycle(t, directory)
+	test := &testEngine{t: t, system: lifecycle.actorSystem, pid: lifecycle.enginePID}
+	future := lifecycle.actorSystem.Root.RequestFuture(lifecycle.enginePID, &RegisterMember{Username: "member", Password: "secret"}, 10*time.Second)
+	// Answered after the registration has gone off to be hashed
+	expect[*CommunityList](test, &ListCommunities{})
+	lifecycle.Stop("test finished")
+	if code := lifecycle.Run(); code != 0 {
+		t.Errorf("Run returned %d, want 0", code)
+	}
+	result, err := future.Result()
+	registered, ok := result.(*MemberRegistered)
+	if err != nil || !ok {
+		t.Fatalf("registration answered %#v, %v", result, err)
+	}
+
+	restored := startJournaledEngine(t, directory, defaultSnapshotInterval)
+	expect[*MemberProfile](restored, &FetchProfile{MemberID: registered.MemberID})
+}
+
+func TestServerThatCannotListenFails(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	lifecycle := startLifecycle(t, t.TempDir())
+	lifecycle.Serve(&http.Server{Addr: listener.Addr().String(), Handler: http.NotFoundHandler()})
+	if code := lifecycle.Run(); code != 1 {
+		t.Errorf("Run returned %d, want 1", code)
+	}
+}
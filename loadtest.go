@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// loadTestOperations are the requests the load test's workers pick from.
+var loadTestOperations = []string{"vote", "reply", "feed", "thread"}
+
+func loadTestCommand(args []string) int {
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	server := flags.String("server", "http://localhost:8080", "base URL of the server")
+	memberCount := flags.Int("members", 20, "members to register")
+	communityCount := flags.Int("communities", 5, "communities to create")
+	threadCount := flags.Int("threads", 20, "threads to create before the workers start")
+	concurrency := flags.Int("concurrency", 10, "workers sending requests at once")
+	runDuration := flags.Duration("duration", 30*time.Second, "how long the workers run")
+	if err := parseCommand(flags, args); err != nil {
+		return commandFailed("loadtest", err)
+	}
+	if *memberCount < 1 || *communityCount < 1 || *threadCount < 1 || *concurrency < 1 {
+		return commandFailed("loadtest", fmt.Errorf("-members, -communities, -threads and -concurrency must be at least 1"))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	test := &loadTest{
+		server:    *server,
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+	}
+	if err := test.setUp(ctx, *memberCount, *communityCount, *threadCount); err != nil {
+		fmt.Fprintf(os.Stderr, "[LoadTest] Setup failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("[LoadTest] Running %d workers for %s against %s\n", *concurrency, *runDuration, *server)
+	elapsed := test.run(ctx, *concurrency, *runDuration)
+	test.report(elapsed)
+	return 0
+}
+
+// loadTest registers its own members and communities, named after the time
+// it started so runs against the same server do not collide, then has
+// workers send a random mix of requests as those members.
+type loadTest struct {
+	server      string
+	clients     []*Client
+	communities []string
+	threadIDs   []string
+
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	lock      sync.Mutex
+}
+
+func (test *loadTest) setUp(ctx context.Context, memberCount, communityCount, threadCount int) error {
+	run := strconv.FormatInt(time.Now().Unix()%(36*36*36*36*36*36), 36)
+	fmt.Printf("[LoadTest] Setting up run %s: %d members, %d communities, %d threads\n", run, memberCount, communityCount, threadCount)
+	for i := 0; i < memberCount; i++ {
+		client := NewClient(test.server)
+		username := fmt.Sprintf("load_%s_%d", run, i)
+		if _, err := client.RegisterMember(ctx, username, "load-test"); err != nil {
+			return fmt.Errorf("register %s: %w", username, err)
+		}
+		if _, err := client.Login(ctx, username, "load-test"); err != nil {
+			return fmt.Errorf("log in %s: %w", username, err)
+		}
+		test.clients = append(test.clients, client)
+	}
+	for i := 0; i < communityCount; i++ {
+		name := fmt.Sprintf("lt%s_%d", run, i)
+		if _, err := test.clients[0].CreateCommunity(ctx, name, "Load test community"); err != nil {
+			return fmt.Errorf("create community %s: %w", name, err)
+		}
+		for _, client := range test.clients[1:] {
+			if _, err := client.JoinCommunity(ctx, name); err != nil {
+				return fmt.Errorf("join community %s: %w", name, err)
+			}
+		}
+		test.communities = append(test.communities, name)
+	}
+	for i := 0; i < threadCount; i++ {
+		if err := test.createThread(ctx); err != nil {
+			return fmt.Errorf("create thread: %w", err)
+		}
+	}
+	return nil
+}
+
+func (test *loadTest) createThread(ctx context.Context) error {
+	client := test.clients[rand.Intn(len(test.clients))]
+	community := test.communities[rand.Intn(len(test.communities))]
+	created, err := client.CreateThread(ctx, community, "Load test thread", "Posted by the load test")
+	if err != nil {
+		return err
+	}
+	test.lock.Lock()
+	test.threadIDs = append(test.threadIDs, created.ID)
+	test.lock.Unlock()
+	return nil
+}
+
+// run keeps concurrency workers busy until the duration is up or ctx is
+// cancelled, and returns how long they ran.
+func (test *loadTest) run(ctx context.Context, concurrency int, duration time.Duration) time.Duration {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				test.step(ctx)
+			}
+		}()
+	}
+	wg.Wait()
+	return time.Since(start)
+}
+
+// step sends one random request as a random member and records its latency.
+func (test *loadTest) step(ctx context.Context) {
+	client := test.clients[rand.Intn(len(test.clients))]
+	operation := loadTestOperations[rand.Intn(len(loadTestOperations))]
+	test.lock.Lock()
+	threadID := test.threadIDs[rand.Intn(len(test.threadIDs))]
+	test.lock.Unlock()
+
+	start := time.Now()
+	var err error
+	switch operation {
+	case "vote":
+		_, err = client.CastVote(ctx, threadID, rand.Float32() < 0.7)
+	case "reply":
+		_, err = client.CreateReply(ctx, threadID, "", "Load test reply")
+	case "feed":
+		_, err = client.FetchFeed(ctx, FeedSortHot, "", 25)
+	case "thread":
+		err = test.createThread(ctx)
+	}
+	latency := time.Since(start)
+	// Requests cut off by the end of the run are not the server's fault
+	if ctx.Err() != nil {
+		return
+	}
+
+	test.lock.Lock()
+	defer test.lock.Unlock()
+	if err != nil {
+		test.errors[operation]++
+		return
+	}
+	test.latencies[operation] = append(test.latencies[operation], latency)
+}
+
+func (test *loadTest) report(elapsed time.Duration) {
+	test.lock.Lock()
+	defer test.lock.Unlock()
+	fmt.Println("\n[LoadTest] Results:")
+	fmt.Printf("  Elapsed Time: %v\n", elapsed.Round(time.Millisecond))
+	total, failed := 0, 0
+	for _, operation := range loadTestOperations {
+		latencies := test.latencies[operation]
+		total += len(latencies)
+		failed += test.errors[operation]
+		if len(latencies) == 0 {
+			fmt.Printf("  %-7s ok=0 errors=%d\n", operation, test.errors[operation])
+			continue
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var sum time.Duration
+		for _, latency := range latencies {
+			sum += latency
+		}
+		fmt.Printf("  %-7s ok=%d errors=%d avg=%v p95=%v max=%v\n", operation, len(latencies), test.errors[operation],
+			(sum / time.Duration(len(latencies))).Round(time.Microsecond),
+			latencies[len(latencies)*95/100].Round(time.Microsecond),
+			latencies[len(latencies)-1].Round(time.Microsecond))
+	}
+	fmt.Printf("  Requests: %d ok, %d failed\n", total, failed)
+	fmt.Printf("  Throughput: %.2f requests/sec\n", float64(total)/elapsed.Seconds())
+}
@@ -1,84 +1,243 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"math/rand"
+	"net"
 	"os"
-	"os/signal"
-	"sync"
-	"syscall"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/asynkron/protoactor-go/actor"
 )
 
+const usage = `Usage: %s <command> [flags]
+
+Commands:
+  serve     run the engine and its HTTP server, alone or as a cluster node
+  simulate  run the engine with simulated members, serving HTTP unless -serve=false
+  client    run a short scripted session against a running server
+  loadtest  drive a running server over HTTP and report throughput and latency
+
+Without a command, simulate runs with its defaults. Run "%s <command> -help"
+for a command's flags. Flags not given on the command line are read from
+COMMUNITY_<FLAG> environment variables, then from the -config file.
+`
+
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
-	var wg sync.WaitGroup
-
-	// Start the server in a separate goroutine
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		fmt.Println("[Main] Starting the server...")
-		mainServer()
-	}()
-
-	// Start the client in another goroutine
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		fmt.Println("[Main] Starting the client...")
-		//mainClient()
-	}()
-
-	// Continue with the simulation logic
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		actorSystem := actor.NewActorSystem()
-		engineProps := actor.PropsFromProducer(func() actor.Actor {
-			return NewCommunityEngine()
-		})
-		enginePID := actorSystem.Root.Spawn(engineProps)
-		fmt.Printf("[Main] Community Engine started with PID=%s\n", enginePID)
-
-		simulator := NewCommunitySimulator(actorSystem, enginePID)
-
-		stopSignal := make(chan os.Signal, 1)
-		signal.Notify(stopSignal, syscall.SIGINT, syscall.SIGTERM)
-
-		memberCount := 10
-		communityCount := 5
-		threadCount := 6
-		runDuration := 1 * time.Minute
-
-		fmt.Println("[Main] Starting community simulation...")
-		start := time.Now()
-		simulationComplete := make(chan struct{})
-		go func() {
-			simulator.RunSimulation(memberCount, communityCount, threadCount, runDuration)
-			close(simulationComplete)
-		}()
-
-		select {
-		case <-stopSignal:
-			fmt.Println("[Main] Interrupt signal received. Terminating simulation.")
-		case <-simulationComplete:
-			fmt.Println("[Main] Simulation finished successfully.")
-		case <-time.After(runDuration):
-			fmt.Println("[Main] Simulation timeout reached.")
+	command, args := "simulate", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "serve":
+		os.Exit(serveCommand(args))
+	case "simulate":
+		os.Exit(simulateCommand(args))
+	case "client":
+		os.Exit(clientCommand(args))
+	case "loadtest":
+		os.Exit(loadTestCommand(args))
+	case "help":
+		fmt.Printf(usage, os.Args[0], os.Args[0])
+	default:
+		fmt.Fprintf(os.Stderr, usage, os.Args[0], os.Args[0])
+		os.Exit(2)
+	}
+}
+
+// engineOptions are the flags shared by the commands that run an engine.
+type engineOptions struct {
+	storeKind  string
+	dataPath   string
+	sharded    bool
+	exportPath string
+	importPath string
+	http       string
+}
+
+func addEngineFlags(flags *flag.FlagSet) *engineOptions {
+	options := &engineOptions{}
+	flags.StringVar(&options.storeKind, "store", "memory", `where to keep state: "memory", "file" or "journal"`)
+	flags.StringVar(&options.dataPath, "data", "community.log", "log file used by the file store, or directory used by the journal; a cluster node's default is named after its port")
+	flags.BoolVar(&options.sharded, "shard", true, "run the commands of each community and each member's inbox on their own child actor")
+	flags.StringVar(&options.exportPath, "export", "", "write the stored state to this JSON file and exit")
+	flags.StringVar(&options.importPath, "import", "", "load an exported JSON file into an empty store before starting")
+	flags.StringVar(&options.http, "http", ":8080", "address the HTTP server listens on")
+	return options
+}
+
+// nodeDataPath names a cluster node's default data file after the node's
+// port, so nodes sharing a host never share a file.
+func nodeDataPath(path, nodeAddress string) (string, error) {
+	_, port, err := net.SplitHostPort(nodeAddress)
+	if err != nil {
+		return "", err
+	}
+	extension := filepath.Ext(path)
+	return strings.TrimSuffix(path, extension) + "-" + port + extension, nil
+}
+
+func serveCommand(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	options := addEngineFlags(flags)
+	nodeAddress := flags.String("node", "", "host:port this node's actors listen on; empty runs a single engine without a cluster")
+	membershipPort := flags.Int("membership-port", 6330, "port serving this node's health to the other cluster nodes")
+	peers := flags.String("peers", "", "comma-separated host:membership-port of every cluster node, this one included, in the same order on every node")
+	if err := parseCommand(flags, args); err != nil {
+		return commandFailed("serve", err)
+	}
+	dataGiven := false
+	flags.Visit(func(f *flag.Flag) {
+		dataGiven = dataGiven || f.Name == "data"
+	})
+
+	clusterConfig := ClusterConfig{
+		Name:           defaultClusterName,
+		Address:        *nodeAddress,
+		MembershipPort: *membershipPort,
+		Timeout:        5 * time.Second,
+	}
+	nodeNumber := 0
+	if *nodeAddress != "" {
+		clusterConfig.Peers = strings.Split(*peers, ",")
+		number, err := clusterConfig.NodeNumber()
+		if err != nil {
+			fmt.Printf("[Main] Invalid cluster peers: %v\n", err)
+			return 1
+		}
+		nodeNumber = number
+		if !dataGiven {
+			path, err := nodeDataPath(options.dataPath, *nodeAddress)
+			if err != nil {
+				fmt.Printf("[Main] Invalid node address: %v\n", err)
+				return 1
+			}
+			options.dataPath = path
 		}
-		elapsedTime := time.Since(start).Seconds()
-		fmt.Printf("Total time taken %.2f seconds\n", elapsedTime)
+	}
+	lifecycle, exit, done := startEngine(options, nodeNumber)
+	if done {
+		return exit
+	}
 
-		fmt.Println("[Main] Shutting down the community engine...")
-		actorSystem.Shutdown()
-		fmt.Println("[Main] Community engine shut down.")
-	}()
+	var node *ClusterNode
+	if *nodeAddress != "" {
+		var err error
+		node, err = StartClusterNode(lifecycle.actorSystem, lifecycle.host, lifecycle.enginePID, clusterConfig)
+		if err != nil {
+			fmt.Printf("[Main] Failed to join cluster: %v\n", err)
+			return 1
+		}
+		lifecycle.JoinedCluster(node)
+	}
+	lifecycle.Serve(mainServer(lifecycle.actorSystem, lifecycle.enginePID, node, options.http))
+	return lifecycle.Run()
+}
+
+func simulateCommand(args []string) int {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	options := addEngineFlags(flags)
+	serve := flags.Bool("serve", true, "serve HTTP while the simulation runs")
+	memberCount := flags.Int("members", 10, "members to register")
+	communityCount := flags.Int("communities", 5, "communities to create")
+	threadCount := flags.Int("threads", 6, "threads to create")
+	runDuration := flags.Duration("duration", 1*time.Minute, "how long to simulate replies and votes")
+	if err := parseCommand(flags, args); err != nil {
+		return commandFailed("simulate", err)
+	}
+
+	lifecycle, exit, done := startEngine(options, 0)
+	if done {
+		return exit
+	}
+	if *serve {
+		lifecycle.Serve(mainServer(lifecycle.actorSystem, lifecycle.enginePID, nil, options.http))
+	}
+	simulator := NewCommunitySimulator(lifecycle.actorSystem, lifecycle.enginePID)
+	fmt.Println("[Main] Starting community simulation...")
+	lifecycle.Simulate(simulator, func() {
+		simulator.RunSimulation(*memberCount, *communityCount, *threadCount, *runDuration)
+	})
+	return lifecycle.Run()
+}
+
+func clientCommand(args []string) int {
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	server := flags.String("server", "http://localhost:8080", "base URL of the server")
+	username := flags.String("username", "test_user", "member to register and log in as")
+	password := flags.String("password", "password123", "the member's password")
+	community := flags.String("community", "test_community", "community to create and post in")
+	verbose := flags.Bool("verbose", true, "log every request")
+	if err := parseCommand(flags, args); err != nil {
+		return commandFailed("client", err)
+	}
+	if err := mainClient(*server, *username, *password, *community, *verbose); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// startEngine opens the store, restores the engine and starts its actor. It
+// reports done when the command has nothing more to do, either because it
+// failed or because it only exported the state.
+func startEngine(options *engineOptions, nodeNumber int) (lifecycle *Lifecycle, exit int, done bool) {
+	idGenerator, err := NewSnowflakeGenerator(nodeNumber)
+	if err != nil {
+		fmt.Printf("[Main] Failed to create ID generator: %v\n", err)
+		return nil, 1, true
+	}
+	// The journal keeps its own snapshots, so its engine only needs a store
+	// in memory
+	var store Store
+	var journal *Journal
+	if options.storeKind == "journal" {
+		store = NewMemoryStore()
+		journal, err = OpenJournal(options.dataPath, defaultSnapshotInterval)
+	} else {
+		store, err = OpenStore(options.storeKind, options.dataPath)
+	}
+	if err != nil {
+		fmt.Printf("[Main] Failed to open store: %v\n", err)
+		return nil, 1, true
+	}
+	engine := NewCommunityEngine(idGenerator, store)
+	engine.sharded = options.sharded
+	if err := engine.restore(); err != nil {
+		fmt.Printf("[Main] Failed to restore state: %v\n", err)
+		return nil, 1, true
+	}
+	if journal != nil {
+		if err := engine.replayJournal(journal); err != nil {
+			fmt.Printf("[Main] Failed to replay journal: %v\n", err)
+			return nil, 1, true
+		}
+	}
+
+	if options.importPath != "" {
+		if err := engine.importFile(options.importPath); err != nil {
+			fmt.Printf("[Main] Failed to import %s: %v\n", options.importPath, err)
+			return nil, 1, true
+		}
+	}
+	if options.exportPath != "" {
+		if err := engine.exportFile(options.exportPath); err != nil {
+			fmt.Printf("[Main] Failed to export %s: %v\n", options.exportPath, err)
+			return nil, 1, true
+		}
+		closeState(store, journal)
+		return nil, 0, true
+	}
 
-	// Wait for all goroutines to complete
-	wg.Wait()
+	// The HTTP server and the simulator share a single engine actor
+	actorSystem := actor.NewActorSystem()
+	deadLetters := monitorActors(actorSystem)
+	host := newEngineHost(engine)
+	enginePID := actorSystem.Root.Spawn(host.props())
+	fmt.Printf("[Main] Community Engine started with PID=%s\n", enginePID)
+	return NewLifecycle(actorSystem, enginePID, host, store, journal, deadLetters), 0, false
 }
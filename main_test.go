@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestNodeDataPath(t *testing.T) {
+	tests := []struct {
+		path, nodeAddress string
+		want              string
+		wantErr           bool
+	}{
+		{"community.log", "127.0.0.1:6331", "community-6331.log", false},
+		{"community.log", ":6341", "community-6341.log", false},
+		{"data/journal", "localhost:7000", "data/journal-7000", false},
+		{"state.v2.log", "[::1]:6331", "state.v2-6331.log", false},
+		{"community.log", "127.0.0.1", "", true},
+	}
+	for _, test := range tests {
+		got, err := nodeDataPath(test.path, test.nodeAddress)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("nodeDataPath(%q, %q) = %q, %v; want %q, error %v", test.path, test.nodeAddress, got, err, test.want, test.wantErr)
+		}
+	}
+}
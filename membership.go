@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+func (shard *communityShard) joinCommunity(context actor.Context, msg *JoinCommunity, members map[string]bool) {
+	if !members[msg.MemberID] {
+		fmt.Printf("[Engine] Failed to join community: MemberID=%s not found\n", msg.MemberID)
+		shard.fail(context, ErrMemberNotFound, "Member not found")
+		return
+	}
+	community := shard.community
+	if community.Banned[msg.MemberID] {
+		shard.fail(context, ErrBanned, "Member is banned from this community")
+		return
+	}
+	if community.Participants[msg.MemberID] {
+		shard.fail(context, ErrAlreadyMember, "Member already joined this community")
+		return
+	}
+	if !shard.accept(context, msg) {
+		return
+	}
+	changed := *community
+	changed.Participants = withFlag(community.Participants, msg.MemberID, true)
+	changed.MemberCount++
+	if !shard.persist(context, shard.store.PutCommunity(&changed)) {
+		return
+	}
+	community.Participants, community.MemberCount = changed.Participants, changed.MemberCount
+	shard.notify(context, &membershipChanged{MemberID: msg.MemberID, CommunityID: community.Name, Joined: true})
+
+	fmt.Printf("[Engine] Member joined community: MemberID=%s, Community=%s\n", msg.MemberID, msg.CommunityID)
+	shard.respond(context, &MembershipChanged{
+		MemberID:    msg.MemberID,
+		CommunityID: community.Name,
+		Joined:      true,
+		MemberCount: community.MemberCount,
+	})
+}
+
+func (shard *communityShard) leaveCommunity(context actor.Context, msg *LeaveCommunity) {
+	community := shard.community
+	if !community.Participants[msg.MemberID] {
+		shard.fail(context, ErrNotMember, "Member has not joined this community")
+		return
+	}
+	if !shard.accept(context, msg) {
+		return
+	}
+	changed := *community
+	changed.Participants = withFlag(community.Participants, msg.MemberID, false)
+	changed.MemberCount--
+	if !shard.persist(context, shard.store.PutCommunity(&changed)) {
+		return
+	}
+	community.Participants, community.MemberCount = changed.Participants, changed.MemberCount
+	shard.notify(context, &membershipChanged{MemberID: msg.MemberID, CommunityID: community.Name, Joined: false})
+
+	fmt.Printf("[Engine] Member left community: MemberID=%s, Community=%s\n", msg.MemberID, msg.CommunityID)
+	shard.respond(context, &MembershipChanged{
+		MemberID:    msg.MemberID,
+		CommunityID: community.Name,
+		Joined:      false,
+		MemberCount: community.MemberCount,
+	})
+}
+
+func (engine *CommunityEngine) listMemberCommunities(context actor.Context, msg *ListMemberCommunities) {
+	if _, exists := engine.members[msg.MemberID]; !exists {
+		engine.fail(context, ErrMemberNotFound, "Member not found")
+		return
+	}
+	communities := make([]string, 0, len(engine.memberships[msg.MemberID]))
+	for name := range engine.memberships[msg.MemberID] {
+		communities = append(communities, name)
+	}
+
+	sort.Strings(communities)
+	engine.respond(context, &MemberCommunities{MemberID: msg.MemberID, Communities: communities})
+}
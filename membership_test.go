@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJoinAndLeaveCommunity(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		test := startTestEngine(t, NewMemoryStore(), sharded)
+		ownerID := test.addMember("owner")
+		memberID := test.addMember("member")
+		bannedID := test.addMember("banned")
+		test.createCommunity("golang", ownerID)
+		test.createCommunity("rust", ownerID)
+		expect[*ModActionRecorded](test, &BanMember{ModeratorID: ownerID, CommunityID: "golang", MemberID: bannedID, Banned: true})
+
+		tests := []struct {
+			name        string
+			command     interface{}
+			code        ErrorCode
+			memberCount int
+		}{
+			{name: "unknown member", command: &JoinCommunity{MemberID: MemberIDPrefix + "missing", CommunityID: "golang"}, code: ErrMemberNotFound},
+			{name: "unknown community", command: &JoinCommunity{MemberID: memberID, CommunityID: "python"}, code: ErrCommunityNotFound},
+			{name: "banned member", command: &JoinCommunity{MemberID: bannedID, CommunityID: "golang"}, code: ErrBanned},
+			{name: "join", command: &JoinCommunity{MemberID: memberID, CommunityID: "GoLang"}, memberCount: 2},
+			{name: "join again", command: &JoinCommunity{MemberID: memberID, CommunityID: "golang"}, code: ErrAlreadyMember},
+			{name: "join another", command: &JoinCommunity{MemberID: memberID, CommunityID: "rust"}, memberCount: 2},
+			{name: "leave", command: &LeaveCommunity{MemberID: memberID, CommunityID: "rust"}, memberCount: 1},
+			{name: "leave again", command: &LeaveCommunity{MemberID: memberID, CommunityID: "rust"}, code: ErrNotMember},
+			{name: "leave unknown community", command: &LeaveCommunity{MemberID: memberID, CommunityID: "python"}, code: ErrCommunityNotFound},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				test := test.in(t)
+				if tc.code != "" {
+					expectFailure(test, tc.command, tc.code)
+					return
+				}
+				changed := expect[*MembershipChanged](test, tc.command)
+				if changed.MemberCount != tc.memberCount {
+					t.Errorf("member count %d, want %d", changed.MemberCount, tc.memberCount)
+				}
+			})
+		}
+
+		joined := expect[*MemberCommunities](test, &ListMemberCommunities{MemberID: memberID})
+		if !reflect.DeepEqual(joined.Communities, []string{"golang"}) {
+			t.Errorf("member belongs to %v, want [golang]", joined.Communities)
+		}
+		if view := expect[*CommunityView](test, &FetchCommunity{Name: "golang"}); view.MemberCount != 2 {
+			t.Errorf("golang has %d members, want 2", view.MemberCount)
+		}
+		expectFailure(test, &ListMemberCommunities{MemberID: MemberIDPrefix + "missing"}, ErrMemberNotFound)
+	}
+}
@@ -1,20 +1,37 @@
-
 package main
 
 import "time"
 
 type Member struct {
-	ID       string
-	Username string
-	Password string
-	Karma    int
+	ID           string
+	Username     string
+	PasswordHash string `json:"-"`
+	Karma        int
+	ThreadCount  int
+	ReplyCount   int
+	CreatedAt    time.Time
 }
 
 type Community struct {
-	Name        string
-	Description string
+	Name         string
+	Description  string
+	OwnerID      string
+	Moderators   map[string]bool
+	Banned       map[string]bool
 	Participants map[string]bool
-	Threads     []*Thread
+	MemberCount  int
+	Threads      []*Thread
+	ModLog       []*ModLogEntry
+	CreatedAt    time.Time
+}
+
+// ModLogEntry records one moderator action in a community's audit log.
+type ModLogEntry struct {
+	ModeratorID string
+	Action      string
+	TargetID    string
+	Reason      string
+	CreatedAt   time.Time
 }
 
 type Thread struct {
@@ -25,8 +42,14 @@ type Thread struct {
 	CommunityID string
 	Upvotes     int
 	Downvotes   int
+	Locked      bool
+	Pinned      bool
+	Removed     bool
+	Deleted     bool
 	Replies     []*Reply
+	History     []*Revision
 	CreatedAt   time.Time
+	EditedAt    *time.Time `json:",omitempty"`
 }
 
 type Reply struct {
@@ -35,18 +58,46 @@ type Reply struct {
 	CreatorID string
 	ThreadID  string
 	ParentID  string
+	Depth     int
 	Upvotes   int
 	Downvotes int
+	Removed   bool
+	Deleted   bool
 	Replies   []*Reply
+	History   []*Revision
 	CreatedAt time.Time
+	EditedAt  *time.Time `json:",omitempty"`
 }
 
-type PrivateMessage struct {
-	ID        string
-	SenderID  string
-	ReceiverID string
-	Content   string
+// Revision records one edit of a thread or reply: the title and content it
+// replaced, who made the edit and when.
+type Revision struct {
+	Title    string `json:",omitempty"`
+	Content  string
+	EditorID string
+	EditedAt time.Time
+}
+
+// Session is a logged-in member's bearer token. The engine keys sessions by
+// the token's SHA-256 so the token itself is never stored.
+type Session struct {
+	MemberID  string
 	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// PrivateMessage is a direct message between two members. Messages between
+// the same pair share a ConversationID; ReplyToID links a reply to the
+// message it answers.
+type PrivateMessage struct {
+	ID             string
+	ConversationID string
+	SenderID       string
+	ReceiverID     string
+	ReplyToID      string `json:",omitempty"`
+	Content        string
+	Read           bool
+	CreatedAt      time.Time
 }
 
 type RegisterMember struct {
@@ -54,15 +105,68 @@ type RegisterMember struct {
 	Password string
 }
 
+type Login struct {
+	Username string
+	Password string
+}
+
+// FetchProfile looks a member up by MemberID if set, otherwise by Username.
+type FetchProfile struct {
+	MemberID string
+	Username string
+}
+
+type Logout struct {
+	Token string
+}
+
+// RevokeSessions logs a member out everywhere.
+type RevokeSessions struct {
+	MemberID string
+}
+
+type ResolveSession struct {
+	Token string
+}
+
 type CreateCommunity struct {
 	Name        string
 	Description string
 	FounderID   string
 }
 
+type FetchCommunity struct {
+	Name string
+}
+
+// FetchCommunityThreads lists a community's threads with pinned threads
+// first, paginated like FetchFeed.
+type FetchCommunityThreads struct {
+	Name  string
+	Sort  FeedSort
+	After string
+	Limit int
+}
+
+// ListCommunities pages through all communities, largest first. After is
+// the name of the last community on the previous page.
+type ListCommunities struct {
+	After string
+	Limit int
+}
+
 type JoinCommunity struct {
-	MemberID     string
-	CommunityID  string
+	MemberID    string
+	CommunityID string
+}
+
+type LeaveCommunity struct {
+	MemberID    string
+	CommunityID string
+}
+
+type ListMemberCommunities struct {
+	MemberID string
 }
 
 type CreateThread struct {
@@ -79,22 +183,406 @@ type CreateReply struct {
 	ParentID  string
 }
 
+// EditThread replaces a thread's title and content; an empty Title keeps the
+// current one. Authors may edit their own threads, and moderators any thread
+// in their community.
+type EditThread struct {
+	EditorID string
+	ThreadID string
+	Title    string
+	Content  string
+}
+
+type EditReply struct {
+	EditorID string
+	ReplyID  string
+	Content  string
+}
+
+// DeleteThread and DeleteReply soft-delete content: it stays in place, with
+// its replies, but reads back as "[deleted]".
+type DeleteThread struct {
+	MemberID string
+	ThreadID string
+}
+
+type DeleteReply struct {
+	MemberID string
+	ReplyID  string
+}
+
+// FetchRevisions asks for the edit history of a thread or reply.
+type FetchRevisions struct {
+	TargetID string
+}
+
+// Moderation commands are issued by ModeratorID against CommunityID and are
+// rejected unless that member moderates the community.
+
+type AddModerator struct {
+	ModeratorID string
+	CommunityID string
+	MemberID    string
+}
+
+// RemoveModerator is restricted to the community owner, except that any
+// moderator may step down by naming themselves.
+type RemoveModerator struct {
+	ModeratorID string
+	CommunityID string
+	MemberID    string
+}
+
+// RemoveContent hides a thread or reply from the community.
+type RemoveContent struct {
+	ModeratorID string
+	CommunityID string
+	TargetID    string
+	Reason      string
+}
+
+type LockThread struct {
+	ModeratorID string
+	CommunityID string
+	ThreadID    string
+	Locked      bool
+}
+
+type PinThread struct {
+	ModeratorID string
+	CommunityID string
+	ThreadID    string
+	Pinned      bool
+}
+
+type BanMember struct {
+	ModeratorID string
+	CommunityID string
+	MemberID    string
+	Banned      bool
+	Reason      string
+}
+
+type FetchModLog struct {
+	ModeratorID string
+	CommunityID string
+}
+
+// FetchThread asks for a thread and its reply tree. When ReplyID is set the
+// tree is rooted at that reply instead, which is how collapsed branches are
+// loaded. Zero MaxDepth and MaxBreadth select the defaults.
+type FetchThread struct {
+	ThreadID   string
+	ReplyID    string
+	MaxDepth   int
+	MaxBreadth int
+}
+
+// CastVote records a member's vote on a thread or reply. Voting again in the
+// opposite direction changes the vote; Retract removes it.
 type CastVote struct {
-	MemberID  string
-	TargetID  string
-	IsUpvote  bool
+	MemberID string
+	TargetID string
+	IsUpvote bool
+	Retract  bool
 }
 
+// SendMessage delivers a private message. ReceiverID may be a member ID or
+// a username, and may be left empty when ReplyToID names the message being
+// answered.
 type SendMessage struct {
-	SenderID  string
+	SenderID   string
 	ReceiverID string
-	Content   string
+	ReplyToID  string
+	Content    string
+}
+
+// FetchInbox pages through messages received by MemberID, newest first.
+// After is the ID of the last message on the previous page.
+type FetchInbox struct {
+	MemberID   string
+	UnreadOnly bool
+	After      string
+	Limit      int
+}
+
+// FetchSent pages through messages sent by MemberID, newest first.
+type FetchSent struct {
+	MemberID string
+	After    string
+	Limit    int
+}
+
+// ListConversations summarizes MemberID's conversations, most recent first.
+type ListConversations struct {
+	MemberID string
+}
+
+// FetchConversation pages through the messages between MemberID and OtherID,
+// newest first.
+type FetchConversation struct {
+	MemberID string
+	OtherID  string
+	After    string
+	Limit    int
+}
+
+// MarkMessageRead sets the read flag on a message MemberID received.
+type MarkMessageRead struct {
+	MemberID  string
+	MessageID string
+	Read      bool
 }
 
+// BlockMember stops BlockedID from sending MemberID messages, or lifts the
+// block when Blocked is false.
+type BlockMember struct {
+	MemberID  string
+	BlockedID string
+	Blocked   bool
+}
+
+// FeedSort selects the ranking used by FetchFeed.
+type FeedSort string
+
+const (
+	FeedSortHot           FeedSort = "hot"
+	FeedSortNew           FeedSort = "new"
+	FeedSortTop           FeedSort = "top"
+	FeedSortControversial FeedSort = "controversial"
+)
+
+// FetchFeed asks for a page of threads from the member's communities. After
+// is the ID of the last thread on the previous page; Limit defaults to
+// defaultFeedLimit.
 type FetchFeed struct {
 	MemberID string
+	Sort     FeedSort
+	After    string
+	Limit    int
 }
 
+// FeedResult carries one page of the feed. Threads are copies without their
+// replies; NextCursor is empty on the last page.
 type FeedResult struct {
-	Threads []*Thread
+	Threads    []*Thread
+	NextCursor string
+}
+
+type MemberRegistered struct {
+	MemberID  string
+	CreatedAt time.Time
+}
+
+type LoginSucceeded struct {
+	MemberID  string
+	Username  string
+	Token     string
+	ExpiresAt time.Time
+}
+
+// MemberProfile is the public view of a member.
+type MemberProfile struct {
+	ID          string
+	Username    string
+	Karma       int
+	ThreadCount int
+	ReplyCount  int
+	JoinedAt    time.Time
+}
+
+type LoggedOut struct {
+	MemberID string
+	Sessions int
+}
+
+type SessionResolved struct {
+	MemberID string
+	Username string
+}
+
+type CommunityCreated struct {
+	Name      string
+	CreatedAt time.Time
+}
+
+// CommunityView is the public snapshot of a community.
+type CommunityView struct {
+	Name        string
+	Description string
+	OwnerID     string
+	Moderators  []string
+	MemberCount int
+	ThreadCount int
+	CreatedAt   time.Time
+}
+
+type CommunityList struct {
+	Communities []*CommunityView
+	NextCursor  string
+}
+
+type MembershipChanged struct {
+	MemberID    string
+	CommunityID string
+	Joined      bool
+	MemberCount int
+}
+
+type MemberCommunities struct {
+	MemberID    string
+	Communities []string
+}
+
+type ThreadCreated struct {
+	ThreadID  string
+	CreatedAt time.Time
+}
+
+type ReplyCreated struct {
+	ReplyID   string
+	CreatedAt time.Time
+}
+
+type ContentEdited struct {
+	TargetID string
+	EditedAt time.Time
+}
+
+type ContentDeleted struct {
+	TargetID  string
+	DeletedAt time.Time
+}
+
+// Revisions lists earlier versions of a thread or reply, oldest first.
+type Revisions struct {
+	TargetID  string
+	Revisions []*Revision
+}
+
+type VoteRecorded struct {
+	TargetID  string
+	MemberID  string
+	IsUpvote  bool
+	Retracted bool
+	Upvotes   int
+	Downvotes int
+}
+
+// ThreadTree is a read-only snapshot of a thread and its reply tree.
+type ThreadTree struct {
+	ID          string
+	Title       string
+	Content     string
+	CreatorID   string
+	CommunityID string
+	Upvotes     int
+	Downvotes   int
+	Locked      bool
+	Pinned      bool
+	Removed     bool
+	Deleted     bool
+	CreatedAt   time.Time
+	EditedAt    *time.Time `json:",omitempty"`
+	Replies     []*ReplyNode
+	More        *MoreReplies `json:",omitempty"`
+}
+
+type ReplyNode struct {
+	ID        string
+	Content   string
+	CreatorID string
+	ParentID  string
+	Depth     int
+	Upvotes   int
+	Downvotes int
+	Removed   bool
+	Deleted   bool
+	CreatedAt time.Time
+	EditedAt  *time.Time `json:",omitempty"`
+	Replies   []*ReplyNode
+	More      *MoreReplies `json:",omitempty"`
+}
+
+// MoreReplies stands in for children cut off by the depth or breadth limit.
+// Fetching the thread again with ReplyID set to ParentID loads them.
+type MoreReplies struct {
+	ParentID string
+	Count    int
+	ReplyIDs []string
+}
+
+type ModActionRecorded struct {
+	CommunityID string
+	Entry       *ModLogEntry
+}
+
+type ModLog struct {
+	CommunityID string
+	Entries     []*ModLogEntry
+}
+
+type MessageDelivered struct {
+	MessageID      string
+	ConversationID string
+	CreatedAt      time.Time
+}
+
+type MessageList struct {
+	Messages   []*PrivateMessage
+	NextCursor string
+}
+
+// ConversationSummary describes one conversation from a member's point of
+// view.
+type ConversationSummary struct {
+	ConversationID string
+	OtherID        string
+	MessageCount   int
+	UnreadCount    int
+	LastMessage    *PrivateMessage
+}
+
+type ConversationList struct {
+	MemberID      string
+	Conversations []*ConversationSummary
+}
+
+type BlockChanged struct {
+	MemberID  string
+	BlockedID string
+	Blocked   bool
+}
+
+// ErrorCode identifies why the CommunityEngine rejected a command.
+type ErrorCode string
+
+const (
+	ErrInvalidRequest       ErrorCode = "invalid_request"
+	ErrMemberNotFound       ErrorCode = "member_not_found"
+	ErrUsernameTaken        ErrorCode = "username_taken"
+	ErrInvalidUsername      ErrorCode = "invalid_username"
+	ErrCommunityExists      ErrorCode = "community_exists"
+	ErrInvalidCommunityName ErrorCode = "invalid_community_name"
+	ErrCommunityNotFound    ErrorCode = "community_not_found"
+	ErrThreadNotFound       ErrorCode = "thread_not_found"
+	ErrTargetNotFound       ErrorCode = "target_not_found"
+	ErrReplyNotFound        ErrorCode = "reply_not_found"
+	ErrReplyTooDeep         ErrorCode = "reply_too_deep"
+	ErrAlreadyMember        ErrorCode = "already_member"
+	ErrNotMember            ErrorCode = "not_member"
+	ErrInvalidCredentials   ErrorCode = "invalid_credentials"
+	ErrNotModerator         ErrorCode = "not_moderator"
+	ErrBanned               ErrorCode = "banned"
+	ErrThreadLocked         ErrorCode = "thread_locked"
+	ErrInvalidSession       ErrorCode = "invalid_session"
+	ErrContentDeleted       ErrorCode = "content_deleted"
+	ErrMessageNotFound      ErrorCode = "message_not_found"
+	ErrBlocked              ErrorCode = "blocked"
+	ErrUnavailable          ErrorCode = "unavailable"
+)
+
+type CommandFailed struct {
+	Code   ErrorCode
+	Reason string
 }
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+// removedPlaceholder replaces the content of removed threads and replies.
+const removedPlaceholder = "[removed]"
+
+// maxPinnedThreads mirrors Reddit's limit on sticky posts per community.
+const maxPinnedThreads = 2
+
+// Actions recorded in the moderation log.
+const (
+	ModActionAddModerator    = "add_moderator"
+	ModActionRemoveModerator = "remove_moderator"
+	ModActionRemoveThread    = "remove_thread"
+	ModActionRemoveReply     = "remove_reply"
+	ModActionLock            = "lock"
+	ModActionUnlock          = "unlock"
+	ModActionPin             = "pin"
+	ModActionUnpin           = "unpin"
+	ModActionBan             = "ban"
+	ModActionUnban           = "unban"
+	ModActionEditThread      = "edit_thread"
+	ModActionEditReply       = "edit_reply"
+	ModActionDeleteThread    = "delete_thread"
+	ModActionDeleteReply     = "delete_reply"
+)
+
+// moderatedCommunity returns the community if moderatorID moderates it, or
+// reports the failure to the sender.
+func (shard *communityShard) moderatedCommunity(context actor.Context, moderatorID string) (*Community, bool) {
+	if !shard.community.Moderators[moderatorID] {
+		shard.fail(context, ErrNotModerator, "Only moderators can do that")
+		return nil, false
+	}
+	return shard.community, true
+}
+
+// moderatedThread resolves a thread that belongs to the community.
+func (shard *communityShard) moderatedThread(context actor.Context, threadID string) (*Thread, bool) {
+	thread, exists := shard.threads[threadID]
+	if !exists {
+		shard.fail(context, ErrThreadNotFound, "Thread not found")
+		return nil, false
+	}
+	return thread, true
+}
+
+// newModAction describes an action for a community's audit log.
+func (shard *communityShard) newModAction(context actor.Context, moderatorID, action, targetID, reason string) *ModLogEntry {
+	return &ModLogEntry{
+		ModeratorID: moderatorID,
+		Action:      action,
+		TargetID:    targetID,
+		Reason:      reason,
+		CreatedAt:   shard.now(context),
+	}
+}
+
+// storeModAction writes entry as the next one in the community's audit log.
+// A nil entry, for a change that is not logged, is not written.
+func (shard *communityShard) storeModAction(context actor.Context, community *Community, entry *ModLogEntry) bool {
+	if entry == nil {
+		return true
+	}
+	return shard.persist(context, shard.store.PutModLogEntry(&ModLogRecord{CommunityID: community.Name, Index: len(community.ModLog), ModLogEntry: entry}))
+}
+
+// logModAction appends a stored entry to the community's audit log.
+func (shard *communityShard) logModAction(community *Community, entry *ModLogEntry) {
+	if entry == nil {
+		return
+	}
+	community.ModLog = append(community.ModLog, entry)
+	fmt.Printf("[Engine] Mod action: Community=%s, Moderator=%s, Action=%s, Target=%s\n", community.Name, entry.ModeratorID, entry.Action, entry.TargetID)
+}
+
+// recordModAction logs a stored entry and answers the sender with it.
+func (shard *communityShard) recordModAction(context actor.Context, community *Community, entry *ModLogEntry) {
+	shard.logModAction(community, entry)
+	shard.respond(context, &ModActionRecorded{CommunityID: community.Name, Entry: entry})
+}
+
+func (shard *communityShard) addModerator(context actor.Context, msg *AddModerator, members map[string]bool) {
+	community, ok := shard.moderatedCommunity(context, msg.ModeratorID)
+	if !ok {
+		return
+	}
+	if !members[msg.MemberID] {
+		shard.fail(context, ErrMemberNotFound, "Member not found")
+		return
+	}
+	if community.Moderators[msg.MemberID] {
+		shard.fail(context, ErrInvalidRequest, "Member is already a moderator")
+		return
+	}
+	if !shard.accept(context, msg) {
+		return
+	}
+	changed := *community
+	changed.Moderators = withFlag(community.Moderators, msg.MemberID, true)
+	entry := shard.newModAction(context, msg.ModeratorID, ModActionAddModerator, msg.MemberID, "")
+	if !shard.persist(context, shard.store.PutCommunity(&changed)) || !shard.storeModAction(context, community, entry) {
+		return
+	}
+	community.Moderators = changed.Moderators
+	shard.recordModAction(context, community, entry)
+}
+
+func (shard *communityShard) removeModerator(context actor.Context, msg *RemoveModerator) {
+	community, ok := shard.moderatedCommunity(context, msg.ModeratorID)
+	if !ok {
+		return
+	}
+	if msg.ModeratorID != community.OwnerID && msg.ModeratorID != msg.MemberID {
+		shard.fail(context, ErrNotModerator, "Only the owner can remove other moderators")
+		return
+	}
+	if msg.MemberID == community.OwnerID {
+		shard.fail(context, ErrInvalidRequest, "The owner cannot be removed as moderator")
+		return
+	}
+	if !community.Moderators[msg.MemberID] {
+		shard.fail(context, ErrInvalidRequest, "Member is not a moderator")
+		return
+	}
+	if !shard.accept(context, msg) {
+		return
+	}
+	changed := *community
+	changed.Moderators = withFlag(community.Moderators, msg.MemberID, false)
+	entry := shard.newModAction(context, msg.ModeratorID, ModActionRemoveModerator, msg.MemberID, "")
+	if !shard.persist(context, shard.store.PutCommunity(&changed)) || !shard.storeModAction(context, community, entry) {
+		return
+	}
+	community.Moderators = changed.Moderators
+	shard.recordModAction(context, community, entry)
+}
+
+func (shard *communityShard) removeContent(context actor.Context, msg *RemoveContent) {
+	community, ok := shard.moderatedCommunity(context, msg.ModeratorID)
+	if !ok {
+		return
+	}
+	if thread, exists := shard.threads[msg.TargetID]; exists {
+		if !shard.accept(context, msg) {
+			return
+		}
+		removed := *thread
+		removed.Removed, removed.Pinned = true, false
+		entry := shard.newModAction(context, msg.ModeratorID, ModActionRemoveThread, msg.TargetID, msg.Reason)
+		if !shard.persist(context, shard.store.PutThread(&removed)) || !shard.storeModAction(context, community, entry) {
+			return
+		}
+		thread.Removed, thread.Pinned = true, false
+		shard.recordModAction(context, community, entry)
+		return
+	}
+	if reply, exists := shard.replies[msg.TargetID]; exists {
+		if !shard.accept(context, msg) {
+			return
+		}
+		removed := *reply
+		removed.Removed = true
+		entry := shard.newModAction(context, msg.ModeratorID, ModActionRemoveReply, msg.TargetID, msg.Reason)
+		if !shard.persist(context, shard.store.PutReply(&removed)) || !shard.storeModAction(context, community, entry) {
+			return
+		}
+		reply.Removed = true
+		shard.recordModAction(context, community, entry)
+		return
+	}
+	shard.fail(context, ErrTargetNotFound, "Thread or reply not found in this community")
+}
+
+func (shard *communityShard) lockThread(context actor.Context, msg *LockThread) {
+	community, ok := shard.moderatedCommunity(context, msg.ModeratorID)
+	if !ok {
+		return
+	}
+	thread, ok := shard.moderatedThread(context, msg.ThreadID)
+	if !ok || !shard.accept(context, msg) {
+		return
+	}
+	action := ModActionUnlock
+	if msg.Locked {
+		action = ModActionLock
+	}
+	locked := *thread
+	locked.Locked = msg.Locked
+	entry := shard.newModAction(context, msg.ModeratorID, action, msg.ThreadID, "")
+	if !shard.persist(context, shard.store.PutThread(&locked)) || !shard.storeModAction(context, community, entry) {
+		return
+	}
+	thread.Locked = msg.Locked
+	shard.recordModAction(context, community, entry)
+}
+
+func (shard *communityShard) pinThread(context actor.Context, msg *PinThread) {
+	community, ok := shard.moderatedCommunity(context, msg.ModeratorID)
+	if !ok {
+		return
+	}
+	thread, ok := shard.moderatedThread(context, msg.ThreadID)
+	if !ok {
+		return
+	}
+	if msg.Pinned && !thread.Pinned {
+		if thread.Removed {
+			shard.fail(context, ErrInvalidRequest, "Removed threads cannot be pinned")
+			return
+		}
+		pinned := 0
+		for _, other := range community.Threads {
+			if other.Pinned {
+				pinned++
+			}
+		}
+		if pinned >= maxPinnedThreads {
+			shard.fail(context, ErrInvalidRequest, fmt.Sprintf("At most %d threads can be pinned", maxPinnedThreads))
+			return
+		}
+	}
+	if !shard.accept(context, msg) {
+		return
+	}
+	action := ModActionUnpin
+	if msg.Pinned {
+		action = ModActionPin
+	}
+	pinned := *thread
+	pinned.Pinned = msg.Pinned
+	entry := shard.newModAction(context, msg.ModeratorID, action, msg.ThreadID, "")
+	if !shard.persist(context, shard.store.PutThread(&pinned)) || !shard.storeModAction(context, community, entry) {
+		return
+	}
+	thread.Pinned = msg.Pinned
+	shard.recordModAction(context, community, entry)
+}
+
+func (shard *communityShard) banMember(context actor.Context, msg *BanMember, members map[string]bool) {
+	community, ok := shard.moderatedCommunity(context, msg.ModeratorID)
+	if !ok {
+		return
+	}
+	if !members[msg.MemberID] {
+		shard.fail(context, ErrMemberNotFound, "Member not found")
+		return
+	}
+	if msg.Banned && community.Moderators[msg.MemberID] {
+		shard.fail(context, ErrInvalidRequest, "Moderators cannot be banned")
+		return
+	}
+	if !shard.accept(context, msg) {
+		return
+	}
+	action := ModActionUnban
+	if msg.Banned {
+		action = ModActionBan
+	}
+	changed := *community
+	changed.Banned = withFlag(community.Banned, msg.MemberID, msg.Banned)
+	entry := shard.newModAction(context, msg.ModeratorID, action, msg.MemberID, msg.Reason)
+	if !shard.persist(context, shard.store.PutCommunity(&changed)) || !shard.storeModAction(context, community, entry) {
+		return
+	}
+	community.Banned = changed.Banned
+	shard.recordModAction(context, community, entry)
+}
+
+func (shard *communityShard) fetchModLog(context actor.Context, msg *FetchModLog) {
+	community, ok := shard.moderatedCommunity(context, msg.ModeratorID)
+	if !ok {
+		return
+	}
+	entries := make([]*ModLogEntry, len(community.ModLog))
+	copy(entries, community.ModLog)
+	shard.respond(context, &ModLog{CommunityID: community.Name, Entries: entries})
+}
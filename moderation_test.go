@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestModerationActions(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		test := startTestEngine(t, NewMemoryStore(), sharded)
+		ownerID := test.addMember("owner")
+		modID := test.addMember("mod")
+		memberID := test.addMember("member")
+		test.createCommunity("golang", ownerID)
+		test.createCommunity("rust", ownerID)
+		first := test.createThread("golang", memberID)
+		second := test.createThread("golang", memberID)
+		third := test.createThread("golang", memberID)
+		removed := test.createThread("golang", memberID)
+		elsewhere := test.createThread("rust", memberID)
+		replyID := test.createReply(first, "", memberID)
+
+		tests := []struct {
+			name    string
+			command interface{}
+			code    ErrorCode
+			action  string
+		}{
+			{name: "unknown community", command: &AddModerator{ModeratorID: ownerID, CommunityID: "python", MemberID: modID}, code: ErrCommunityNotFound},
+			{name: "not a moderator", command: &AddModerator{ModeratorID: memberID, CommunityID: "golang", MemberID: modID}, code: ErrNotModerator},
+			{name: "unknown member", command: &AddModerator{ModeratorID: ownerID, CommunityID: "golang", MemberID: MemberIDPrefix + "missing"}, code: ErrMemberNotFound},
+			{name: "add moderator", command: &AddModerator{ModeratorID: ownerID, CommunityID: "golang", MemberID: modID}, action: ModActionAddModerator},
+			{name: "add moderator twice", command: &AddModerator{ModeratorID: ownerID, CommunityID: "golang", MemberID: modID}, code: ErrInvalidRequest},
+			{name: "moderator removes the owner", command: &RemoveModerator{ModeratorID: modID, CommunityID: "golang", MemberID: ownerID}, code: ErrNotModerator},
+			{name: "owner removes themselves", command: &RemoveModerator{ModeratorID: ownerID, CommunityID: "golang", MemberID: ownerID}, code: ErrInvalidRequest},
+			{name: "ban a moderator", command: &BanMember{ModeratorID: ownerID, CommunityID: "golang", MemberID: modID, Banned: true}, code: ErrInvalidRequest},
+			{name: "lock", command: &LockThread{ModeratorID: modID, CommunityID: "golang", ThreadID: first, Locked: true}, action: ModActionLock},
+			{name: "lock a thread elsewhere", command: &LockThread{ModeratorID: modID, CommunityID: "golang", ThreadID: elsewhere, Locked: true}, code: ErrThreadNotFound},
+			{name: "remove a thread elsewhere", command: &RemoveContent{ModeratorID: modID, CommunityID: "golang", TargetID: elsewhere}, code: ErrTargetNotFound},
+			{name: "remove thread", command: &RemoveContent{ModeratorID: modID, CommunityID: "golang", TargetID: removed, Reason: "spam"}, action: ModActionRemoveThread},
+			{name: "remove reply", command: &RemoveContent{ModeratorID: modID, CommunityID: "golang", TargetID: replyID}, action: ModActionRemoveReply},
+			{name: "pin a removed thread", command: &PinThread{ModeratorID: modID, CommunityID: "golang", ThreadID: removed, Pinned: true}, code: ErrInvalidRequest},
+			{name: "pin", command: &PinThread{ModeratorID: modID, CommunityID: "golang", ThreadID: first, Pinned: true}, action: ModActionPin},
+			{name: "pin another", command: &PinThread{ModeratorID: modID, CommunityID: "golang", ThreadID: second, Pinned: true}, action: ModActionPin},
+			{name: "pin past the limit", command: &PinThread{ModeratorID: modID, CommunityID: "golang", ThreadID: third, Pinned: true}, code: ErrInvalidRequest},
+			{name: "unpin", command: &PinThread{ModeratorID: modID, CommunityID: "golang", ThreadID: second}, action: ModActionUnpin},
+			{name: "ban", command: &BanMember{ModeratorID: modID, CommunityID: "golang", MemberID: memberID, Banned: true, Reason: "spam"}, action: ModActionBan},
+			{name: "unban", command: &BanMember{ModeratorID: modID, CommunityID: "golang", MemberID: memberID}, action: ModActionUnban},
+			{name: "moderator steps down", command: &RemoveModerator{ModeratorID: modID, CommunityID: "golang", MemberID: modID}, action: ModActionRemoveModerator},
+			{name: "former moderator", command: &LockThread{ModeratorID: modID, CommunityID: "golang", ThreadID: first}, code: ErrNotModerator},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				test := test.in(t)
+				if tc.code != "" {
+					expectFailure(test, tc.command, tc.code)
+					return
+				}
+				recorded := expect[*ModActionRecorded](test, tc.command)
+				if recorded.Entry.Action != tc.action {
+					t.Errorf("recorded %s, want %s", recorded.Entry.Action, tc.action)
+				}
+			})
+		}
+
+		expectFailure(test, &CreateReply{Content: "Reply", CreatorID: memberID, ThreadID: first}, ErrThreadLocked)
+		if tree := expect[*ThreadTree](test, &FetchThread{ThreadID: removed}); tree.Title != removedPlaceholder || tree.CreatorID != "" {
+			t.Errorf("removed thread shows %q by %q, want a placeholder and no author", tree.Title, tree.CreatorID)
+		}
+		expectFailure(test, &FetchModLog{ModeratorID: memberID, CommunityID: "golang"}, ErrNotModerator)
+		log := expect[*ModLog](test, &FetchModLog{ModeratorID: ownerID, CommunityID: "golang"})
+		if len(log.Entries) != 10 || log.Entries[2].Reason != "spam" {
+			t.Errorf("mod log holds %d entries, want 10 with the removal's reason", len(log.Entries))
+		}
+	}
+}
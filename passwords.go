@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	passwordHashScheme     = "pbkdf2-sha256"
+	passwordHashIterations = 310000
+	passwordSaltBytes      = 16
+	passwordKeyBytes       = 32
+)
+
+// passwordSlots bounds how many password hashes are computed at once. Each
+// takes a good fraction of a second, so a burst of logins would otherwise
+// leave no CPU for anything else.
+var passwordSlots = make(chan struct{}, max(1, runtime.GOMAXPROCS(0)/2))
+
+// deriveKey runs pbkdf2SHA256 once a slot is free.
+func deriveKey(password, salt []byte, iterations, keyLength int) []byte {
+	passwordSlots <- struct{}{}
+	defer func() { <-passwordSlots }()
+	return pbkdf2SHA256(password, salt, iterations, keyLength)
+}
+
+// pbkdf2SHA256 derives a key as specified in RFC 8018 section 5.2.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	blocks := (keyLength + prf.Size() - 1) / prf.Size()
+	key := make([]byte, 0, blocks*prf.Size())
+	var counter [4]byte
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLength]
+}
+
+// hashPassword is slow on purpose, like verifyPassword, so neither may run on
+// the engine actor. It returns a self-describing hash of the form
+// "pbkdf2-sha256$<iterations>$<salt>$<key>" with base64 salt and key.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := deriveKey([]byte(password), salt, passwordHashIterations, passwordKeyBytes)
+	return fmt.Sprintf("%s$%d$%s$%s", passwordHashScheme, passwordHashIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyPassword reports whether password matches a hash from hashPassword.
+func verifyPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordHashScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	key := deriveKey([]byte(password), salt, iterations, len(expected))
+	return hmac.Equal(key, expected)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// Vectors from RFC 7914 section 11 and the widely used SHA-256
+	// counterparts of RFC 6070
+	tests := []struct {
+		password, salt string
+		iterations     int
+		keyLength      int
+		want           string
+	}{
+		{"passwd", "salt", 1, 64, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, 64, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+		{"password", "salt", 2, 32, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, 20, "c5e478d59288c841aa530db6845c4c8d962893a0"},
+	}
+	for _, test := range tests {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(test.password), []byte(test.salt), test.iterations, test.keyLength))
+		if got != test.want {
+			t.Errorf("pbkdf2SHA256(%q, %q, %d, %d) = %s, want %s", test.password, test.salt, test.iterations, test.keyLength, got, test.want)
+		}
+	}
+}
+
+func TestHashAndVerifyPassword(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, passwordHashScheme+"$") {
+		t.Fatalf("hash %q does not name its scheme", hash)
+	}
+	other, _ := hashPassword("correct horse")
+	if other == hash {
+		t.Error("two hashes of the same password share a salt")
+	}
+	parts := strings.Split(hash, "$")
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		want     bool
+	}{
+		{"right password", hash, "correct horse", true},
+		{"wrong password", hash, "correct horse ", false},
+		{"empty password", hash, "", false},
+		{"other scheme", "bcrypt$" + strings.Join(parts[1:], "$"), "correct horse", false},
+		{"bad iterations", strings.Join([]string{parts[0], "x", parts[2], parts[3]}, "$"), "correct horse", false},
+		{"too few parts", strings.Join(parts[:3], "$"), "correct horse", false},
+		{"empty hash", "", "", false},
+	}
+	for _, test := range tests {
+		if got := verifyPassword(test.hash, test.password); got != test.want {
+			t.Errorf("%s: verifyPassword = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+const (
+	// maxReplyDepth is the deepest nesting level a new reply may have.
+	maxReplyDepth = 10
+
+	defaultTreeDepth   = 5
+	defaultTreeBreadth = 20
+	maxTreeBreadth     = 200
+)
+
+func (shard *communityShard) createReply(context actor.Context, msg *CreateReply, members map[string]bool) {
+	thread, exists := shard.threads[msg.ThreadID]
+	if !exists {
+		fmt.Printf("[Engine] Failed to add reply: ThreadID=%s not found\n", msg.ThreadID)
+		shard.fail(context, ErrThreadNotFound, "Thread not found")
+		return
+	}
+	if thread.Locked {
+		shard.fail(context, ErrThreadLocked, "Thread is locked")
+		return
+	}
+	if thread.Deleted {
+		shard.fail(context, ErrContentDeleted, "Thread has been deleted")
+		return
+	}
+	if thread.Removed {
+		shard.fail(context, ErrContentDeleted, "Thread has been removed by a moderator")
+		return
+	}
+	if !members[msg.CreatorID] {
+		fmt.Printf("[Engine] Failed to add reply: CreatorID=%s not found\n", msg.CreatorID)
+		shard.fail(context, ErrMemberNotFound, "Member not found")
+		return
+	}
+	if shard.community.Banned[msg.CreatorID] {
+		shard.fail(context, ErrBanned, "Member is banned from this community")
+		return
+	}
+	var parent *Reply
+	if msg.ParentID != "" {
+		parent, exists = shard.replies[msg.ParentID]
+		if !exists {
+			fmt.Printf("[Engine] Failed to add reply: ParentID=%s not found\n", msg.ParentID)
+			shard.fail(context, ErrReplyNotFound, "Parent reply not found")
+			return
+		}
+		if parent.ThreadID != msg.ThreadID {
+			shard.fail(context, ErrInvalidRequest, "Parent reply belongs to another thread")
+			return
+		}
+		if parent.Deleted {
+			shard.fail(context, ErrContentDeleted, "Parent reply has been deleted")
+			return
+		}
+		if parent.Removed {
+			shard.fail(context, ErrContentDeleted, "Parent reply has been removed by a moderator")
+			return
+		}
+		if parent.Depth+1 > maxReplyDepth {
+			shard.fail(context, ErrReplyTooDeep, "Reply nesting limit reached")
+			return
+		}
+	}
+	if !shard.accept(context, msg) {
+		return
+	}
+
+	replyID := shard.newID(context, ReplyIDPrefix)
+	reply := &Reply{
+		ID:        replyID,
+		Content:   msg.Content,
+		CreatorID: msg.CreatorID,
+		ThreadID:  msg.ThreadID,
+		ParentID:  msg.ParentID,
+		Replies:   make([]*Reply, 0),
+		CreatedAt: shard.now(context),
+	}
+	if parent != nil {
+		reply.Depth = parent.Depth + 1
+	}
+	if !shard.persist(context, shard.store.PutReply(reply)) {
+		return
+	}
+	if parent != nil {
+		parent.Replies = append(parent.Replies, reply)
+	} else {
+		thread.Replies = append(thread.Replies, reply)
+	}
+	shard.replies[replyID] = reply
+	shard.notify(context, &contentAdded{ID: replyID, CommunityID: shard.name})
+	shard.notify(context, &tallyChanged{MemberID: msg.CreatorID, Replies: 1})
+
+	fmt.Printf("[Engine] New reply added: ThreadID=%s, ParentID=%s, Content=%s, Creator=%s\n", msg.ThreadID, msg.ParentID, msg.Content, msg.CreatorID)
+	shard.respond(context, &ReplyCreated{ReplyID: replyID, CreatedAt: reply.CreatedAt})
+}
+
+// countReplies returns the number of replies in the given subtrees.
+func countReplies(replies []*Reply) int {
+	count := len(replies)
+	for _, reply := range replies {
+		count += countReplies(reply.Replies)
+	}
+	return count
+}
+
+// collapseReplies builds the "load more" stub for replies that are not shown.
+func collapseReplies(parentID string, replies []*Reply) *MoreReplies {
+	if len(replies) == 0 {
+		return nil
+	}
+	more := &MoreReplies{
+		ParentID: parentID,
+		Count:    countReplies(replies),
+		ReplyIDs: make([]string, 0, len(replies)),
+	}
+	for _, reply := range replies {
+		more.ReplyIDs = append(more.ReplyIDs, reply.ID)
+	}
+	return more
+}
+
+func newReplyNode(reply *Reply) *ReplyNode {
+	node := &ReplyNode{
+		ID:        reply.ID,
+		Content:   reply.Content,
+		CreatorID: reply.CreatorID,
+		ParentID:  reply.ParentID,
+		Depth:     reply.Depth,
+		Upvotes:   reply.Upvotes,
+		Downvotes: reply.Downvotes,
+		Removed:   reply.Removed,
+		Deleted:   reply.Deleted,
+		CreatedAt: reply.CreatedAt,
+		EditedAt:  reply.EditedAt,
+	}
+	// Keep removed and deleted replies in the tree so their children stay
+	// reachable
+	if reply.Removed {
+		node.Content = removedPlaceholder
+		node.CreatorID = ""
+	} else if reply.Deleted {
+		node.Content = deletedPlaceholder
+		node.CreatorID = ""
+	}
+	return node
+}
+
+// buildReplyNodes copies up to maxBreadth replies per level and maxDepth
+// levels deep, replacing everything beyond with MoreReplies stubs.
+func buildReplyNodes(parentID string, replies []*Reply, depth, maxDepth, maxBreadth int) ([]*ReplyNode, *MoreReplies) {
+	nodes := make([]*ReplyNode, 0)
+	if depth >= maxDepth {
+		return nodes, collapseReplies(parentID, replies)
+	}
+	shown := replies
+	if len(shown) > maxBreadth {
+		shown = replies[:maxBreadth]
+	}
+	for _, reply := range shown {
+		node := newReplyNode(reply)
+		node.Replies, node.More = buildReplyNodes(reply.ID, reply.Replies, depth+1, maxDepth, maxBreadth)
+		nodes = append(nodes, node)
+	}
+	return nodes, collapseReplies(parentID, replies[len(shown):])
+}
+
+func (shard *communityShard) fetchThread(context actor.Context, msg *FetchThread) {
+	maxDepth := msg.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultTreeDepth
+	}
+	if maxDepth > maxReplyDepth+1 {
+		maxDepth = maxReplyDepth + 1
+	}
+	maxBreadth := msg.MaxBreadth
+	if maxBreadth <= 0 {
+		maxBreadth = defaultTreeBreadth
+	}
+	if maxBreadth > maxTreeBreadth {
+		maxBreadth = maxTreeBreadth
+	}
+
+	thread, exists := shard.threads[msg.ThreadID]
+	if !exists {
+		shard.fail(context, ErrThreadNotFound, "Thread not found")
+		return
+	}
+	tree := &ThreadTree{
+		ID:          thread.ID,
+		Title:       thread.Title,
+		Content:     thread.Content,
+		CreatorID:   thread.CreatorID,
+		CommunityID: thread.CommunityID,
+		Upvotes:     thread.Upvotes,
+		Downvotes:   thread.Downvotes,
+		Locked:      thread.Locked,
+		Pinned:      thread.Pinned,
+		Removed:     thread.Removed,
+		Deleted:     thread.Deleted,
+		CreatedAt:   thread.CreatedAt,
+		EditedAt:    thread.EditedAt,
+	}
+	// Like replies, removed and deleted threads keep their replies but lose
+	// what identifies them
+	if thread.Removed {
+		tree.Title = removedPlaceholder
+		tree.Content = removedPlaceholder
+		tree.CreatorID = ""
+	} else if thread.Deleted {
+		tree.Title = deletedPlaceholder
+		tree.Content = deletedPlaceholder
+		tree.CreatorID = ""
+	}
+	if msg.ReplyID == "" {
+		tree.Replies, tree.More = buildReplyNodes(thread.ID, thread.Replies, 0, maxDepth, maxBreadth)
+	} else {
+		root, exists := shard.replies[msg.ReplyID]
+		if !exists || root.ThreadID != thread.ID {
+			shard.fail(context, ErrReplyNotFound, "Reply not found")
+			return
+		}
+		node := newReplyNode(root)
+		node.Replies, node.More = buildReplyNodes(root.ID, root.Replies, 1, maxDepth, maxBreadth)
+		tree.Replies = []*ReplyNode{node}
+	}
+	shard.respond(context, tree)
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestCreateReplyRejections(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		test := startTestEngine(t, NewMemoryStore(), sharded)
+		ownerID := test.addMember("owner")
+		memberID := test.addMember("member")
+		test.createCommunity("golang", ownerID)
+		threadID := test.createThread("golang", ownerID)
+		removedReplyID := test.createReply(threadID, "", memberID)
+		expect[*ModActionRecorded](test, &RemoveContent{ModeratorID: ownerID, CommunityID: "golang", TargetID: removedReplyID})
+		removedThreadID := test.createThread("golang", ownerID)
+		expect[*ModActionRecorded](test, &RemoveContent{ModeratorID: ownerID, CommunityID: "golang", TargetID: removedThreadID})
+
+		tests := []struct {
+			name  string
+			reply *CreateReply
+			code  ErrorCode
+		}{
+			{"unknown thread", &CreateReply{Content: "Hi", CreatorID: memberID, ThreadID: ThreadIDPrefix + "missing"}, ErrThreadNotFound},
+			{"unknown creator", &CreateReply{Content: "Hi", CreatorID: MemberIDPrefix + "missing", ThreadID: threadID}, ErrMemberNotFound},
+			{"removed thread", &CreateReply{Content: "Hi", CreatorID: memberID, ThreadID: removedThreadID}, ErrContentDeleted},
+			{"removed parent", &CreateReply{Content: "Hi", CreatorID: memberID, ThreadID: threadID, ParentID: removedReplyID}, ErrContentDeleted},
+			{"unknown parent", &CreateReply{Content: "Hi", CreatorID: memberID, ThreadID: threadID, ParentID: ReplyIDPrefix + "missing"}, ErrReplyNotFound},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				expectFailure(test.in(t), tc.reply, tc.code)
+			})
+		}
+	}
+}
+
+func TestCountReplies(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies []*Reply
+		want    int
+	}{
+		{"none", nil, 0},
+		{"flat", []*Reply{{}, {}}, 2},
+		{"nested", []*Reply{{Replies: []*Reply{{}, {Replies: []*Reply{{}}}}}, {}}, 5},
+	}
+	for _, test := range tests {
+		if got := countReplies(test.replies); got != test.want {
+			t.Errorf("%s: countReplies = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
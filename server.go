@@ -4,144 +4,830 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"sync"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
 )
 
 type Server struct {
-	engine *CommunityEngine
-	lock   sync.Mutex
+	actorSystem *actor.ActorSystem
+	enginePID   *actor.PID
+	timeout     time.Duration
+
+	// cluster routes commands to the grains that own them. Without it every
+	// command goes to enginePID.
+	cluster *ClusterNode
 }
 
-func NewServer(engine *CommunityEngine) *Server {
+func NewServer(system *actor.ActorSystem, enginePID *actor.PID) *Server {
 	return &Server{
-		engine: engine,
+		actorSystem: system,
+		enginePID:   enginePID,
+		timeout:     5 * time.Second,
 	}
 }
 
 func (s *Server) RegisterRoutes() {
 	http.HandleFunc("/register", s.RegisterMember)
-	http.HandleFunc("/community", s.CreateCommunity)
-	http.HandleFunc("/thread", s.CreateThread)
-	http.HandleFunc("/reply", s.CreateReply)
+	http.HandleFunc("/login", s.Login)
+	http.HandleFunc("/logout", s.authenticate(s.Logout))
+	http.HandleFunc("/logout/all", s.authenticate(s.RevokeSessions))
+	http.HandleFunc("/community", s.authenticate(s.CreateCommunity))
+	http.HandleFunc("/thread", s.authenticate(s.CreateThread))
+	http.HandleFunc("/reply", s.authenticate(s.CreateReply))
+	http.HandleFunc("/vote", s.authenticate(s.CastVote))
+	http.HandleFunc("/community/{name}/join", s.authenticate(s.JoinCommunity))
+	http.HandleFunc("/community/{name}/leave", s.authenticate(s.LeaveCommunity))
+	http.HandleFunc("/community/{name}/mod/moderators/add", s.authenticate(s.AddModerator))
+	http.HandleFunc("/community/{name}/mod/moderators/remove", s.authenticate(s.RemoveModerator))
+	http.HandleFunc("/community/{name}/mod/remove", s.authenticate(s.RemoveContent))
+	http.HandleFunc("/community/{name}/mod/lock", s.authenticate(s.LockThread))
+	http.HandleFunc("/community/{name}/mod/pin", s.authenticate(s.PinThread))
+	http.HandleFunc("/community/{name}/mod/ban", s.authenticate(s.BanMember))
+	http.HandleFunc("/community/{name}/mod/log", s.authenticate(s.FetchModLog))
+	http.HandleFunc("/communities", s.ListCommunities)
+	http.HandleFunc("/community/{name}", s.FetchCommunity)
+	http.HandleFunc("/community/{name}/threads", s.FetchCommunityThreads)
+	http.HandleFunc("/user/{id}", s.FetchProfile)
+	http.HandleFunc("/user/{id}/communities", s.ListMemberCommunities)
+	http.HandleFunc("/feed", s.authenticate(s.FetchFeed))
+	http.HandleFunc("/thread/{id}", s.Thread)
+	http.HandleFunc("/thread/{id}/history", s.FetchRevisions)
+	http.HandleFunc("/reply/{id}", s.Reply)
+	http.HandleFunc("/reply/{id}/history", s.FetchRevisions)
+	http.HandleFunc("/messages", s.authenticate(s.SendMessage))
+	http.HandleFunc("/messages/inbox", s.authenticate(s.FetchInbox))
+	http.HandleFunc("/messages/sent", s.authenticate(s.FetchSent))
+	http.HandleFunc("/messages/conversations", s.authenticate(s.ListConversations))
+	http.HandleFunc("/messages/conversations/{member}", s.authenticate(s.FetchConversation))
+	http.HandleFunc("/messages/block", s.authenticate(s.BlockMember))
+	http.HandleFunc("/message/{id}/read", s.authenticate(s.MarkMessageRead))
+}
+
+// request sends a command to the CommunityEngine and waits for its response.
+func (s *Server) request(message interface{}) (interface{}, error) {
+	if s.cluster != nil {
+		return s.cluster.Request(message)
+	}
+	return s.actorSystem.Root.RequestFuture(s.enginePID, message, s.timeout).Result()
+}
+
+// Error codes for failures detected by the HTTP layer rather than the engine.
+const (
+	ErrMethodNotAllowed   ErrorCode = "method_not_allowed"
+	ErrNotFound           ErrorCode = "not_found"
+	ErrUnauthorized       ErrorCode = "unauthorized"
+	ErrForbidden          ErrorCode = "forbidden"
+	ErrEngineUnavailable  ErrorCode = "engine_unavailable"
+	ErrUnexpectedResponse ErrorCode = "unexpected_response"
+)
+
+// CreatedResponse is the body returned when a resource is created.
+type CreatedResponse struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIError is the error half of every failed response, wrapped in an
+// ErrorResponse envelope on the wire.
+type APIError struct {
+	StatusCode int       `json:"-"`
+	Code       ErrorCode `json:"code"`
+	Message    string    `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code ErrorCode, message string) {
+	writeJSON(w, status, &ErrorResponse{Error: &APIError{Code: code, Message: message}})
+}
+
+// writeEngineError reports a failed or unexpected engine response.
+func (s *Server) writeEngineError(w http.ResponseWriter, result interface{}, err error) {
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, ErrEngineUnavailable, "Engine unavailable")
+		return
+	}
+	if failed, ok := result.(*CommandFailed); ok {
+		writeError(w, statusForCode(failed.Code), failed.Code, failed.Reason)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, ErrUnexpectedResponse, "Unexpected engine response")
+}
+
+// statusForCode maps an engine error code to an HTTP status.
+func statusForCode(code ErrorCode) int {
+	switch code {
+	case ErrMemberNotFound, ErrCommunityNotFound, ErrThreadNotFound, ErrTargetNotFound, ErrReplyNotFound, ErrMessageNotFound:
+		return http.StatusNotFound
+	case ErrAlreadyMember, ErrNotMember, ErrUsernameTaken, ErrCommunityExists:
+		return http.StatusConflict
+	case ErrInvalidCredentials, ErrInvalidSession:
+		return http.StatusUnauthorized
+	case ErrNotModerator, ErrBanned, ErrThreadLocked, ErrBlocked:
+		return http.StatusForbidden
+	case ErrContentDeleted:
+		return http.StatusGone
+	case ErrUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// intQuery parses an optional integer query parameter, returning 0 if absent.
+func intQuery(query url.Values, key string) (int, error) {
+	value := query.Get(key)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
 }
 
 func (s *Server) RegisterMember(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
 		return
 	}
 	var req RegisterMember
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body is not valid JSON")
 		return
 	}
-	s.lock.Lock()
-	memberID := generateID()
-	member := &Member{
-		ID:       memberID,
-		Username: req.Username,
-		Password: req.Password,
-		Karma:    0,
+	result, err := s.request(&req)
+	registered, ok := result.(*MemberRegistered)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
 	}
-	s.engine.members[memberID] = member
-	s.lock.Unlock()
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, "Member registered with ID: %s", memberID)
+	writeJSON(w, http.StatusCreated, &CreatedResponse{ID: registered.MemberID, CreatedAt: registered.CreatedAt})
+}
+
+func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	var req Login
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body is not valid JSON")
+		return
+	}
+	result, err := s.request(&req)
+	loggedIn, ok := result.(*LoginSucceeded)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, loggedIn)
+}
+
+// FetchProfile serves GET /user/{id}, where id is a member ID or a username.
+func (s *Server) FetchProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	req := FetchProfile{Username: r.PathValue("id")}
+	if strings.HasPrefix(req.Username, MemberIDPrefix) {
+		req.MemberID = req.Username
+	}
+	result, err := s.request(&req)
+	profile, ok := result.(*MemberProfile)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func (s *Server) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	result, err := s.request(&Logout{Token: bearerToken(r)})
+	loggedOut, ok := result.(*LoggedOut)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, loggedOut)
+}
+
+func (s *Server) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	result, err := s.request(&RevokeSessions{MemberID: callerFrom(r).MemberID})
+	loggedOut, ok := result.(*LoggedOut)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, loggedOut)
 }
 
 func (s *Server) CreateCommunity(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
 		return
 	}
 	var req CreateCommunity
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body is not valid JSON")
 		return
 	}
-	s.lock.Lock()
-	community := &Community{
-		Name:         req.Name,
-		Description:  req.Description,
-		Participants: make(map[string]bool),
-		Threads:      make([]*Thread, 0),
+	if !actAs(w, r, &req.FounderID) {
+		return
+	}
+	result, err := s.request(&req)
+	created, ok := result.(*CommunityCreated)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
 	}
-	s.engine.communities[req.Name] = community
-	s.lock.Unlock()
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, "Community created: %s", req.Name)
+	writeJSON(w, http.StatusCreated, &CreatedResponse{ID: created.Name, CreatedAt: created.CreatedAt})
 }
 
 func (s *Server) CreateThread(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
 		return
 	}
 	var req CreateThread
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body is not valid JSON")
 		return
 	}
-	s.lock.Lock()
-	threadID := generateID()
-	thread := &Thread{
-		ID:          threadID,
-		Title:       req.Title,
-		Content:     req.Content,
-		CreatorID:   req.CreatorID,
-		CommunityID: req.CommunityID,
-		Replies:     make([]*Reply, 0),
-		CreatedAt:   time.Now(),
+	if !actAs(w, r, &req.CreatorID) {
+		return
 	}
-	s.engine.threads[threadID] = thread
-	if community, exists := s.engine.communities[req.CommunityID]; exists {
-		community.Threads = append(community.Threads, thread)
+	result, err := s.request(&req)
+	created, ok := result.(*ThreadCreated)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
 	}
-	s.lock.Unlock()
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, "Thread created with ID: %s", threadID)
+	writeJSON(w, http.StatusCreated, &CreatedResponse{ID: created.ThreadID, CreatedAt: created.CreatedAt})
 }
 
 func (s *Server) CreateReply(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
 		return
 	}
 	var req CreateReply
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
-		return
-	}
-	s.lock.Lock()
-	replyID := generateID()
-	if thread, exists := s.engine.threads[req.ThreadID]; exists {
-		reply := &Reply{
-			ID:        replyID,
-			Content:   req.Content,
-			CreatorID: req.CreatorID,
-			ThreadID:  req.ThreadID,
-			ParentID:  req.ParentID,
-			Replies:   make([]*Reply, 0),
-			CreatedAt: time.Now(),
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body is not valid JSON")
+		return
+	}
+	if !actAs(w, r, &req.CreatorID) {
+		return
+	}
+	result, err := s.request(&req)
+	created, ok := result.(*ReplyCreated)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, &CreatedResponse{ID: created.ReplyID, CreatedAt: created.CreatedAt})
+}
+
+// Thread serves /thread/{id}. Reading is public; editing and deleting need a
+// session.
+func (s *Server) Thread(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.FetchThread(w, r)
+	case http.MethodPatch:
+		s.authenticate(s.EditThread)(w, r)
+	case http.MethodDelete:
+		s.authenticate(s.DeleteThread)(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+	}
+}
+
+// Reply serves /reply/{id}. Replies are read through their thread.
+func (s *Server) Reply(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPatch:
+		s.authenticate(s.EditReply)(w, r)
+	case http.MethodDelete:
+		s.authenticate(s.DeleteReply)(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+	}
+}
+
+func (s *Server) EditThread(w http.ResponseWriter, r *http.Request) {
+	var req EditThread
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body is not valid JSON")
+		return
+	}
+	req.ThreadID = r.PathValue("id")
+	if !actAs(w, r, &req.EditorID) {
+		return
+	}
+	s.edit(w, &req)
+}
+
+func (s *Server) EditReply(w http.ResponseWriter, r *http.Request) {
+	var req EditReply
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body is not valid JSON")
+		return
+	}
+	req.ReplyID = r.PathValue("id")
+	if !actAs(w, r, &req.EditorID) {
+		return
+	}
+	s.edit(w, &req)
+}
+
+func (s *Server) edit(w http.ResponseWriter, command interface{}) {
+	result, err := s.request(command)
+	edited, ok := result.(*ContentEdited)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, edited)
+}
+
+func (s *Server) DeleteThread(w http.ResponseWriter, r *http.Request) {
+	s.delete(w, &DeleteThread{MemberID: callerFrom(r).MemberID, ThreadID: r.PathValue("id")})
+}
+
+func (s *Server) DeleteReply(w http.ResponseWriter, r *http.Request) {
+	s.delete(w, &DeleteReply{MemberID: callerFrom(r).MemberID, ReplyID: r.PathValue("id")})
+}
+
+func (s *Server) delete(w http.ResponseWriter, command interface{}) {
+	result, err := s.request(command)
+	deleted, ok := result.(*ContentDeleted)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deleted)
+}
+
+// FetchRevisions serves GET /thread/{id}/history and GET /reply/{id}/history.
+func (s *Server) FetchRevisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	result, err := s.request(&FetchRevisions{TargetID: r.PathValue("id")})
+	revisions, ok := result.(*Revisions)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, revisions)
+}
+
+// FetchThread serves GET /thread/{id}?comment=ID&depth=N&limit=N. Branches
+// beyond depth levels or limit siblings come back as MoreReplies stubs.
+func (s *Server) FetchThread(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	query := r.URL.Query()
+	req := FetchThread{
+		ThreadID: r.PathValue("id"),
+		ReplyID:  query.Get("comment"),
+	}
+	var err error
+	if req.MaxDepth, err = intQuery(query, "depth"); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Query parameter depth must be an integer")
+		return
+	}
+	if req.MaxBreadth, err = intQuery(query, "limit"); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Query parameter limit must be an integer")
+		return
+	}
+	result, err := s.request(&req)
+	tree, ok := result.(*ThreadTree)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tree)
+}
+
+func (s *Server) CastVote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	var req CastVote
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body is not valid JSON")
+		return
+	}
+	if !actAs(w, r, &req.MemberID) {
+		return
+	}
+	result, err := s.request(&req)
+	recorded, ok := result.(*VoteRecorded)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, recorded)
+}
+
+func (s *Server) ListCommunities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	query := r.URL.Query()
+	req := ListCommunities{After: query.Get("after")}
+	var err error
+	if req.Limit, err = intQuery(query, "limit"); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Query parameter limit must be an integer")
+		return
+	}
+	result, err := s.request(&req)
+	communities, ok := result.(*CommunityList)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, communities)
+}
+
+func (s *Server) FetchCommunity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	result, err := s.request(&FetchCommunity{Name: r.PathValue("name")})
+	community, ok := result.(*CommunityView)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, community)
+}
+
+// FetchCommunityThreads serves GET /community/{name}/threads?sort=hot&after=ID&limit=N.
+func (s *Server) FetchCommunityThreads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	query := r.URL.Query()
+	req := FetchCommunityThreads{
+		Name:  r.PathValue("name"),
+		Sort:  FeedSort(query.Get("sort")),
+		After: query.Get("after"),
+	}
+	var err error
+	if req.Limit, err = intQuery(query, "limit"); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Query parameter limit must be an integer")
+		return
+	}
+	result, err := s.request(&req)
+	threads, ok := result.(*FeedResult)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, threads)
+}
+
+func (s *Server) JoinCommunity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	result, err := s.request(&JoinCommunity{MemberID: callerFrom(r).MemberID, CommunityID: r.PathValue("name")})
+	changed, ok := result.(*MembershipChanged)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, changed)
+}
+
+func (s *Server) LeaveCommunity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	result, err := s.request(&LeaveCommunity{MemberID: callerFrom(r).MemberID, CommunityID: r.PathValue("name")})
+	changed, ok := result.(*MembershipChanged)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, changed)
+}
+
+func (s *Server) ListMemberCommunities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	result, err := s.request(&ListMemberCommunities{MemberID: r.PathValue("id")})
+	communities, ok := result.(*MemberCommunities)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, communities)
+}
+
+// FetchFeed serves the caller's feed for GET /feed?sort=hot&after=ID&limit=N.
+func (s *Server) FetchFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	query := r.URL.Query()
+	req := FetchFeed{
+		MemberID: callerFrom(r).MemberID,
+		Sort:     FeedSort(query.Get("sort")),
+		After:    query.Get("after"),
+	}
+	var err error
+	if req.Limit, err = intQuery(query, "limit"); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Query parameter limit must be an integer")
+		return
+	}
+	result, err := s.request(&req)
+	feed, ok := result.(*FeedResult)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, feed)
+}
+
+func (s *Server) SendMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	var req SendMessage
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body is not valid JSON")
+		return
+	}
+	if !actAs(w, r, &req.SenderID) {
+		return
+	}
+	result, err := s.request(&req)
+	delivered, ok := result.(*MessageDelivered)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, &CreatedResponse{ID: delivered.MessageID, CreatedAt: delivered.CreatedAt})
+}
+
+// FetchInbox serves GET /messages/inbox?unread=true&after=ID&limit=N.
+func (s *Server) FetchInbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	query := r.URL.Query()
+	req := FetchInbox{
+		MemberID:   callerFrom(r).MemberID,
+		UnreadOnly: query.Get("unread") == "true",
+		After:      query.Get("after"),
+	}
+	var err error
+	if req.Limit, err = intQuery(query, "limit"); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Query parameter limit must be an integer")
+		return
+	}
+	s.listMessages(w, &req)
+}
+
+func (s *Server) FetchSent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	query := r.URL.Query()
+	req := FetchSent{MemberID: callerFrom(r).MemberID, After: query.Get("after")}
+	var err error
+	if req.Limit, err = intQuery(query, "limit"); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Query parameter limit must be an integer")
+		return
+	}
+	s.listMessages(w, &req)
+}
+
+// FetchConversation serves GET /messages/conversations/{member}, where member
+// is the other participant's ID or username.
+func (s *Server) FetchConversation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	query := r.URL.Query()
+	req := FetchConversation{
+		MemberID: callerFrom(r).MemberID,
+		OtherID:  r.PathValue("member"),
+		After:    query.Get("after"),
+	}
+	var err error
+	if req.Limit, err = intQuery(query, "limit"); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Query parameter limit must be an integer")
+		return
+	}
+	s.listMessages(w, &req)
+}
+
+func (s *Server) listMessages(w http.ResponseWriter, command interface{}) {
+	result, err := s.request(command)
+	messages, ok := result.(*MessageList)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, messages)
+}
+
+func (s *Server) ListConversations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	result, err := s.request(&ListConversations{MemberID: callerFrom(r).MemberID})
+	conversations, ok := result.(*ConversationList)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, conversations)
+}
+
+func (s *Server) MarkMessageRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	req := MarkMessageRead{Read: true}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body is not valid JSON")
+			return
 		}
-		thread.Replies = append(thread.Replies, reply)
-		fmt.Fprintf(w, "Reply created with ID: %s", replyID)
-	} else {
-		http.Error(w, "Thread not found", http.StatusNotFound)
 	}
-	s.lock.Unlock()
+	req.MemberID = callerFrom(r).MemberID
+	req.MessageID = r.PathValue("id")
+	result, err := s.request(&req)
+	message, ok := result.(*PrivateMessage)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, message)
+}
+
+func (s *Server) BlockMember(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	var req BlockMember
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body is not valid JSON")
+		return
+	}
+	if !actAs(w, r, &req.MemberID) {
+		return
+	}
+	result, err := s.request(&req)
+	changed, ok := result.(*BlockChanged)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, changed)
 }
 
-func mainServer() {
-	engine := NewCommunityEngine()
-	server := NewServer(engine)
+// decodeModCommand reads a moderation command from a POST body, taking the
+// community from the URL and the moderator from the session.
+func decodeModCommand(w http.ResponseWriter, r *http.Request, req interface{}, communityID, moderatorID *string) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body is not valid JSON")
+		return false
+	}
+	*communityID = r.PathValue("name")
+	return actAs(w, r, moderatorID)
+}
+
+// moderate forwards a moderation command and answers with its log entry.
+func (s *Server) moderate(w http.ResponseWriter, command interface{}) {
+	result, err := s.request(command)
+	recorded, ok := result.(*ModActionRecorded)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, recorded.Entry)
+}
+
+func (s *Server) AddModerator(w http.ResponseWriter, r *http.Request) {
+	var req AddModerator
+	if decodeModCommand(w, r, &req, &req.CommunityID, &req.ModeratorID) {
+		s.moderate(w, &req)
+	}
+}
+
+func (s *Server) RemoveModerator(w http.ResponseWriter, r *http.Request) {
+	var req RemoveModerator
+	if decodeModCommand(w, r, &req, &req.CommunityID, &req.ModeratorID) {
+		s.moderate(w, &req)
+	}
+}
+
+func (s *Server) RemoveContent(w http.ResponseWriter, r *http.Request) {
+	var req RemoveContent
+	if decodeModCommand(w, r, &req, &req.CommunityID, &req.ModeratorID) {
+		s.moderate(w, &req)
+	}
+}
+
+func (s *Server) LockThread(w http.ResponseWriter, r *http.Request) {
+	var req LockThread
+	if decodeModCommand(w, r, &req, &req.CommunityID, &req.ModeratorID) {
+		s.moderate(w, &req)
+	}
+}
+
+func (s *Server) PinThread(w http.ResponseWriter, r *http.Request) {
+	var req PinThread
+	if decodeModCommand(w, r, &req, &req.CommunityID, &req.ModeratorID) {
+		s.moderate(w, &req)
+	}
+}
+
+func (s *Server) BanMember(w http.ResponseWriter, r *http.Request) {
+	var req BanMember
+	if decodeModCommand(w, r, &req, &req.CommunityID, &req.ModeratorID) {
+		s.moderate(w, &req)
+	}
+}
+
+func (s *Server) FetchModLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Invalid request method")
+		return
+	}
+	result, err := s.request(&FetchModLog{ModeratorID: callerFrom(r).MemberID, CommunityID: r.PathValue("name")})
+	modLog, ok := result.(*ModLog)
+	if err != nil || !ok {
+		s.writeEngineError(w, result, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, modLog)
+}
+
+// mainServer registers the routes and returns a server for address; the
+// caller starts it and shuts it down.
+func mainServer(system *actor.ActorSystem, enginePID *actor.PID, node *ClusterNode, address string) *http.Server {
+	server := NewServer(system, enginePID)
+	server.cluster = node
 	server.RegisterRoutes()
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, "Server is running!")
+		if r.URL.Path != "/" {
+			writeError(w, http.StatusNotFound, ErrNotFound, "No route for "+r.URL.Path)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 	})
-	fmt.Println("Starting server on port 8080...")
-	fmt.Println("DEBUG: Server is starting with updated code...")
-
-	http.ListenAndServe(":8080", nil)
+	return &http.Server{Addr: address}
 }
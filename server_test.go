@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatusForCode(t *testing.T) {
+	tests := []struct {
+		code ErrorCode
+		want int
+	}{
+		{ErrThreadNotFound, http.StatusNotFound},
+		{ErrCommunityExists, http.StatusConflict},
+		{ErrInvalidSession, http.StatusUnauthorized},
+		{ErrBanned, http.StatusForbidden},
+		{ErrContentDeleted, http.StatusGone},
+		{ErrUnavailable, http.StatusServiceUnavailable},
+		{ErrInvalidCommunityName, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if got := statusForCode(test.code); got != test.want {
+			t.Errorf("statusForCode(%s) = %d, want %d", test.code, got, test.want)
+		}
+	}
+}
+
+func TestWriteEngineError(t *testing.T) {
+	tests := []struct {
+		name   string
+		result interface{}
+		err    error
+		status int
+		code   ErrorCode
+	}{
+		{"engine unreachable", nil, errors.New("timeout"), http.StatusServiceUnavailable, ErrEngineUnavailable},
+		{"command failed", &CommandFailed{Code: ErrNotModerator, Reason: "No"}, nil, http.StatusForbidden, ErrNotModerator},
+		{"unexpected response", &LoggedOut{}, nil, http.StatusInternalServerError, ErrUnexpectedResponse},
+	}
+	server := &Server{}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		server.writeEngineError(w, test.result, test.err)
+		var response ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != test.status || response.Error == nil || response.Error.Code != test.code {
+			t.Errorf("%s: answered %d %s, want %d with %s", test.name, w.Code, w.Body, test.status, test.code)
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s: Content-Type = %q", test.name, contentType)
+		}
+	}
+}
+
+// TestHandlerResponses checks the status and body of handlers answering
+// through the engine, including the error envelope of failed requests.
+func TestHandlerResponses(t *testing.T) {
+	test := startTestEngine(t, NewMemoryStore(), true)
+	memberID := test.addMember("member")
+	token := expect[*LoginSucceeded](test, &openSession{MemberID: memberID}).Token
+	test.createCommunity("golang", memberID)
+	server := NewServer(test.system, test.pid)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+		status  int
+		code    ErrorCode
+		id      string
+	}{
+		{name: "wrong method", handler: server.RegisterMember, method: http.MethodGet, status: http.StatusMethodNotAllowed, code: ErrMethodNotAllowed},
+		{name: "invalid JSON", handler: server.Login, method: http.MethodPost, body: "{", status: http.StatusBadRequest, code: ErrInvalidRequest},
+		{name: "engine rejection", handler: server.RegisterMember, method: http.MethodPost, body: `{"Username":"member","Password":"secret"}`, status: http.StatusConflict, code: ErrUsernameTaken},
+		{name: "created", handler: server.authenticate(server.CreateCommunity), method: http.MethodPost, body: `{"Name":"rust"}`, status: http.StatusCreated, id: "rust"},
+		{name: "conflict", handler: server.authenticate(server.CreateCommunity), method: http.MethodPost, body: `{"Name":"golang"}`, status: http.StatusConflict, code: ErrCommunityExists},
+		{name: "invalid name", handler: server.authenticate(server.CreateCommunity), method: http.MethodPost, body: `{"Name":"a b"}`, status: http.StatusBadRequest, code: ErrInvalidCommunityName},
+		{name: "not found", handler: server.FetchProfile, method: http.MethodGet, status: http.StatusNotFound, code: ErrMemberNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/", strings.NewReader(tc.body))
+			r.Header.Set("Authorization", "Bearer "+token)
+			r.SetPathValue("id", "nobody")
+			w := httptest.NewRecorder()
+			tc.handler(w, r)
+			if w.Code != tc.status {
+				t.Fatalf("answered %d %s, want %d", w.Code, w.Body, tc.status)
+			}
+			if tc.code != "" {
+				var response ErrorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Error == nil || response.Error.Code != tc.code || response.Error.Message == "" {
+					t.Errorf("answered %s, want an error envelope with %s", w.Body, tc.code)
+				}
+				return
+			}
+			var created CreatedResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.ID != tc.id || created.CreatedAt.IsZero() {
+				t.Errorf("answered %s, want %s created", w.Body, tc.id)
+			}
+		})
+	}
+}
+
+// TestReadEndpoints checks the query parameters of the read handlers and the
+// number of items they answer with.
+func TestReadEndpoints(t *testing.T) {
+	test := startTestEngine(t, NewMemoryStore(), true)
+	memberID := test.addMember("member")
+	test.createCommunity("golang", memberID)
+	test.createCommunity("rust", memberID)
+	threadID := test.createThread("golang", memberID)
+	test.createThread("golang", memberID)
+	test.createThread("golang", memberID)
+	parentID := test.createReply(threadID, "", memberID)
+	test.createReply(threadID, parentID, memberID)
+	server := NewServer(test.system, test.pid)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		id      string
+		query   string
+		status  int
+		field   string
+		length  int
+	}{
+		{name: "communities page", handler: server.ListCommunities, query: "?limit=1", status: http.StatusOK, field: "Communities", length: 1},
+		{name: "communities with an invalid limit", handler: server.ListCommunities, query: "?limit=one", status: http.StatusBadRequest},
+		{name: "community", handler: server.FetchCommunity, id: "GoLang", status: http.StatusOK, field: "Moderators", length: 1},
+		{name: "unknown community", handler: server.FetchCommunity, id: "python", status: http.StatusNotFound},
+		{name: "community threads page", handler: server.FetchCommunityThreads, id: "golang", query: "?sort=new&limit=2", status: http.StatusOK, field: "Threads", length: 2},
+		{name: "community threads with an unknown sort", handler: server.FetchCommunityThreads, id: "golang", query: "?sort=oldest", status: http.StatusBadRequest},
+		{name: "thread", handler: server.FetchThread, id: threadID, status: http.StatusOK, field: "Replies", length: 1},
+		{name: "thread with an invalid depth", handler: server.FetchThread, id: threadID, query: "?depth=deep", status: http.StatusBadRequest},
+		{name: "unknown thread", handler: server.FetchThread, id: "missing", status: http.StatusNotFound},
+		{name: "profile by username", handler: server.FetchProfile, id: "Member", status: http.StatusOK},
+		{name: "member communities", handler: server.ListMemberCommunities, id: memberID, status: http.StatusOK, field: "Communities", length: 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/"+tc.query, nil)
+			r.SetPathValue("id", tc.id)
+			r.SetPathValue("name", tc.id)
+			w := httptest.NewRecorder()
+			tc.handler(w, r)
+			if w.Code != tc.status {
+				t.Fatalf("answered %d %s, want %d", w.Code, w.Body, tc.status)
+			}
+			if tc.field == "" {
+				return
+			}
+			var body map[string]json.RawMessage
+			var items []json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(body[tc.field], &items); err != nil || len(items) != tc.length {
+				t.Errorf("answered %s %s, want %d", tc.field, body[tc.field], tc.length)
+			}
+		})
+	}
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+// shardTimeout bounds how long the engine waits for a shard's actor to
+// answer a question of its own.
+const shardTimeout = 5 * time.Second
+
+// shardBase is what community and member shards have in common. The engine
+// owns a shard, and applies its commands itself, until it spawns the shard's
+// actor on first use; from then on only that actor touches the shard.
+type shardBase struct {
+	*engineBase
+	// pid is the shard's actor. Only the engine sets it, before passing the
+	// actor its first message.
+	pid *actor.PID
+}
+
+func (shard *shardBase) base() *shardBase {
+	return shard
+}
+
+// notify tells the engine about a change to what its directories hold: at
+// once while the engine applies the shard's commands, or as a message once
+// the shard runs on its own actor. Shards notify the engine before they
+// answer, so by the time the sender can name what changed, the engine knows
+// about it.
+func (shard *shardBase) notify(context actor.Context, note interface{}) {
+	if shard.pid != nil {
+		context.Send(context.Parent(), &shardNote{base: shard.engineBase, note: note})
+		return
+	}
+	shard.notes(context, note)
+}
+
+// shardActor is implemented by both kinds of shard.
+type shardActor interface {
+	actor.Actor
+	base() *shardBase
+	actorName() string
+	// receive handles a message for the shard, on its actor or on the
+	// engine's.
+	receive(context actor.Context, message interface{})
+	// answer answers a question from the engine.
+	answer(question interface{}) interface{}
+}
+
+// communityShard holds a community with its threads, replies and votes.
+// Votes are kept per target and member as upvote or downvote.
+type communityShard struct {
+	shardBase
+	name      string
+	community *Community
+	threads   map[string]*Thread
+	replies   map[string]*Reply
+	votes     map[string]map[string]int
+}
+
+func newCommunityShard(base *engineBase, community *Community) *communityShard {
+	return &communityShard{
+		shardBase: shardBase{engineBase: base},
+		name:      community.Name,
+		community: community,
+		threads:   make(map[string]*Thread),
+		replies:   make(map[string]*Reply),
+		votes:     make(map[string]map[string]int),
+	}
+}
+
+func (shard *communityShard) actorName() string {
+	return "community-" + shard.name
+}
+
+// memberShard holds what belongs to one member alone: the messages they
+// received, the members they block, and copies of the messages they sent,
+// which belong to the shards of their receivers.
+type memberShard struct {
+	shardBase
+	memberID      string
+	inbox         []*PrivateMessage
+	sent          []*PrivateMessage
+	conversations map[string][]*PrivateMessage
+	blocked       map[string]bool
+}
+
+func newMemberShard(base *engineBase, memberID string) *memberShard {
+	return &memberShard{
+		shardBase:     shardBase{engineBase: base},
+		memberID:      memberID,
+		conversations: make(map[string][]*PrivateMessage),
+		blocked:       make(map[string]bool),
+	}
+}
+
+func (shard *memberShard) actorName() string {
+	return "member-" + shard.memberID
+}
+
+// shardRequest is a command the engine passes to a shard, with what only the
+// engine can look up: which of the members the command names exist, and the
+// ID of another member the command names by ID or username.
+type shardRequest struct {
+	command interface{}
+	members map[string]bool
+	otherID string
+}
+
+// shardState asks a shard for a copy of everything it holds, as a Snapshot.
+type shardState struct{}
+
+// listedThreads asks a community's shard for its listed threads.
+type listedThreads struct{}
+
+// describeCommunity asks a community's shard for its CommunityView.
+type describeCommunity struct{}
+
+// shardNote carries a note from a shard's actor to the engine. Notes from
+// the shards of an engine since rebuilt are dropped, since the rebuilt
+// engine counted what they report when it restored its state.
+type shardNote struct {
+	base *engineBase
+	note interface{}
+}
+
+// contentAdded reports a new thread or reply.
+type contentAdded struct {
+	ID          string
+	CommunityID string
+}
+
+// membershipChanged reports a member joining or leaving a community.
+type membershipChanged struct {
+	MemberID    string
+	CommunityID string
+	Joined      bool
+}
+
+// tallyChanged reports a change to a member's karma and counts.
+type tallyChanged struct {
+	MemberID string
+	Karma    int
+	Threads  int
+	Replies  int
+}
+
+// messageAdded reports a delivered message. The engine passes it on to the
+// sender's shard, which keeps a copy.
+type messageAdded struct {
+	Message *PrivateMessage
+}
+
+// messageRead reports that the receiver marked a message read or unread. The
+// engine passes it on to the sender's shard as well.
+type messageRead struct {
+	MessageID string
+	Read      bool
+}
+
+// messageEntry is what the engine knows of a message.
+type messageEntry struct {
+	senderID       string
+	receiverID     string
+	conversationID string
+}
+
+// communityShard finds a community's shard case-insensitively.
+func (engine *CommunityEngine) communityShard(name string) (*communityShard, bool) {
+	shard, exists := engine.communities[strings.ToLower(name)]
+	return shard, exists
+}
+
+// shardList lists the shards of every community and member.
+func (engine *CommunityEngine) shardList() []shardActor {
+	shards := make([]shardActor, 0, len(engine.communities)+len(engine.memberShards))
+	for _, shard := range engine.communities {
+		shards = append(shards, shard)
+	}
+	for _, shard := range engine.memberShards {
+		shards = append(shards, shard)
+	}
+	return shards
+}
+
+// communityCommand names the community a command is confined to, or the
+// thread or reply whose community that is, or returns "" for commands that
+// may touch several communities or none.
+func communityCommand(message interface{}) (reference string, content bool) {
+	switch msg := message.(type) {
+	case *CreateThread:
+		return msg.CommunityID, false
+	case *FetchCommunity:
+		return msg.Name, false
+	case *FetchCommunityThreads:
+		return msg.Name, false
+	case *JoinCommunity:
+		return msg.CommunityID, false
+	case *LeaveCommunity:
+		return msg.CommunityID, false
+	case *AddModerator:
+		return msg.CommunityID, false
+	case *RemoveModerator:
+		return msg.CommunityID, false
+	case *RemoveContent:
+		return msg.CommunityID, false
+	case *LockThread:
+		return msg.CommunityID, false
+	case *PinThread:
+		return msg.CommunityID, false
+	case *BanMember:
+		return msg.CommunityID, false
+	case *FetchModLog:
+		return msg.CommunityID, false
+	case *CreateReply:
+		return msg.ThreadID, true
+	case *CastVote:
+		return msg.TargetID, true
+	case *FetchThread:
+		return msg.ThreadID, true
+	case *EditThread:
+		return msg.ThreadID, true
+	case *EditReply:
+		return msg.ReplyID, true
+	case *DeleteThread:
+		return msg.ThreadID, true
+	case *DeleteReply:
+		return msg.ReplyID, true
+	case *FetchRevisions:
+		return msg.TargetID, true
+	}
+	return "", false
+}
+
+// knownMembers looks up the member a community command names and returns
+// whether it exists, keyed by ID.
+func (engine *CommunityEngine) knownMembers(command interface{}) map[string]bool {
+	var memberID string
+	switch msg := command.(type) {
+	case *CreateThread:
+		memberID = msg.CreatorID
+	case *CreateReply:
+		memberID = msg.CreatorID
+	case *CastVote:
+		memberID = msg.MemberID
+	case *JoinCommunity:
+		memberID = msg.MemberID
+	case *AddModerator:
+		memberID = msg.MemberID
+	case *BanMember:
+		memberID = msg.MemberID
+	}
+	_, exists := engine.members[memberID]
+	return map[string]bool{memberID: exists}
+}
+
+// routeCommunity passes a command to the shard of the community it names,
+// or of the thread or reply it names.
+func (engine *CommunityEngine) routeCommunity(context actor.Context, command interface{}) {
+	reference, content := communityCommand(command)
+	if content {
+		reference = engine.content[reference]
+	}
+	request := &shardRequest{command: command, members: engine.knownMembers(command)}
+	shard, exists := engine.communityShard(reference)
+	if !exists {
+		engine.failUnrouted(context, request)
+		return
+	}
+	engine.pass(context, shard, request)
+}
+
+// failUnrouted rejects a command naming a community, thread or reply that
+// does not exist, after the checks its handler makes first.
+func (engine *CommunityEngine) failUnrouted(context actor.Context, request *shardRequest) {
+	switch msg := request.command.(type) {
+	case *CreateThread:
+		fmt.Printf("[Engine] Failed to create thread: Community=%s not found\n", msg.CommunityID)
+	case *JoinCommunity:
+		if !request.members[msg.MemberID] {
+			fmt.Printf("[Engine] Failed to join community: MemberID=%s not found\n", msg.MemberID)
+			engine.fail(context, ErrMemberNotFound, "Member not found")
+			return
+		}
+		fmt.Printf("[Engine] Failed to join community: Community=%s not found\n", msg.CommunityID)
+	case *LeaveCommunity:
+		fmt.Printf("[Engine] Failed to leave community: Community=%s not found\n", msg.CommunityID)
+	case *CreateReply:
+		fmt.Printf("[Engine] Failed to add reply: ThreadID=%s not found\n", msg.ThreadID)
+		engine.fail(context, ErrThreadNotFound, "Thread not found")
+		return
+	case *CastVote:
+		if !request.members[msg.MemberID] {
+			fmt.Printf("[Engine] Failed to record vote: MemberID=%s not found\n", msg.MemberID)
+			engine.fail(context, ErrMemberNotFound, "Member not found")
+			return
+		}
+		fmt.Printf("[Engine] Failed to record vote: TargetID=%s not found\n", msg.TargetID)
+		engine.fail(context, ErrTargetNotFound, "Vote target not found")
+		return
+	case *FetchThread, *EditThread, *DeleteThread:
+		engine.fail(context, ErrThreadNotFound, "Thread not found")
+		return
+	case *EditReply, *DeleteReply:
+		engine.fail(context, ErrReplyNotFound, "Reply not found")
+		return
+	case *FetchRevisions:
+		engine.fail(context, ErrTargetNotFound, "Thread or reply not found")
+		return
+	}
+	engine.fail(context, ErrCommunityNotFound, "Community not found")
+}
+
+// routeMember passes a command to the shard of the member it concerns.
+func (engine *CommunityEngine) routeMember(context actor.Context, memberID string, command interface{}) {
+	shard, exists := engine.memberShards[memberID]
+	if !exists {
+		engine.fail(context, ErrMemberNotFound, "Member not found")
+		return
+	}
+	engine.pass(context, shard, &shardRequest{command: command})
+}
+
+// pass hands a message to a shard: to the shard's actor, spawned on first
+// use, when the engine is sharded and running, or else to the shard itself.
+// The shard answers the sender.
+func (engine *CommunityEngine) pass(context actor.Context, shard shardActor, message interface{}) {
+	base := shard.base()
+	if base.pid == nil && engine.sharded && running(context) {
+		base.pid = engine.spawnChild(context, shard.actorName(), shard)
+	}
+	if base.pid != nil {
+		context.RequestWithCustomSender(base.pid, message, context.Sender())
+		return
+	}
+	shard.receive(context, message)
+}
+
+// spawnChild starts the actor of a shard, or returns nil so the engine
+// applies the shard's commands itself.
+func (engine *CommunityEngine) spawnChild(context actor.Context, name string, child actor.Actor) *actor.PID {
+	pid, err := context.SpawnNamed(actor.PropsFromProducer(func() actor.Actor { return child }), name)
+	if err != nil {
+		fmt.Printf("[Engine] Failed to start actor %s: %v\n", name, err)
+		return nil
+	}
+	return pid
+}
+
+// ask puts a question to every shard given. Shards the engine still owns
+// answer at once; the answers of those running on their own actors are left
+// to futures, by index.
+func (engine *CommunityEngine) ask(context actor.Context, shards []shardActor, question interface{}) ([]interface{}, map[int]*actor.Future) {
+	answers := make([]interface{}, len(shards))
+	futures := make(map[int]*actor.Future)
+	for i, shard := range shards {
+		if pid := shard.base().pid; pid != nil && running(context) {
+			futures[i] = context.RequestFuture(pid, question, shardTimeout)
+		} else {
+			answers[i] = shard.answer(question)
+		}
+	}
+	return answers, futures
+}
+
+// await fills in the answers futures promise.
+func await(answers []interface{}, futures map[int]*actor.Future) error {
+	for i, future := range futures {
+		answer, err := future.Result()
+		if err != nil {
+			return err
+		}
+		answers[i] = answer
+	}
+	return nil
+}
+
+// askShards puts a question to every shard given and answers the sender
+// with what combine makes of their answers. When shards running on their own
+// actors are asked, combine runs on a goroutine of its own once they have
+// answered, so a busy shard does not hold the engine up.
+func (engine *CommunityEngine) askShards(context actor.Context, shards []shardActor, question interface{}, combine func(answers []interface{}) interface{}) {
+	answers, futures := engine.ask(context, shards, question)
+	if len(futures) == 0 {
+		engine.respond(context, combine(answers))
+		return
+	}
+	later := engine.answerLater(context)
+	go func() {
+		if err := await(answers, futures); err != nil {
+			fmt.Printf("[Engine] Shards did not answer %T: %v\n", question, err)
+			later.fail(ErrUnavailable, "Part of the engine did not answer")
+			return
+		}
+		later.respond(combine(answers))
+	}()
+}
+
+// stopShards stops the shards' actors once they have applied every command
+// already passed to them. The engine's actor calls it while stopping, as it
+// would otherwise stop them with commands still queued.
+func (engine *CommunityEngine) stopShards(context actor.Context) {
+	futures := make([]*actor.Future, 0)
+	for _, shard := range engine.shardList() {
+		if pid := shard.base().pid; pid != nil {
+			futures = append(futures, context.PoisonFuture(pid))
+		}
+	}
+	for _, future := range futures {
+		if err := future.Wait(); err != nil {
+			fmt.Printf("[Engine] Shard did not stop cleanly: %v\n", err)
+		}
+	}
+}
+
+// receiveNote applies a note from a shard's actor, unless the shard belonged
+// to an engine this one was rebuilt from.
+func (engine *CommunityEngine) receiveNote(context actor.Context, msg *shardNote) {
+	if msg.base != engine.engineBase {
+		return
+	}
+	engine.applyNote(context, msg.note)
+}
+
+// applyNote updates the engine's directories with a change a shard made.
+func (engine *CommunityEngine) applyNote(context actor.Context, note interface{}) {
+	switch note := note.(type) {
+	case *contentAdded:
+		engine.content[note.ID] = note.CommunityID
+	case *membershipChanged:
+		engine.changeMembership(note.MemberID, note.CommunityID, note.Joined)
+	case *tallyChanged:
+		if member, exists := engine.members[note.MemberID]; exists {
+			member.Karma += note.Karma
+			member.ThreadCount += note.Threads
+			member.ReplyCount += note.Replies
+		}
+	case *messageAdded:
+		message := note.Message
+		engine.messages[message.ID] = &messageEntry{senderID: message.SenderID, receiverID: message.ReceiverID, conversationID: message.ConversationID}
+		if sender, exists := engine.memberShards[message.SenderID]; exists {
+			engine.pass(context, sender, note)
+		}
+	case *messageRead:
+		if entry, exists := engine.messages[note.MessageID]; exists {
+			engine.pass(context, engine.memberShards[entry.senderID], note)
+		}
+	}
+}
+
+// changeMembership records that a member joined or left a community.
+func (engine *CommunityEngine) changeMembership(memberID, communityID string, joined bool) {
+	if !joined {
+		delete(engine.memberships[memberID], communityID)
+		return
+	}
+	communities, exists := engine.memberships[memberID]
+	if !exists {
+		communities = make(map[string]bool)
+		engine.memberships[memberID] = communities
+	}
+	communities[communityID] = true
+}
+
+func (shard *communityShard) Receive(context actor.Context) {
+	defer shard.answerFailure(context)
+	shard.receive(context, context.Message())
+}
+
+func (shard *communityShard) receive(context actor.Context, message interface{}) {
+	switch msg := message.(type) {
+	case *shardRequest:
+		shard.journaling(context, msg.command, func(context actor.Context) {
+			shard.apply(context, msg)
+		})
+	case *shardState, *listedThreads, *describeCommunity:
+		shard.respond(context, shard.answer(msg))
+	}
+}
+
+func (shard *communityShard) answer(question interface{}) interface{} {
+	switch question.(type) {
+	case *shardState:
+		return shard.state()
+	case *listedThreads:
+		return &FeedResult{Threads: shard.listedThreads()}
+	case *describeCommunity:
+		return viewCommunity(shard.community)
+	}
+	return nil
+}
+
+// apply runs a command against the community's state.
+func (shard *communityShard) apply(context actor.Context, request *shardRequest) {
+	switch msg := request.command.(type) {
+
+	case *CreateThread:
+		shard.createThread(context, msg, request.members)
+
+	case *FetchCommunity:
+		shard.fetchCommunity(context, msg)
+
+	case *FetchCommunityThreads:
+		shard.fetchCommunityThreads(context, msg)
+
+	case *JoinCommunity:
+		shard.joinCommunity(context, msg, request.members)
+
+	case *LeaveCommunity:
+		shard.leaveCommunity(context, msg)
+
+	case *AddModerator:
+		shard.addModerator(context, msg, request.members)
+
+	case *RemoveModerator:
+		shard.removeModerator(context, msg)
+
+	case *RemoveContent:
+		shard.removeContent(context, msg)
+
+	case *LockThread:
+		shard.lockThread(context, msg)
+
+	case *PinThread:
+		shard.pinThread(context, msg)
+
+	case *BanMember:
+		shard.banMember(context, msg, request.members)
+
+	case *FetchModLog:
+		shard.fetchModLog(context, msg)
+
+	case *CreateReply:
+		shard.createReply(context, msg, request.members)
+
+	case *CastVote:
+		shard.castVote(context, msg, request.members)
+
+	case *FetchThread:
+		shard.fetchThread(context, msg)
+
+	case *EditThread:
+		shard.editThread(context, msg)
+
+	case *EditReply:
+		shard.editReply(context, msg)
+
+	case *DeleteThread:
+		shard.deleteThread(context, msg)
+
+	case *DeleteReply:
+		shard.deleteReply(context, msg)
+
+	case *FetchRevisions:
+		shard.fetchRevisions(context, msg)
+	}
+}
+
+func (shard *memberShard) Receive(context actor.Context) {
+	defer shard.answerFailure(context)
+	shard.receive(context, context.Message())
+}
+
+func (shard *memberShard) receive(context actor.Context, message interface{}) {
+	switch msg := message.(type) {
+	case *shardRequest:
+		shard.journaling(context, msg.command, func(context actor.Context) {
+			shard.apply(context, msg)
+		})
+	case *messageAdded:
+		shard.copySent(msg.Message)
+	case *messageRead:
+		shard.markSent(msg)
+	case *shardState:
+		shard.respond(context, shard.answer(msg))
+	}
+}
+
+func (shard *memberShard) answer(question interface{}) interface{} {
+	if _, ok := question.(*shardState); ok {
+		return shard.state()
+	}
+	return nil
+}
+
+// apply runs a command against the member's messages and blocks.
+func (shard *memberShard) apply(context actor.Context, request *shardRequest) {
+	switch msg := request.command.(type) {
+
+	case *SendMessage:
+		shard.deliverMessage(context, msg)
+
+	case *FetchInbox:
+		shard.fetchInbox(context, msg)
+
+	case *FetchSent:
+		shard.fetchSent(context, msg)
+
+	case *ListConversations:
+		shard.listConversations(context, msg)
+
+	case *FetchConversation:
+		shard.fetchConversation(context, msg, request.otherID)
+
+	case *MarkMessageRead:
+		shard.markMessageRead(context, msg)
+
+	case *BlockMember:
+		shard.blockMember(context, msg, request.otherID)
+
+	case *collectMessages:
+		shard.collectMessages(context, msg)
+	}
+}
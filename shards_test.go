@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const benchmarkCommunities = 8
+
+// holdingStore holds up storing messages and threads whose content or title
+// is "Held" until release is closed.
+type holdingStore struct {
+	Store
+	release chan struct{}
+}
+
+func newHoldingStore() *holdingStore {
+	return &holdingStore{Store: NewMemoryStore(), release: make(chan struct{})}
+}
+
+func (store *holdingStore) PutMessage(message *PrivateMessage) error {
+	if message.Content == "Held" {
+		<-store.release
+	}
+	return store.Store.PutMessage(message)
+}
+
+func (store *holdingStore) PutThread(thread *Thread) error {
+	if thread.Title == "Held" {
+		<-store.release
+	}
+	return store.Store.PutThread(thread)
+}
+
+// TestMembersRunIndependently holds up a message to one member and checks
+// that other members' messages, communities and the engine go on meanwhile.
+func TestMembersRunIndependently(t *testing.T) {
+	store := newHoldingStore()
+	test := startTestEngine(t, store, true)
+	slowID := test.addMember("slow")
+	aliceID := test.addMember("alice")
+	bobID := test.addMember("bob")
+	test.createCommunity("golang", aliceID)
+
+	held := test.system.Root.RequestFuture(test.pid, &SendMessage{SenderID: aliceID, ReceiverID: slowID, Content: "Held"}, 5*time.Second)
+	func() {
+		defer close(store.release)
+		expect[*MessageDelivered](test, &SendMessage{SenderID: bobID, ReceiverID: aliceID, Content: "Hi"})
+		expect[*MessageList](test, &FetchInbox{MemberID: aliceID})
+		expect[*BlockChanged](test, &BlockMember{MemberID: bobID, BlockedID: aliceID, Blocked: true})
+		threadID := test.createThread("golang", bobID)
+		expect[*VoteRecorded](test, &CastVote{MemberID: aliceID, TargetID: threadID, IsUpvote: true})
+		test.addMember("other")
+	}()
+
+	result, err := held.Result()
+	if _, ok := result.(*MessageDelivered); err != nil || !ok {
+		t.Errorf("held message answered %#v, %v; want MessageDelivered", result, err)
+	}
+}
+
+// TestCommunitiesRunIndependently holds up a command on one community and
+// checks that other communities and the engine's own writes go on meanwhile.
+func TestCommunitiesRunIndependently(t *testing.T) {
+	for _, journaled := range []bool{false, true} {
+		store := newHoldingStore()
+		engine := newTestEngine(t, store, true)
+		if journaled {
+			journal, err := OpenJournal(t.TempDir(), defaultSnapshotInterval)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { journal.Close() })
+			if err := engine.replayJournal(journal); err != nil {
+				t.Fatal(err)
+			}
+		}
+		test := runTestEngine(t, engine)
+		memberID := test.addMember("member")
+		test.createCommunity("slow", memberID)
+		test.createCommunity("fast", memberID)
+
+		held := test.system.Root.RequestFuture(test.pid, &CreateThread{Title: "Held", CreatorID: memberID, CommunityID: "slow"}, 5*time.Second)
+		func() {
+			defer close(store.release)
+			threadID := test.createThread("fast", memberID)
+			test.createReply(threadID, "", memberID)
+			expect[*VoteRecorded](test, &CastVote{MemberID: memberID, TargetID: threadID, IsUpvote: true})
+			otherID := test.addMember("other")
+			test.createCommunity("another", otherID)
+			expect[*MembershipChanged](test, &JoinCommunity{MemberID: otherID, CommunityID: "fast"})
+			expect[*CommunityView](test, &FetchCommunity{Name: "fast"})
+		}()
+
+		result, err := held.Result()
+		if _, ok := result.(*ThreadCreated); err != nil || !ok {
+			t.Errorf("held command answered %#v, %v; want ThreadCreated", result, err)
+		}
+	}
+}
+
+// benchmarkCommunityCommands measures replies and votes sent from parallel
+// clients, each working in one of several communities. With engineWrites set,
+// another client keeps registering members meanwhile, so the engine always
+// has a write of its own in progress.
+func benchmarkCommunityCommands(b *testing.B, sharded, journaled, engineWrites bool) {
+	engine := newTestEngine(b, NewMemoryStore(), sharded)
+	if journaled {
+		journal, err := OpenJournal(b.TempDir(), 1<<30)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() { journal.Close() })
+		if err := engine.replayJournal(journal); err != nil {
+			b.Fatal(err)
+		}
+	}
+	test := runTestEngine(b, engine)
+
+	memberIDs := make([]string, benchmarkCommunities)
+	threadIDs := make([]string, benchmarkCommunities)
+	for i := range threadIDs {
+		memberIDs[i] = test.addMember(fmt.Sprintf("member%d", i))
+		name := fmt.Sprintf("community%d", i)
+		test.createCommunity(name, memberIDs[i])
+		threadIDs[i] = test.createThread(name, memberIDs[i])
+	}
+
+	stop := make(chan struct{})
+	var writer sync.WaitGroup
+	if engineWrites {
+		writer.Add(1)
+		go func() {
+			defer writer.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				test.system.Root.RequestFuture(test.pid, &addMember{Username: fmt.Sprintf("writer%d", i), PasswordHash: "test"}, 5*time.Second).Wait()
+			}
+		}()
+	}
+
+	// Each client answers for itself, since only the benchmark's own
+	// goroutine may stop it
+	send := func(message interface{}) (interface{}, bool) {
+		result, err := test.system.Root.RequestFuture(test.pid, message, 5*time.Second).Result()
+		if err != nil {
+			b.Errorf("%T: %v", message, err)
+			return nil, false
+		}
+		if failed, ok := result.(*CommandFailed); ok {
+			b.Errorf("%T failed with %s (%s)", message, failed.Code, failed.Reason)
+			return nil, false
+		}
+		return result, true
+	}
+
+	var clients atomic.Int64
+	b.SetParallelism(benchmarkCommunities)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		client := int(clients.Add(1)-1) % benchmarkCommunities
+		memberID, threadID := memberIDs[client], threadIDs[client]
+		for pb.Next() {
+			result, ok := send(&CreateReply{Content: "Reply", CreatorID: memberID, ThreadID: threadID})
+			if !ok {
+				return
+			}
+			if _, ok := send(&CastVote{MemberID: memberID, TargetID: result.(*ReplyCreated).ReplyID, IsUpvote: true}); !ok {
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	writer.Wait()
+}
+
+// runCommunityBenchmarks runs the community benchmark for one engine mode
+// against the in-memory store and the journal, with and without engine
+// writes going on.
+func runCommunityBenchmarks(b *testing.B, sharded bool) {
+	for _, journaled := range []bool{false, true} {
+		for _, engineWrites := range []bool{false, true} {
+			name := "store"
+			if journaled {
+				name = "journal"
+			}
+			if engineWrites {
+				name += "-engine-writes"
+			}
+			b.Run(name, func(b *testing.B) {
+				benchmarkCommunityCommands(b, sharded, journaled, engineWrites)
+			})
+		}
+	}
+}
+
+func BenchmarkSingleActorCommands(b *testing.B) {
+	runCommunityBenchmarks(b, false)
+}
+
+func BenchmarkShardedCommands(b *testing.B) {
+	runCommunityBenchmarks(b, true)
+}
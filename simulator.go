@@ -1,5 +1,3 @@
-
-
 package main
 
 import (
@@ -18,10 +16,17 @@ type CommunitySimulator struct {
 	members     map[string]*Member
 	communities map[string]*Community
 	threads     map[string]*Thread
+	replyIDs    map[string][]string
 	lock        sync.Mutex
 	metrics     *SimulationMetrics
+
+	// stop is closed to end the simulated activity early.
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
+const simulatorRequestTimeout = 5 * time.Second
+
 type SimulationMetrics struct {
 	StartTime          time.Time
 	MembersCreated     int
@@ -39,27 +44,68 @@ func NewCommunitySimulator(system *actor.ActorSystem, enginePID *actor.PID) *Com
 		members:     make(map[string]*Member),
 		communities: make(map[string]*Community),
 		threads:     make(map[string]*Thread),
+		replyIDs:    make(map[string][]string),
 		metrics:     &SimulationMetrics{StartTime: time.Now()},
+		stop:        make(chan struct{}),
+	}
+}
+
+// Stop ends the simulation after the activity in progress. RunSimulation
+// still prints its metrics before returning.
+func (cs *CommunitySimulator) Stop() {
+	cs.stopOnce.Do(func() {
+		close(cs.stop)
+	})
+}
+
+// request sends a command to the engine and waits for its outcome, logging
+// rejected commands so the caller only has to handle the success case.
+func (cs *CommunitySimulator) request(message interface{}) (interface{}, bool) {
+	result, err := cs.actorSystem.Root.RequestFuture(cs.enginePID, message, simulatorRequestTimeout).Result()
+	if err != nil {
+		fmt.Printf("[Simulator] Request %T failed: %v\n", message, err)
+		return nil, false
+	}
+	if failed, ok := result.(*CommandFailed); ok {
+		fmt.Printf("[Simulator] Request %T rejected: Code=%s, Reason=%s\n", message, failed.Code, failed.Reason)
+		return nil, false
+	}
+	return result, true
+}
+
+// randomMemberID picks the engine-assigned ID of a random simulated member.
+func (cs *CommunitySimulator) randomMemberID() string {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	ids := make([]string, 0, len(cs.members))
+	for _, member := range cs.members {
+		ids = append(ids, member.ID)
+	}
+	if len(ids) == 0 {
+		return ""
 	}
+	return ids[rand.Intn(len(ids))]
 }
 
 func (cs *CommunitySimulator) CreateMembers(count int) {
 	fmt.Printf("Creating %d members...\n", count)
 	for i := 0; i < count; i++ {
 		username := fmt.Sprintf("member_%d", i)
-		memberID := fmt.Sprintf("member_%d", i)
 
 		message := &RegisterMember{
 			Username: username,
 			Password: fmt.Sprintf("password_%d", i),
 		}
-		cs.actorSystem.Root.Send(cs.enginePID, message)
+		result, ok := cs.request(message)
+		if !ok {
+			continue
+		}
+		registered := result.(*MemberRegistered)
 
 		cs.lock.Lock()
 		cs.members[username] = &Member{
-			ID:       memberID,
+			ID:       registered.MemberID,
 			Username: username,
-			Password: fmt.Sprintf("password_%d", i),
 		}
 		cs.metrics.MembersCreated++
 		cs.lock.Unlock()
@@ -73,19 +119,21 @@ func (cs *CommunitySimulator) CreateCommunities(count int) {
 	fmt.Printf("Creating %d communities...\n", count)
 	for i := 0; i < count; i++ {
 		name := fmt.Sprintf("community_%d", i)
-		founderID := fmt.Sprintf("member_%d", rand.Intn(len(cs.members)))
+		founderID := cs.randomMemberID()
 
 		message := &CreateCommunity{
 			Name:        name,
 			Description: fmt.Sprintf("Description for community %d", i),
 			FounderID:   founderID,
 		}
-		cs.actorSystem.Root.Send(cs.enginePID, message)
+		if _, ok := cs.request(message); !ok {
+			continue
+		}
 
 		cs.lock.Lock()
 		cs.communities[name] = &Community{
-			Name:        name,
-			Description: fmt.Sprintf("Description for community %d", i),
+			Name:         name,
+			Description:  fmt.Sprintf("Description for community %d", i),
 			Participants: make(map[string]bool),
 			Threads:      make([]*Thread, 0),
 		}
@@ -97,6 +145,39 @@ func (cs *CommunitySimulator) CreateCommunities(count int) {
 	fmt.Printf("Total communities created: %d\n", len(cs.communities))
 }
 
+// JoinCommunities has every member join a random subset of communities,
+// favouring the popular ones. Picking the same community twice is expected,
+// so already_member rejections are not logged.
+func (cs *CommunitySimulator) JoinCommunities() {
+	fmt.Println("Joining members to communities...")
+	communityNames := make([]string, 0, len(cs.communities))
+	for name := range cs.communities {
+		communityNames = append(communityNames, name)
+	}
+	if len(communityNames) == 0 {
+		return
+	}
+
+	joins := 0
+	for _, member := range cs.members {
+		for i := 0; i < 1+rand.Intn(len(communityNames)); i++ {
+			communityName := communityNames[cs.getZipfIndex(len(communityNames))]
+			message := &JoinCommunity{MemberID: member.ID, CommunityID: communityName}
+			result, err := cs.actorSystem.Root.RequestFuture(cs.enginePID, message, simulatorRequestTimeout).Result()
+			if err != nil {
+				continue
+			}
+			if _, ok := result.(*MembershipChanged); ok {
+				cs.lock.Lock()
+				cs.communities[communityName].Participants[member.ID] = true
+				cs.lock.Unlock()
+				joins++
+			}
+		}
+	}
+	fmt.Printf("Total memberships created: %d\n", joins)
+}
+
 func (cs *CommunitySimulator) CreateThreads(count int) {
 	fmt.Printf("Creating %d actors...\n", count)
 	communityNames := make([]string, 0, len(cs.communities))
@@ -113,20 +194,24 @@ func (cs *CommunitySimulator) CreateThreads(count int) {
 		communityIndex := cs.getZipfIndex(len(communityNames))
 		communityName := communityNames[communityIndex]
 
-		creatorID := fmt.Sprintf("member_%d", rand.Intn(len(cs.members)))
-		threadID := fmt.Sprintf("actor_%d", i)
+		creatorID := cs.randomMemberID()
 
-		thread := &Thread{
-			ID:          threadID,
+		message := &CreateThread{
 			Title:       fmt.Sprintf("Actor Title %d", i),
 			Content:     fmt.Sprintf("Actor Content %d", i),
 			CreatorID:   creatorID,
 			CommunityID: communityName,
 		}
+		result, ok := cs.request(message)
+		if !ok {
+			continue
+		}
+		threadID := result.(*ThreadCreated).ThreadID
 
-		message := &CreateThread{
-			Title:       thread.Title,
-			Content:     thread.Content,
+		thread := &Thread{
+			ID:          threadID,
+			Title:       message.Title,
+			Content:     message.Content,
 			CreatorID:   creatorID,
 			CommunityID: communityName,
 		}
@@ -137,14 +222,13 @@ func (cs *CommunitySimulator) CreateThreads(count int) {
 		cs.metrics.ThreadsCreated++
 		cs.lock.Unlock()
 
-		cs.actorSystem.Root.Send(cs.enginePID, message)
 		time.Sleep(20 * time.Millisecond)
 	}
 	fmt.Printf("Total Actors created: %d\n", len(cs.threads))
 }
 
 func (cs *CommunitySimulator) SimulateActivity() {
-	memberID := fmt.Sprintf("member_%d", rand.Intn(len(cs.members)))
+	memberID := cs.randomMemberID()
 	threadIDs := make([]string, 0, len(cs.threads))
 	for threadID := range cs.threads {
 		threadIDs = append(threadIDs, threadID)
@@ -159,26 +243,33 @@ func (cs *CommunitySimulator) SimulateActivity() {
 				CreatorID: memberID,
 				ThreadID:  threadID,
 			}
-			cs.actorSystem.Root.Send(cs.enginePID, message)
-			cs.metrics.RepliesSubmitted++
+			// Half of the replies answer an earlier reply to build up nesting
+			if existing := cs.replyIDs[threadID]; len(existing) > 0 && rand.Float32() < 0.5 {
+				message.ParentID = existing[rand.Intn(len(existing))]
+			}
+			if result, ok := cs.request(message); ok {
+				cs.replyIDs[threadID] = append(cs.replyIDs[threadID], result.(*ReplyCreated).ReplyID)
+				cs.metrics.RepliesSubmitted++
+			}
 		} else {
 			message := &CastVote{
 				MemberID: memberID,
 				TargetID: threadID,
 				IsUpvote: rand.Float32() > 0.5,
 			}
-			cs.actorSystem.Root.Send(cs.enginePID, message)
-			cs.metrics.VotesCast++
+			if _, ok := cs.request(message); ok {
+				cs.metrics.VotesCast++
+			}
 		}
 	}
 }
 
+// getZipfIndex picks an index in [0, size), favouring the lowest ones.
 func (cs *CommunitySimulator) getZipfIndex(size int) int {
 	x := rand.Float64()
-	return int(math.Floor(math.Pow(float64(size), x)))
+	return int(math.Floor(math.Pow(float64(size+1), x))) - 1
 }
 
-
 func (cs *CommunitySimulator) DisplayMetrics() {
 	duration := time.Since(cs.metrics.StartTime)
 	fmt.Println("\n[Simulator] Simulation Metrics:")
@@ -193,17 +284,22 @@ func (cs *CommunitySimulator) DisplayMetrics() {
 	fmt.Println("[Simulator] Simulation completed successfully.")
 }
 
-
-
 func (cs *CommunitySimulator) RunSimulation(members, communities, threads int, duration time.Duration) {
 	cs.CreateMembers(members)
 	cs.CreateCommunities(communities)
+	cs.JoinCommunities()
 	cs.CreateThreads(threads)
 
 	start := time.Now()
+activity:
 	for time.Since(start) < duration {
 		cs.SimulateActivity()
-		time.Sleep(1 * time.Second)
+		select {
+		case <-cs.stop:
+			fmt.Println("[Simulator] Stopped early.")
+			break activity
+		case <-time.After(1 * time.Second):
+		}
 	}
 
 	cs.DisplayMetrics()
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestGetZipfIndex(t *testing.T) {
+	cs := &CommunitySimulator{}
+	for _, size := range []int{1, 2, 3, 10, 100} {
+		seen := make(map[int]bool)
+		for i := 0; i < 10000; i++ {
+			index := cs.getZipfIndex(size)
+			if index < 0 || index >= size {
+				t.Fatalf("getZipfIndex(%d) = %d, want an index in [0, %d)", size, index, size)
+			}
+			seen[index] = true
+		}
+		if !seen[0] {
+			t.Errorf("getZipfIndex(%d) never picked index 0", size)
+		}
+	}
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+// Store persists the engine's records. The engine keeps its working state in
+// its own maps, writes every change through to the store and rebuilds those
+// maps from Load at startup. Sessions are not stored, so members log in
+// again after a restart.
+type Store interface {
+	Load() (*Snapshot, error)
+	PutMember(member *Member) error
+	PutCommunity(community *Community) error
+	PutModLogEntry(record *ModLogRecord) error
+	PutThread(thread *Thread) error
+	PutReply(reply *Reply) error
+	PutVote(vote *Vote) error
+	PutMessage(message *PrivateMessage) error
+	PutBlock(block *Block) error
+	Close() error
+}
+
+// Vote is one member's vote on a thread or reply. A zero Value removes it.
+type Vote struct {
+	TargetID string
+	MemberID string
+	Value    int
+}
+
+// ModLogRecord stores one entry of a community's moderation log, the
+// Index-th, on its own, so logging an action does not rewrite the community
+// with its whole log. Stored communities leave their log out.
+type ModLogRecord struct {
+	CommunityID string
+	Index       int
+	*ModLogEntry
+}
+
+// Block records that MemberID does not accept messages from BlockedID. A
+// false Blocked removes it.
+type Block struct {
+	MemberID  string
+	BlockedID string
+	Blocked   bool
+}
+
+// Snapshot holds every stored record, each kind ordered by ID and therefore
+// by creation time. Communities come without their threads and threads
+// without their replies; the engine links them up again. Communities do come
+// with their moderation logs, which Load puts back together.
+type Snapshot struct {
+	Members     []*Member
+	Communities []*Community
+	Threads     []*Thread
+	Replies     []*Reply
+	Votes       []*Vote
+	Messages    []*PrivateMessage
+	Blocks      []*Block
+}
+
+// Record kinds, used as keys by the stores.
+const (
+	recordMember    = "member"
+	recordCommunity = "community"
+	recordModLog    = "modlog"
+	recordThread    = "thread"
+	recordReply     = "reply"
+	recordVote      = "vote"
+	recordMessage   = "message"
+	recordBlock     = "block"
+)
+
+type recordKey struct {
+	Kind string
+	ID   string
+}
+
+// storedMember carries the password hash, which Member leaves out of JSON.
+type storedMember struct {
+	*Member
+	PasswordHash string
+}
+
+// encodeRecord turns a Put argument into its key and JSON form. A nil value
+// means the record is deleted.
+func encodeRecord(record interface{}) (recordKey, json.RawMessage, error) {
+	var key recordKey
+	var value interface{}
+	switch record := record.(type) {
+	case *Member:
+		key = recordKey{recordMember, record.ID}
+		value = &storedMember{Member: record, PasswordHash: record.PasswordHash}
+	case *Community:
+		stored := *record
+		stored.Threads = nil
+		stored.ModLog = nil
+		key, value = recordKey{recordCommunity, record.Name}, &stored
+	case *ModLogRecord:
+		key, value = modLogKey(record.CommunityID, record.Index), record
+	case *Thread:
+		stored := *record
+		stored.Replies = nil
+		key, value = recordKey{recordThread, record.ID}, &stored
+	case *Reply:
+		stored := *record
+		stored.Replies = nil
+		key, value = recordKey{recordReply, record.ID}, &stored
+	case *Vote:
+		key = recordKey{recordVote, record.TargetID + "/" + record.MemberID}
+		if record.Value != noVote {
+			value = record
+		}
+	case *PrivateMessage:
+		key, value = recordKey{recordMessage, record.ID}, record
+	case *Block:
+		key = recordKey{recordBlock, record.MemberID + "/" + record.BlockedID}
+		if record.Blocked {
+			value = record
+		}
+	default:
+		return key, nil, fmt.Errorf("cannot store %T", record)
+	}
+	if value == nil {
+		return key, nil, nil
+	}
+	data, err := json.Marshal(value)
+	return key, data, err
+}
+
+// decodeSnapshot rebuilds records from their JSON forms.
+func decodeSnapshot(records map[recordKey]json.RawMessage) (*Snapshot, error) {
+	keys := make([]recordKey, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	snapshot := &Snapshot{}
+	var modLog []*ModLogRecord
+	for _, key := range keys {
+		data := records[key]
+		var err error
+		switch key.Kind {
+		case recordMember:
+			stored := storedMember{Member: &Member{}}
+			if err = json.Unmarshal(data, &stored); err == nil {
+				stored.Member.PasswordHash = stored.PasswordHash
+				snapshot.Members = append(snapshot.Members, stored.Member)
+			}
+		case recordCommunity:
+			snapshot.Communities, err = appendDecoded(snapshot.Communities, data)
+		case recordModLog:
+			modLog, err = appendDecoded(modLog, data)
+		case recordThread:
+			snapshot.Threads, err = appendDecoded(snapshot.Threads, data)
+		case recordReply:
+			snapshot.Replies, err = appendDecoded(snapshot.Replies, data)
+		case recordVote:
+			snapshot.Votes, err = appendDecoded(snapshot.Votes, data)
+		case recordMessage:
+			snapshot.Messages, err = appendDecoded(snapshot.Messages, data)
+		case recordBlock:
+			snapshot.Blocks, err = appendDecoded(snapshot.Blocks, data)
+		default:
+			err = fmt.Errorf("unknown record kind %q", key.Kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", key.Kind, key.ID, err)
+		}
+	}
+
+	// Keys order each community's entries by index
+	communities := make(map[string]*Community, len(snapshot.Communities))
+	for _, community := range snapshot.Communities {
+		communities[community.Name] = community
+	}
+	for _, record := range modLog {
+		community, exists := communities[record.CommunityID]
+		if !exists || record.ModLogEntry == nil {
+			return nil, fmt.Errorf("%s %s: community not found", recordModLog, modLogKey(record.CommunityID, record.Index).ID)
+		}
+		community.ModLog = append(community.ModLog, record.ModLogEntry)
+	}
+	return snapshot, nil
+}
+
+// modLogKey pads the index so keys sort in log order.
+func modLogKey(communityID string, index int) recordKey {
+	return recordKey{recordModLog, fmt.Sprintf("%s/%010d", communityID, index)}
+}
+
+// splitModLogs moves the moderation logs that older versions stored inside
+// community records out into records of their own.
+func splitModLogs(records map[recordKey]json.RawMessage) error {
+	for key, data := range records {
+		if key.Kind != recordCommunity {
+			continue
+		}
+		community := &Community{}
+		if err := json.Unmarshal(data, community); err != nil {
+			return fmt.Errorf("%s %s: %w", key.Kind, key.ID, err)
+		}
+		if len(community.ModLog) == 0 {
+			continue
+		}
+		for index, entry := range community.ModLog {
+			entryKey, entryData, err := encodeRecord(&ModLogRecord{CommunityID: community.Name, Index: index, ModLogEntry: entry})
+			if err != nil {
+				return err
+			}
+			records[entryKey] = entryData
+		}
+		_, communityData, err := encodeRecord(community)
+		if err != nil {
+			return err
+		}
+		records[key] = communityData
+	}
+	return nil
+}
+
+// MarshalJSON keeps the members' password hashes, which Member leaves out.
+func (snapshot *Snapshot) MarshalJSON() ([]byte, error) {
+	type plain Snapshot
+	members := make([]*storedMember, len(snapshot.Members))
+	for i, member := range snapshot.Members {
+		members[i] = &storedMember{Member: member, PasswordHash: member.PasswordHash}
+	}
+	return json.Marshal(&struct {
+		Members []*storedMember
+		*plain
+	}{members, (*plain)(snapshot)})
+}
+
+func (snapshot *Snapshot) UnmarshalJSON(data []byte) error {
+	type plain Snapshot
+	var decoded struct {
+		Members []*storedMember
+		*plain
+	}
+	decoded.plain = (*plain)(snapshot)
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	snapshot.Members = make([]*Member, 0, len(decoded.Members))
+	for _, stored := range decoded.Members {
+		if stored.Member == nil {
+			return fmt.Errorf("empty member record")
+		}
+		stored.Member.PasswordHash = stored.PasswordHash
+		snapshot.Members = append(snapshot.Members, stored.Member)
+	}
+	return nil
+}
+
+func appendDecoded[T any](records []*T, data json.RawMessage) ([]*T, error) {
+	record := new(T)
+	if err := json.Unmarshal(data, record); err != nil {
+		return records, err
+	}
+	return append(records, record), nil
+}
+
+// MemoryStore keeps records in memory only. It is the default for
+// development and simulations, where nothing needs to survive a restart.
+type MemoryStore struct {
+	records map[recordKey]json.RawMessage
+	lock    sync.Mutex
+
+	// persist, if set, is called with every change before it is applied.
+	persist func(key recordKey, data json.RawMessage) error
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[recordKey]json.RawMessage)}
+}
+
+func (store *MemoryStore) put(record interface{}) error {
+	key, data, err := encodeRecord(record)
+	if err != nil {
+		return err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.persist != nil {
+		if err := store.persist(key, data); err != nil {
+			return err
+		}
+	}
+	if data == nil {
+		delete(store.records, key)
+	} else {
+		store.records[key] = data
+	}
+	return nil
+}
+
+func (store *MemoryStore) Load() (*Snapshot, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return decodeSnapshot(store.records)
+}
+
+func (store *MemoryStore) PutMember(member *Member) error {
+	return store.put(member)
+}
+
+func (store *MemoryStore) PutCommunity(community *Community) error {
+	return store.put(community)
+}
+
+func (store *MemoryStore) PutModLogEntry(record *ModLogRecord) error {
+	return store.put(record)
+}
+
+func (store *MemoryStore) PutThread(thread *Thread) error {
+	return store.put(thread)
+}
+
+func (store *MemoryStore) PutReply(reply *Reply) error {
+	return store.put(reply)
+}
+
+func (store *MemoryStore) PutVote(vote *Vote) error {
+	return store.put(vote)
+}
+
+func (store *MemoryStore) PutMessage(message *PrivateMessage) error {
+	return store.put(message)
+}
+
+func (store *MemoryStore) PutBlock(block *Block) error {
+	return store.put(block)
+}
+
+func (store *MemoryStore) Close() error {
+	return nil
+}
+
+// OpenStore opens the store named by kind: "memory", or "file" backed by the
+// log at path.
+func OpenStore(kind, path string) (Store, error) {
+	switch kind {
+	case "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		return OpenFileStore(path)
+	}
+	return nil, fmt.Errorf("unknown store %q", kind)
+}
+
+// restore rebuilds the engine's maps from its store. It runs before the
+// engine receives any message.
+func (engine *CommunityEngine) restore() error {
+	snapshot, err := engine.store.Load()
+	if err != nil {
+		return err
+	}
+	return engine.load(snapshot)
+}
+
+// load adds the records in snapshot to the engine's directories and shards,
+// linking threads to their communities and replies to their parents.
+func (engine *CommunityEngine) load(snapshot *Snapshot) error {
+	tallyMembers(snapshot)
+	for _, member := range snapshot.Members {
+		engine.members[member.ID] = member
+		engine.memberShards[member.ID] = newMemberShard(engine.engineBase, member.ID)
+		engine.usernames[strings.ToLower(member.Username)] = member.ID
+	}
+	for _, community := range snapshot.Communities {
+		if community.Moderators == nil {
+			community.Moderators = make(map[string]bool)
+		}
+		if community.Banned == nil {
+			community.Banned = make(map[string]bool)
+		}
+		if community.Participants == nil {
+			community.Participants = make(map[string]bool)
+		}
+		community.Threads = make([]*Thread, 0)
+		engine.communities[strings.ToLower(community.Name)] = newCommunityShard(engine.engineBase, community)
+		for memberID := range community.Participants {
+			engine.changeMembership(memberID, community.Name, true)
+		}
+	}
+	for _, thread := range snapshot.Threads {
+		shard, exists := engine.communityShard(thread.CommunityID)
+		if !exists {
+			return fmt.Errorf("thread %s: community %s not found", thread.ID, thread.CommunityID)
+		}
+		thread.Replies = make([]*Reply, 0)
+		shard.threads[thread.ID] = thread
+		shard.community.Threads = append(shard.community.Threads, thread)
+		engine.content[thread.ID] = shard.name
+	}
+	// Replies are ordered by ID, so parents come before their children
+	for _, reply := range snapshot.Replies {
+		shard, exists := engine.communityShard(engine.content[reply.ThreadID])
+		if !exists {
+			return fmt.Errorf("reply %s: thread %s not found", reply.ID, reply.ThreadID)
+		}
+		reply.Replies = make([]*Reply, 0)
+		if reply.ParentID == "" {
+			thread := shard.threads[reply.ThreadID]
+			thread.Replies = append(thread.Replies, reply)
+		} else {
+			parent, exists := shard.replies[reply.ParentID]
+			if !exists {
+				return fmt.Errorf("reply %s: parent %s not found", reply.ID, reply.ParentID)
+			}
+			parent.Replies = append(parent.Replies, reply)
+		}
+		shard.replies[reply.ID] = reply
+		engine.content[reply.ID] = shard.name
+	}
+	for _, vote := range snapshot.Votes {
+		shard, exists := engine.communityShard(engine.content[vote.TargetID])
+		if !exists {
+			return fmt.Errorf("vote by %s: target %s not found", vote.MemberID, vote.TargetID)
+		}
+		if shard.votes[vote.TargetID] == nil {
+			shard.votes[vote.TargetID] = make(map[string]int)
+		}
+		shard.votes[vote.TargetID][vote.MemberID] = vote.Value
+	}
+	for _, message := range snapshot.Messages {
+		sender, exists := engine.memberShards[message.SenderID]
+		if !exists {
+			return fmt.Errorf("message %s: sender %s not found", message.ID, message.SenderID)
+		}
+		receiver, exists := engine.memberShards[message.ReceiverID]
+		if !exists {
+			return fmt.Errorf("message %s: receiver %s not found", message.ID, message.ReceiverID)
+		}
+		receiver.inbox = append(receiver.inbox, message)
+		receiver.conversations[message.ConversationID] = append(receiver.conversations[message.ConversationID], message)
+		copied := *message
+		sender.copySent(&copied)
+		engine.messages[message.ID] = &messageEntry{senderID: message.SenderID, receiverID: message.ReceiverID, conversationID: message.ConversationID}
+	}
+	for _, block := range snapshot.Blocks {
+		shard, exists := engine.memberShards[block.MemberID]
+		if !exists {
+			return fmt.Errorf("block by %s: member not found", block.MemberID)
+		}
+		shard.blocked[block.BlockedID] = true
+	}
+	fmt.Printf("[Engine] Restored %d members, %d communities, %d threads, %d replies, %d messages\n",
+		len(snapshot.Members), len(snapshot.Communities), len(snapshot.Threads), len(snapshot.Replies), len(snapshot.Messages))
+	return nil
+}
+
+// tallyMembers works out every member's karma and counts from the threads,
+// replies and votes in snapshot. They change with content in any community,
+// so they are derived rather than stored: members are stored as they
+// registered. Votes on your own content earn no karma.
+func tallyMembers(snapshot *Snapshot) {
+	members := make(map[string]*Member, len(snapshot.Members))
+	for _, member := range snapshot.Members {
+		member.Karma, member.ThreadCount, member.ReplyCount = 0, 0, 0
+		members[member.ID] = member
+	}
+	creators := make(map[string]string, len(snapshot.Threads)+len(snapshot.Replies))
+	for _, thread := range snapshot.Threads {
+		creators[thread.ID] = thread.CreatorID
+		if member, exists := members[thread.CreatorID]; exists {
+			member.ThreadCount++
+		}
+	}
+	for _, reply := range snapshot.Replies {
+		creators[reply.ID] = reply.CreatorID
+		if member, exists := members[reply.CreatorID]; exists {
+			member.ReplyCount++
+		}
+	}
+	for _, vote := range snapshot.Votes {
+		creatorID := creators[vote.TargetID]
+		if member, exists := members[creatorID]; exists && creatorID != vote.MemberID {
+			member.Karma += vote.Value
+		}
+	}
+}
+
+// snapshot copies every record the engine holds, ordered as Store.Load
+// orders them. Sessions are left out, as they are from the store. Each shard
+// copies its own records; the engine waits for all of them, so no command
+// reaches a shard meanwhile. Without a context, while the engine's actor is
+// not running, the shards are copied directly.
+func (engine *CommunityEngine) snapshot(context actor.Context) (*Snapshot, error) {
+	answers, futures := engine.ask(context, engine.shardList(), &shardState{})
+	if err := await(answers, futures); err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{}
+	for _, member := range engine.members {
+		copied := *member
+		snapshot.Members = append(snapshot.Members, &copied)
+	}
+	for _, answer := range answers {
+		part := answer.(*Snapshot)
+		snapshot.Communities = append(snapshot.Communities, part.Communities...)
+		snapshot.Threads = append(snapshot.Threads, part.Threads...)
+		snapshot.Replies = append(snapshot.Replies, part.Replies...)
+		snapshot.Votes = append(snapshot.Votes, part.Votes...)
+		snapshot.Messages = append(snapshot.Messages, part.Messages...)
+		snapshot.Blocks = append(snapshot.Blocks, part.Blocks...)
+	}
+	tallyMembers(snapshot)
+
+	sort.Slice(snapshot.Members, func(i, j int) bool { return snapshot.Members[i].ID < snapshot.Members[j].ID })
+	sort.Slice(snapshot.Communities, func(i, j int) bool { return snapshot.Communities[i].Name < snapshot.Communities[j].Name })
+	sort.Slice(snapshot.Threads, func(i, j int) bool { return snapshot.Threads[i].ID < snapshot.Threads[j].ID })
+	sort.Slice(snapshot.Replies, func(i, j int) bool { return snapshot.Replies[i].ID < snapshot.Replies[j].ID })
+	sort.Slice(snapshot.Votes, func(i, j int) bool {
+		a, b := snapshot.Votes[i], snapshot.Votes[j]
+		return a.TargetID < b.TargetID || (a.TargetID == b.TargetID && a.MemberID < b.MemberID)
+	})
+	sort.Slice(snapshot.Messages, func(i, j int) bool { return snapshot.Messages[i].ID < snapshot.Messages[j].ID })
+	sort.Slice(snapshot.Blocks, func(i, j int) bool {
+		a, b := snapshot.Blocks[i], snapshot.Blocks[j]
+		return a.MemberID < b.MemberID || (a.MemberID == b.MemberID && a.BlockedID < b.BlockedID)
+	})
+	return snapshot, nil
+}
+
+// state copies the community's records for a snapshot.
+func (shard *communityShard) state() *Snapshot {
+	community := shard.community
+	copied := *community
+	copied.Threads = nil
+	copied.Moderators = copyFlags(community.Moderators)
+	copied.Banned = copyFlags(community.Banned)
+	copied.Participants = copyFlags(community.Participants)
+	copied.ModLog = append([]*ModLogEntry(nil), community.ModLog...)
+	state := &Snapshot{Communities: []*Community{&copied}}
+	for _, thread := range shard.threads {
+		copied := *thread
+		copied.Replies = nil
+		copied.History = append([]*Revision(nil), copied.History...)
+		state.Threads = append(state.Threads, &copied)
+	}
+	for _, reply := range shard.replies {
+		copied := *reply
+		copied.Replies = nil
+		copied.History = append([]*Revision(nil), copied.History...)
+		state.Replies = append(state.Replies, &copied)
+	}
+	for targetID, votes := range shard.votes {
+		for memberID, value := range votes {
+			if value != noVote {
+				state.Votes = append(state.Votes, &Vote{TargetID: targetID, MemberID: memberID, Value: value})
+			}
+		}
+	}
+	return state
+}
+
+// state copies the member's records for a snapshot. Every message is in
+// exactly one inbox.
+func (shard *memberShard) state() *Snapshot {
+	state := &Snapshot{}
+	for _, message := range shard.inbox {
+		copied := *message
+		state.Messages = append(state.Messages, &copied)
+	}
+	for blockedID := range shard.blocked {
+		state.Blocks = append(state.Blocks, &Block{MemberID: shard.memberID, BlockedID: blockedID, Blocked: true})
+	}
+	return state
+}
+
+func copyFlags(flags map[string]bool) map[string]bool {
+	copied := make(map[string]bool, len(flags))
+	for key, value := range flags {
+		copied[key] = value
+	}
+	return copied
+}
+
+// withFlag copies flags with key set or cleared, so a change can be stored
+// before it is made.
+func withFlag(flags map[string]bool, key string, set bool) map[string]bool {
+	copied := copyFlags(flags)
+	if set {
+		copied[key] = true
+	} else {
+		delete(copied, key)
+	}
+	return copied
+}
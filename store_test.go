@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStore counts the community and moderation log writes.
+type countingStore struct {
+	Store
+	communities atomic.Int64
+	modLog      atomic.Int64
+}
+
+func (store *countingStore) PutCommunity(community *Community) error {
+	store.communities.Add(1)
+	return store.Store.PutCommunity(community)
+}
+
+func (store *countingStore) PutModLogEntry(record *ModLogRecord) error {
+	store.modLog.Add(1)
+	return store.Store.PutModLogEntry(record)
+}
+
+func TestModActionsStoreOnlyTheirEntry(t *testing.T) {
+	store := &countingStore{Store: NewMemoryStore()}
+	test := startTestEngine(t, store, false)
+	ownerID := test.addMember("owner")
+	test.createCommunity("golang", ownerID)
+	threadID := test.createThread("golang", ownerID)
+	written := store.communities.Load()
+
+	const actions = 10
+	for i := 0; i < actions; i++ {
+		expect[*ModActionRecorded](test, &LockThread{ModeratorID: ownerID, CommunityID: "golang", ThreadID: threadID, Locked: i%2 == 0})
+	}
+	if rewritten := store.communities.Load() - written; rewritten != 0 {
+		t.Errorf("%d mod actions rewrote the community %d times", actions, rewritten)
+	}
+	if entries := store.modLog.Load(); entries != actions {
+		t.Errorf("%d mod actions stored %d log entries", actions, entries)
+	}
+}
+
+// TestFailedWritesChangeNothing checks that commands the store cannot take
+// are rejected and leave the engine, and the store, as they were.
+func TestFailedWritesChangeNothing(t *testing.T) {
+	var failing atomic.Bool
+	store := NewMemoryStore()
+	store.persist = func(key recordKey, data json.RawMessage) error {
+		if failing.Load() {
+			return errors.New("disk full")
+		}
+		return nil
+	}
+	test := startTestEngine(t, store, true)
+	aliceID := test.addMember("alice")
+	bobID := test.addMember("bob")
+	test.createCommunity("golang", aliceID)
+	threadID := test.createThread("golang", aliceID)
+
+	views := func(test *testEngine) string {
+		data, _ := json.Marshal([]interface{}{
+			expect[*ThreadTree](test, &FetchThread{ThreadID: threadID}),
+			expect[*MemberProfile](test, &FetchProfile{MemberID: aliceID}),
+			expect[*MemberProfile](test, &FetchProfile{MemberID: bobID}),
+			expect[*CommunityView](test, &FetchCommunity{Name: "golang"}),
+			expect[*ModLog](test, &FetchModLog{ModeratorID: aliceID, CommunityID: "golang"}),
+			expect[*MessageList](test, &FetchInbox{MemberID: bobID}),
+		})
+		return string(data)
+	}
+	want := views(test)
+	failing.Store(true)
+	for _, command := range []interface{}{
+		&CastVote{MemberID: bobID, TargetID: threadID, IsUpvote: true},
+		&CreateReply{Content: "Reply", CreatorID: bobID, ThreadID: threadID},
+		&JoinCommunity{MemberID: bobID, CommunityID: "golang"},
+		&LockThread{ModeratorID: aliceID, CommunityID: "golang", ThreadID: threadID, Locked: true},
+		&EditThread{EditorID: aliceID, ThreadID: threadID, Title: "Edited"},
+		&SendMessage{SenderID: aliceID, ReceiverID: bobID, Content: "Hi"},
+	} {
+		expectFailure(test, command, ErrUnavailable)
+	}
+	failing.Store(false)
+	if got := views(test); got != want {
+		t.Errorf("after failed writes the engine serves %s, want %s", got, want)
+	}
+	if got := views(startTestEngine(t, store, true)); got != want {
+		t.Errorf("after failed writes the store restores %s, want %s", got, want)
+	}
+}
+
+func TestModLogSurvivesRestore(t *testing.T) {
+	store := NewMemoryStore()
+	test := startTestEngine(t, store, false)
+	ownerID := test.addMember("owner")
+	memberID := test.addMember("member")
+	test.createCommunity("golang", ownerID)
+	expect[*ModActionRecorded](test, &AddModerator{ModeratorID: ownerID, CommunityID: "golang", MemberID: memberID})
+	expect[*ModActionRecorded](test, &RemoveModerator{ModeratorID: ownerID, CommunityID: "golang", MemberID: memberID})
+	expect[*ModActionRecorded](test, &BanMember{ModeratorID: ownerID, CommunityID: "golang", MemberID: memberID, Banned: true, Reason: "spam"})
+	want := expect[*ModLog](test, &FetchModLog{ModeratorID: ownerID, CommunityID: "golang"})
+
+	restored := startTestEngine(t, store, false)
+	got := expect[*ModLog](restored, &FetchModLog{ModeratorID: ownerID, CommunityID: "golang"})
+	// Compared as JSON, which drops the monotonic clock readings
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	if len(got.Entries) != 3 || string(gotJSON) != string(wantJSON) {
+		t.Errorf("restored mod log %s, want %s", gotJSON, wantJSON)
+	}
+	community := expect[*CommunityView](restored, &FetchCommunity{Name: "golang"})
+	if !reflect.DeepEqual(community.Moderators, []string{ownerID}) {
+		t.Errorf("restored moderators %v, want only the owner", community.Moderators)
+	}
+	expectFailure(restored, &CreateThread{Title: "T", CreatorID: memberID, CommunityID: "golang"}, ErrBanned)
+}
+
+func TestFileStoreSplitsEmbeddedModLogs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "community.log")
+	createdAt := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	legacy := &Community{
+		Name:       "golang",
+		OwnerID:    "u_owner",
+		Moderators: map[string]bool{"u_owner": true},
+		ModLog: []*ModLogEntry{
+			{ModeratorID: "u_owner", Action: ModActionBan, TargetID: "u_a", CreatedAt: createdAt},
+			{ModeratorID: "u_owner", Action: ModActionUnban, TargetID: "u_a", CreatedAt: createdAt},
+		},
+	}
+	data, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, _ := json.Marshal(&logEntry{Kind: recordCommunity, ID: legacy.Name, Data: data})
+	if err := os.WriteFile(path, append(line, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Storing the community again must not lose the log it used to carry
+	community := *legacy
+	community.Description = "changed"
+	if err := store.PutCommunity(&community); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutModLogEntry(&ModLogRecord{CommunityID: "golang", Index: 2, ModLogEntry: &ModLogEntry{ModeratorID: "u_owner", Action: ModActionBan, TargetID: "u_b", CreatedAt: createdAt}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	snapshot, err := reopened.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Communities) != 1 {
+		t.Fatalf("loaded %d communities, want 1", len(snapshot.Communities))
+	}
+	loaded := snapshot.Communities[0]
+	var targets []string
+	for _, entry := range loaded.ModLog {
+		targets = append(targets, entry.Action+" "+entry.TargetID)
+	}
+	want := []string{"ban u_a", "unban u_a", "ban u_b"}
+	if loaded.Description != "changed" || !reflect.DeepEqual(targets, want) {
+		t.Errorf("loaded %q with log %v, want %q with %v", loaded.Description, targets, "changed", want)
+	}
+}
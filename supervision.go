@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+// The engine is restarted after a failure at most engineRestartLimit times
+// within engineRestartWindow; one more failure stops it for good.
+const (
+	engineRestartLimit  = 10
+	engineRestartWindow = time.Minute
+)
+
+// engineHost produces the engine actor. Its first incarnation is the engine
+// restored at startup. A failed command may leave the engine's or a shard's
+// maps half changed, so every later incarnation is a new engine rebuilt from
+// the store or the journal, with new shards.
+type engineHost struct {
+	engine   *CommunityEngine
+	started  bool
+	restarts int
+	lock     sync.Mutex
+}
+
+func newEngineHost(engine *CommunityEngine) *engineHost {
+	return &engineHost{engine: engine}
+}
+
+// current is the engine of the actor's latest incarnation.
+func (host *engineHost) current() *CommunityEngine {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	return host.engine
+}
+
+// props describes the engine actor. Its guardian restarts it when it fails.
+// A community's or member's actor failing is escalated to the engine, since
+// the engine's directories may already hold notes of the failed command.
+func (host *engineHost) props() *actor.Props {
+	engineSupervisor := actor.NewOneForOneStrategy(engineRestartLimit, engineRestartWindow, actor.DefaultDecider)
+	communitySupervisor := actor.NewOneForOneStrategy(0, 0, func(reason interface{}) actor.Directive {
+		return actor.EscalateDirective
+	})
+	return actor.PropsFromProducer(host.produce,
+		actor.WithGuardian(engineSupervisor),
+		actor.WithSupervisor(communitySupervisor))
+}
+
+// restartCount is how many times the engine has been rebuilt.
+func (host *engineHost) restartCount() int {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	return host.restarts
+}
+
+func (host *engineHost) produce() actor.Actor {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	if !host.started {
+		host.started = true
+		return host.engine
+	}
+	host.restarts++
+	engine, err := host.engine.rebuild()
+	if err != nil {
+		fmt.Printf("[Supervisor] Failed to rebuild the engine, keeping its state as it is: %v\n", err)
+		return host.engine
+	}
+	host.engine = engine
+	fmt.Printf("[Supervisor] Engine rebuilt after failure %d\n", host.restarts)
+	return engine
+}
+
+// rebuild makes a new engine holding the state this one had before its
+// failed command. The failed command discarded its own event, so replay
+// leaves it out.
+func (engine *CommunityEngine) rebuild() (*CommunityEngine, error) {
+	rebuilt := NewCommunityEngine(engine.idGenerator, engine.store)
+	rebuilt.sharded = engine.sharded
+	rebuilt.background = engine.background
+	if engine.journal == nil {
+		if err := rebuilt.restore(); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := engine.journal.rewind(); err != nil {
+			return nil, err
+		}
+		if err := rebuilt.replayJournal(engine.journal); err != nil {
+			return nil, err
+		}
+	}
+
+	// Sessions are kept in memory only, and survive the failure
+	for key, session := range engine.sessions {
+		rebuilt.sessions[key] = session
+	}
+	return rebuilt, nil
+}
+
+// answerFailure tells the sender its command failed before the panic reaches
+// the supervisor, so the request does not wait out its timeout. It must be
+// deferred directly.
+func (engine *engineBase) answerFailure(context actor.Context) {
+	if reason := recover(); reason != nil {
+		fmt.Printf("[Engine] Failed handling %T: %v\n", context.Message(), reason)
+		engine.fail(context, ErrUnavailable, "The engine failed and is restarting")
+		panic(reason)
+	}
+}
+
+// monitorActors logs supervision decisions and messages sent to actors that
+// no longer exist, and returns the count of the latter.
+func monitorActors(system *actor.ActorSystem) *atomic.Int64 {
+	deadLetters := &atomic.Int64{}
+	system.EventStream.Subscribe(func(event interface{}) {
+		switch event := event.(type) {
+		case *actor.SupervisorEvent:
+			fmt.Printf("[Supervisor] %s failed (%v): %s\n", event.Child, event.Reason, event.Directive)
+		case *actor.DeadLetterEvent:
+			count := deadLetters.Add(1)
+			fmt.Printf("[Supervisor] Dead letter %d: %T to %s\n", count, event.Message, event.PID)
+		}
+	})
+	return deadLetters
+}
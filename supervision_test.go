@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingStore panics once while storing a thread with the given title, after
+// the command creating it has been journaled.
+type failingStore struct {
+	Store
+	title string
+	armed atomic.Bool
+}
+
+func newFailingStore(title string) *failingStore {
+	store := &failingStore{Store: NewMemoryStore(), title: title}
+	store.armed.Store(true)
+	return store
+}
+
+func (store *failingStore) PutThread(thread *Thread) error {
+	if thread.Title == store.title && store.armed.CompareAndSwap(true, false) {
+		panic("injected failure storing " + thread.ID)
+	}
+	return store.Store.PutThread(thread)
+}
+
+// checkRebuiltState asserts the engine kept the member and thread created
+// before the failure, dropped the thread that failed and still takes
+// commands on the community that failed.
+func checkRebuiltState(test *testEngine, memberID, threadID string) {
+	test.t.Helper()
+	// The failed command is answered before its actor fails, so the restart
+	// may still be under way
+	deadline := time.Now().Add(5 * time.Second)
+	for test.host.restartCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if restarts := test.host.restartCount(); restarts != 1 {
+		test.t.Fatalf("engine restarted %d times, want 1", restarts)
+	}
+	profile := expect[*MemberProfile](test, &FetchProfile{MemberID: memberID})
+	if profile.ThreadCount != 1 {
+		test.t.Errorf("member has %d threads, want 1", profile.ThreadCount)
+	}
+	expect[*ThreadTree](test, &FetchThread{ThreadID: threadID})
+	community := expect[*CommunityView](test, &FetchCommunity{Name: "golang"})
+	if community.ThreadCount != 1 {
+		test.t.Errorf("community has %d threads, want 1", community.ThreadCount)
+	}
+	test.createThread("golang", memberID)
+}
+
+func TestEngineRebuildsFromStoreAfterFailure(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		test := startTestEngine(t, newFailingStore("fail"), sharded)
+		memberID := test.addMember("member")
+		test.createCommunity("golang", memberID)
+		threadID := test.createThread("golang", memberID)
+
+		expectFailure(test, &CreateThread{Title: "fail", CreatorID: memberID, CommunityID: "golang"}, ErrUnavailable)
+		checkRebuiltState(test, memberID, threadID)
+	}
+}
+
+func TestEngineRebuildsFromJournalAfterFailure(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		directory := t.TempDir()
+		journal, err := OpenJournal(directory, defaultSnapshotInterval)
+		if err != nil {
+			t.Fatal(err)
+		}
+		engine := newTestEngine(t, newFailingStore("fail"), sharded)
+		if err := engine.replayJournal(journal); err != nil {
+			t.Fatal(err)
+		}
+		test := runTestEngine(t, engine)
+		memberID := test.addMember("member")
+		test.createCommunity("golang", memberID)
+		threadID := test.createThread("golang", memberID)
+
+		expectFailure(test, &CreateThread{Title: "fail", CreatorID: memberID, CommunityID: "golang"}, ErrUnavailable)
+		checkRebuiltState(test, memberID, threadID)
+		test.system.Root.PoisonFuture(test.pid).Wait()
+		if err := journal.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The failed command was discarded, so a later start leaves it out
+		// as well
+		journal, err = OpenJournal(directory, defaultSnapshotInterval)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restarted := newTestEngine(t, NewMemoryStore(), sharded)
+		if err := restarted.replayJournal(journal); err != nil {
+			t.Fatal(err)
+		}
+		shard, _ := restarted.communityShard("golang")
+		community := shard.community
+		if len(community.Threads) != 2 || community.Threads[0].ID != threadID {
+			t.Errorf("replayed community has threads %v, want %s and the one created after the failure", community.Threads, threadID)
+		}
+		for _, thread := range community.Threads {
+			if thread.Title == "fail" {
+				t.Errorf("replay applied the failed thread %s", thread.ID)
+			}
+		}
+		journal.Close()
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+// Vote directions stored per member in communityShard.votes.
+const (
+	noVote   = 0
+	upvote   = 1
+	downvote = -1
+)
+
+// voteTarget is the part of a Thread or Reply that voting touches.
+type voteTarget struct {
+	creatorID string
+	upvotes   *int
+	downvotes *int
+	// save stores the target with the tallies given.
+	save func(store Store, upvotes, downvotes int) error
+}
+
+// resolveVoteTarget looks the target ID up as a thread first, then as a reply.
+func (shard *communityShard) resolveVoteTarget(targetID string) (*voteTarget, bool) {
+	if thread, exists := shard.threads[targetID]; exists {
+		return &voteTarget{
+			creatorID: thread.CreatorID,
+			upvotes:   &thread.Upvotes,
+			downvotes: &thread.Downvotes,
+			save: func(store Store, upvotes, downvotes int) error {
+				saved := *thread
+				saved.Upvotes, saved.Downvotes = upvotes, downvotes
+				return store.PutThread(&saved)
+			},
+		}, true
+	}
+	if reply, exists := shard.replies[targetID]; exists {
+		return &voteTarget{
+			creatorID: reply.CreatorID,
+			upvotes:   &reply.Upvotes,
+			downvotes: &reply.Downvotes,
+			save: func(store Store, upvotes, downvotes int) error {
+				saved := *reply
+				saved.Upvotes, saved.Downvotes = upvotes, downvotes
+				return store.PutReply(&saved)
+			},
+		}, true
+	}
+	return nil, false
+}
+
+// tally returns the target's tallies once a single member's vote moves from
+// one direction to another.
+func (target *voteTarget) tally(previous, next int) (upvotes, downvotes int) {
+	upvotes, downvotes = *target.upvotes, *target.downvotes
+	switch previous {
+	case upvote:
+		upvotes--
+	case downvote:
+		downvotes--
+	}
+	switch next {
+	case upvote:
+		upvotes++
+	case downvote:
+		downvotes++
+	}
+	return upvotes, downvotes
+}
+
+func (shard *communityShard) castVote(context actor.Context, msg *CastVote, members map[string]bool) {
+	if !members[msg.MemberID] {
+		fmt.Printf("[Engine] Failed to record vote: MemberID=%s not found\n", msg.MemberID)
+		shard.fail(context, ErrMemberNotFound, "Member not found")
+		return
+	}
+	target, exists := shard.resolveVoteTarget(msg.TargetID)
+	if !exists {
+		fmt.Printf("[Engine] Failed to record vote: TargetID=%s not found\n", msg.TargetID)
+		shard.fail(context, ErrTargetNotFound, "Vote target not found")
+		return
+	}
+
+	next := downvote
+	if msg.Retract {
+		next = noVote
+	} else if msg.IsUpvote {
+		next = upvote
+	}
+
+	if shard.community.Banned[msg.MemberID] {
+		shard.fail(context, ErrBanned, "Member is banned from this community")
+		return
+	}
+	if !shard.accept(context, msg) {
+		return
+	}
+	previous := shard.votes[msg.TargetID][msg.MemberID]
+	upvotes, downvotes := target.tally(previous, next)
+	if !shard.persist(context, shard.store.PutVote(&Vote{TargetID: msg.TargetID, MemberID: msg.MemberID, Value: next})) ||
+		!shard.persist(context, target.save(shard.store, upvotes, downvotes)) {
+		return
+	}
+	voters, exists := shard.votes[msg.TargetID]
+	if !exists {
+		voters = make(map[string]int)
+		shard.votes[msg.TargetID] = voters
+	}
+	if next == noVote {
+		delete(voters, msg.MemberID)
+	} else {
+		voters[msg.MemberID] = next
+	}
+	*target.upvotes, *target.downvotes = upvotes, downvotes
+	// Votes on your own content count towards its score but not your karma
+	if target.creatorID != msg.MemberID && next != previous {
+		shard.notify(context, &tallyChanged{MemberID: target.creatorID, Karma: next - previous})
+	}
+	recorded := &VoteRecorded{
+		TargetID:  msg.TargetID,
+		MemberID:  msg.MemberID,
+		IsUpvote:  next == upvote,
+		Retracted: next == noVote,
+		Upvotes:   upvotes,
+		Downvotes: downvotes,
+	}
+
+	switch next {
+	case upvote:
+		fmt.Printf("[Engine] Upvote recorded: TargetID=%s, MemberID=%s\n", msg.TargetID, msg.MemberID)
+	case downvote:
+		fmt.Printf("[Engine] Downvote recorded: TargetID=%s, MemberID=%s\n", msg.TargetID, msg.MemberID)
+	default:
+		fmt.Printf("[Engine] Vote retracted: TargetID=%s, MemberID=%s\n", msg.TargetID, msg.MemberID)
+	}
+	shard.respond(context, recorded)
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestCastVote(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		test := startTestEngine(t, NewMemoryStore(), sharded)
+		ownerID := test.addMember("owner")
+		voterID := test.addMember("voter")
+		bannedID := test.addMember("banned")
+		test.createCommunity("golang", ownerID)
+		threadID := test.createThread("golang", ownerID)
+		replyID := test.createReply(threadID, "", ownerID)
+		expect[*ModActionRecorded](test, &BanMember{ModeratorID: ownerID, CommunityID: "golang", MemberID: bannedID, Banned: true})
+
+		tests := []struct {
+			name          string
+			vote          *CastVote
+			code          ErrorCode
+			up, down      int
+			karma         int
+			wantRetracted bool
+		}{
+			{name: "unknown member", vote: &CastVote{MemberID: MemberIDPrefix + "missing", TargetID: threadID, IsUpvote: true}, code: ErrMemberNotFound},
+			{name: "unknown target", vote: &CastVote{MemberID: voterID, TargetID: ThreadIDPrefix + "missing", IsUpvote: true}, code: ErrTargetNotFound},
+			{name: "banned member on thread", vote: &CastVote{MemberID: bannedID, TargetID: threadID, IsUpvote: true}, code: ErrBanned},
+			{name: "banned member on reply", vote: &CastVote{MemberID: bannedID, TargetID: replyID}, code: ErrBanned},
+			{name: "upvote", vote: &CastVote{MemberID: voterID, TargetID: threadID, IsUpvote: true}, up: 1, karma: 1},
+			{name: "repeated upvote", vote: &CastVote{MemberID: voterID, TargetID: threadID, IsUpvote: true}, up: 1, karma: 1},
+			{name: "switch to downvote", vote: &CastVote{MemberID: voterID, TargetID: threadID}, down: 1, karma: -1},
+			{name: "retract", vote: &CastVote{MemberID: voterID, TargetID: threadID, Retract: true}, karma: 0, wantRetracted: true},
+			{name: "author upvotes their own reply", vote: &CastVote{MemberID: ownerID, TargetID: replyID, IsUpvote: true}, up: 1, karma: 0},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				test := test.in(t)
+				if tc.code != "" {
+					expectFailure(test, tc.vote, tc.code)
+					return
+				}
+				recorded := expect[*VoteRecorded](test, tc.vote)
+				if recorded.Upvotes != tc.up || recorded.Downvotes != tc.down || recorded.Retracted != tc.wantRetracted {
+					t.Errorf("recorded %+v, want %d up, %d down, retracted %v", recorded, tc.up, tc.down, tc.wantRetracted)
+				}
+				if karma := expect[*MemberProfile](test, &FetchProfile{MemberID: ownerID}).Karma; karma != tc.karma {
+					t.Errorf("author karma %d, want %d", karma, tc.karma)
+				}
+			})
+		}
+	}
+}